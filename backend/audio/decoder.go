@@ -11,7 +11,7 @@ import (
 const (
 	FilenameLengthBytes        = 4
 	DataLengthBytes            = 4
-	MaximumFilenameBytesLength = 50
+	MaximumFilenameBytesLength = 255 // Default filename limit of the stego payload
 	BitsInByte                 = 8
 )
 
@@ -25,7 +25,7 @@ func NewAudioDecoder() *AudioDecoder {
 func (ad *AudioDecoder) AnalyzeMP3(mp3Data []byte) (*MP3Info, error) {
 	mp3File, err := mp3parser.ParseMP3File(mp3Data)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse MP3: %v", err)
+		return nil, fmt.Errorf("failed to parse MP3: %w", err)
 	}
 
 	if len(mp3File.Frames) == 0 {
@@ -41,7 +41,7 @@ func (ad *AudioDecoder) AnalyzeMP3(mp3Data []byte) (*MP3Info, error) {
 		totalDataBytes += len(frame.Data)
 	}
 
-	return &MP3Info{
+	info := &MP3Info{
 		Bitrate:        firstFrame.Header.Bitrate,
 		SampleRate:     firstFrame.Header.SampleRate,
 		ChannelMode:    firstFrame.Header.ChannelMode,
@@ -49,7 +49,20 @@ func (ad *AudioDecoder) AnalyzeMP3(mp3Data []byte) (*MP3Info, error) {
 		TotalDataBytes: totalDataBytes,
 		HasID3v1:       mp3File.ID3v1 != nil,
 		HasID3v2:       mp3File.ID3v2 != nil,
-	}, nil
+		HasInfoFrame:   mp3File.Xing != nil,
+		SkippedBytes:   mp3File.SkippedBytes,
+		SkippedRegions: mp3File.SkippedRegions,
+
+		UnanalyzableFrames: mp3parser.CountUnanalyzableFrames(mp3File),
+		Duration:           mp3parser.EstimateDuration(mp3File),
+	}
+
+	if mp3File.Xing != nil && mp3File.Xing.HasLAMETag {
+		info.EncoderDelay = mp3File.Xing.EncoderDelay
+		info.EncoderPadding = mp3File.Xing.EncoderPadding
+	}
+
+	return info, nil
 }
 
 // DecodeMP3ToPCM decodes MP3 data to PCM for PSNR calculation
@@ -84,6 +97,15 @@ type MP3Info struct {
 	TotalDataBytes int
 	HasID3v1       bool
 	HasID3v2       bool
+	HasInfoFrame   bool
+	EncoderDelay   int // Gapless playback delay from the LAME tag
+	EncoderPadding int // Gapless playback padding from the LAME tag
+	SkippedBytes   int // Bytes skipped while resyncing to frame headers
+	SkippedRegions int // Contiguous regions of skipped bytes
+
+	UnanalyzableFrames int // Audio frames whose regions could not be determined
+
+	Duration float64 // Seconds, estimated from the frame headers
 }
 
 func (ad *AudioDecoder) CalculateMaxSecretLength(pcmData []byte, lsbBits int) int {
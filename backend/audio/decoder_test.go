@@ -0,0 +1,40 @@
+package audio
+
+import (
+	"math"
+	"os"
+	"testing"
+)
+
+func TestEstimatedDurationMatchesDecoded(t *testing.T) {
+	tests := []string{
+		"../../test_cases/file_example_MP3_700KB.mp3",
+		"../../test_cases/Billie Eilish - WILDFLOWER (Official Lyric Video).mp3",
+	}
+
+	decoder := NewAudioDecoder()
+	for _, path := range tests {
+		t.Run(path, func(t *testing.T) {
+			mp3Data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			info, err := decoder.AnalyzeMP3(mp3Data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, metadata, err := decoder.DecodeMP3ToPCM(mp3Data)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The estimate tracks a plain decode to within one frame: the
+			// decoder outputs the Info frame as silence, the estimate skips it
+			tolerance := 1152 / float64(info.SampleRate)
+			if info.Duration <= 0 || math.Abs(info.Duration-metadata.Duration) > tolerance+1e-9 {
+				t.Errorf("estimated %.4fs, decoded %.4fs, want within %.4fs", info.Duration, metadata.Duration, tolerance)
+			}
+		})
+	}
+}
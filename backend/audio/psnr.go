@@ -2,9 +2,16 @@
 package audio
 
 import (
+	"fmt"
 	"math"
+
+	"steganography-backend/mp3parser"
 )
 
+// MaxAlignableSamples bounds how many trailing samples may be trimmed when
+// aligning two decodes (a few frames of decoder delay/flush differences)
+const MaxAlignableSamples = 4 * 1152 * 2
+
 func CalculatePSNR(original, stego []byte) float64 {
 	if len(original) != len(stego) {
 		return 0.0
@@ -35,9 +42,11 @@ func CalculatePSNR(original, stego []byte) float64 {
 	return psnr
 }
 
-// CalculatePSNRFloat64 calculates PSNR for float64 audio samples
+// CalculatePSNRFloat64 calculates PSNR for float64 audio samples. Buffers of
+// different lengths cannot be compared and yield 0.0; use AlignPCMSamples first.
 func CalculatePSNRFloat64(original, stego []float64) float64 {
 	if len(original) != len(stego) {
+		fmt.Printf("Warning: PSNR sample count mismatch (original %d, stego %d), returning 0.0\n", len(original), len(stego))
 		return 0.0
 	}
 
@@ -66,9 +75,215 @@ func CalculatePSNRFloat64(original, stego []float64) float64 {
 	return psnr
 }
 
+// CalculatePSNRForChannelMode calculates PSNR for interleaved samples
+// according to the MPEG channel mode. Dual channel streams carry two
+// independent mono programs, so each channel is compared on its own and the
+// worse PSNR is returned; every other mode is compared as a whole.
+func CalculatePSNRForChannelMode(original, stego []float64, channels, channelMode int) float64 {
+	if channelMode != mp3parser.ChannelModeDualChannel || channels < 2 {
+		return CalculatePSNRFloat64(original, stego)
+	}
+
+	psnr := math.Inf(1)
+	for ch := 0; ch < channels; ch++ {
+		channelPSNR := CalculatePSNRFloat64(channelSamples(original, channels, ch), channelSamples(stego, channels, ch))
+		psnr = math.Min(psnr, channelPSNR)
+	}
+	return psnr
+}
+
 func ValidatePSNR(psnr float64, threshold float64) bool {
 	if math.IsInf(psnr, 1) {
 		return true // Infinite PSNR is always good
 	}
 	return psnr >= threshold
 }
+
+// Minimum PSNR (dB) for each quality grade; anything lower is graded F
+const (
+	GradeAThreshold = 80.0 // Inaudible, typical of ancillary embedding
+	GradeBThreshold = 60.0
+	GradeCThreshold = 45.0
+	GradeDThreshold = 30.0
+)
+
+// QualityGrade translates a PSNR into a letter grade from A (transparent) to
+// F (clearly audible) for non-expert users. Identical audio (infinite PSNR)
+// grades A.
+func QualityGrade(psnr float64) string {
+	switch {
+	case psnr >= GradeAThreshold:
+		return "A"
+	case psnr >= GradeBThreshold:
+		return "B"
+	case psnr >= GradeCThreshold:
+		return "C"
+	case psnr >= GradeDThreshold:
+		return "D"
+	default:
+		return "F"
+	}
+}
+
+// AlignPCMSamples makes two interleaved sample buffers comparable and returns
+// them with their common channel count. A channel count mismatch (e.g. the
+// decoder upmixing one file) is resolved by downmixing both to mono, except
+// for dual channel streams whose two programs are unrelated: those compare
+// the first channel only. Small length differences are trimmed to the
+// shorter buffer. Larger differences are reported as an error rather than
+// being turned into a misleading PSNR.
+func AlignPCMSamples(original []float64, originalChannels int, stego []float64, stegoChannels int, channelMode int) ([]float64, []float64, int, error) {
+	return alignPCMSamples(original, originalChannels, stego, stegoChannels, channelMode, MaxAlignableSamples)
+}
+
+// AlignPCMSamplesToShorter aligns like AlignPCMSamples but trims any length
+// difference to the shorter buffer, for comparing files whose frame counts
+// differ (e.g. one was cut short) over their common region
+func AlignPCMSamplesToShorter(original []float64, originalChannels int, stego []float64, stegoChannels int, channelMode int) ([]float64, []float64, int, error) {
+	return alignPCMSamples(original, originalChannels, stego, stegoChannels, channelMode, -1)
+}
+
+// alignPCMSamples aligns channels and trims up to maxTrim samples, any amount
+// when maxTrim is negative
+func alignPCMSamples(original []float64, originalChannels int, stego []float64, stegoChannels int, channelMode int, maxTrim int) ([]float64, []float64, int, error) {
+	channels := originalChannels
+	if originalChannels != stegoChannels {
+		if channelMode == mp3parser.ChannelModeDualChannel {
+			fmt.Printf("Warning: PSNR channel count mismatch (original %d, stego %d), comparing the first dual channel\n", originalChannels, stegoChannels)
+			original = channelSamples(original, originalChannels, 0)
+			stego = channelSamples(stego, stegoChannels, 0)
+		} else {
+			fmt.Printf("Warning: PSNR channel count mismatch (original %d, stego %d), comparing mono downmix\n", originalChannels, stegoChannels)
+			original = downmixToMono(original, originalChannels)
+			stego = downmixToMono(stego, stegoChannels)
+		}
+		channels = 1
+	}
+
+	if len(original) == len(stego) {
+		return original, stego, channels, nil
+	}
+
+	diff := len(original) - len(stego)
+	if diff < 0 {
+		diff = -diff
+	}
+	if maxTrim >= 0 && diff > maxTrim {
+		return nil, nil, 0, fmt.Errorf("sample count mismatch too large to align: original %d, stego %d", len(original), len(stego))
+	}
+
+	// Trim to whole sample frames so channels stay interleaved in step
+	n := min(len(original), len(stego))
+	n -= n % max(channels, 1)
+	fmt.Printf("Warning: PSNR sample count mismatch (original %d, stego %d), trimming to %d\n", len(original), len(stego), n)
+	return original[:n], stego[:n], channels, nil
+}
+
+// channelSamples returns the samples of one channel of an interleaved buffer
+func channelSamples(samples []float64, channels, channel int) []float64 {
+	if channels <= 1 {
+		return samples
+	}
+
+	single := make([]float64, len(samples)/channels)
+	for i := range single {
+		single[i] = samples[i*channels+channel]
+	}
+	return single
+}
+
+func downmixToMono(samples []float64, channels int) []float64 {
+	if channels <= 1 {
+		return samples
+	}
+
+	mono := make([]float64, len(samples)/channels)
+	for i := range mono {
+		var sum float64
+		for ch := 0; ch < channels; ch++ {
+			sum += samples[i*channels+ch]
+		}
+		mono[i] = sum / float64(channels)
+	}
+	return mono
+}
+
+// Policies for a trailing odd byte in 16-bit PCM, which holds only half a sample
+const (
+	OddBytesTruncate = "truncate" // Drop the incomplete trailing sample (default)
+	OddBytesPad      = "pad"      // Complete the trailing sample with a zero high byte
+)
+
+// ValidateOddBytePolicy checks that policy is a known odd-byte policy
+func ValidateOddBytePolicy(policy string) error {
+	switch policy {
+	case OddBytesTruncate, OddBytesPad:
+		return nil
+	default:
+		return fmt.Errorf("unknown odd byte policy: %q", policy)
+	}
+}
+
+// AlignPCMBytes brings both 16-bit PCM buffers to one even target length
+// using the policy: truncate cuts both to the shorter length rounded down to
+// a whole sample, pad zero-fills both to the longer length rounded up. A
+// buffer with a stray trailing byte so converts to the same whole samples as
+// its counterpart instead of gaining or losing one. Buffers that differ by
+// more than that stray byte hold different streams (another frame or channel
+// count); padding them to a common length would invent audio, so each is
+// only made even and AlignPCMSamples reconciles them. Call it before BytesToFloat64
+// whenever the two buffers are compared.
+func AlignPCMBytes(original, stego []byte, policy string) ([]byte, []byte) {
+	if diff := len(original) - len(stego); diff < -1 || diff > 1 {
+		return evenPCMBytes(original, policy), evenPCMBytes(stego, policy)
+	}
+
+	target := min(len(original), len(stego))
+	target -= target % 2
+	if policy == OddBytesPad {
+		target = max(len(original), len(stego))
+		target += target % 2
+	}
+	return resizePCMBytes(original, target), resizePCMBytes(stego, target)
+}
+
+func evenPCMBytes(data []byte, policy string) []byte {
+	if len(data)%2 == 0 {
+		return data
+	}
+
+	if policy == OddBytesPad {
+		return resizePCMBytes(data, len(data)+1)
+	}
+	return data[:len(data)-1]
+}
+
+// resizePCMBytes truncates data to n bytes or zero-pads it up to n
+func resizePCMBytes(data []byte, n int) []byte {
+	if len(data) >= n {
+		return data[:n]
+	}
+
+	padded := make([]byte, n)
+	copy(padded, data)
+	return padded
+}
+
+// BytesToFloat64 converts 16-bit little-endian PCM to samples normalized to
+// [-1.0, 1.0]. A trailing odd byte is dropped; use AlignPCMBytes first to
+// choose how it is handled when comparing buffers.
+func BytesToFloat64(data []byte) []float64 {
+	data = evenPCMBytes(data, OddBytesTruncate)
+
+	samples := make([]float64, len(data)/2)
+	for i := range samples {
+		// Read little-endian 16-bit sample
+		low := int16(data[i*2])
+		high := int16(data[i*2+1])
+		sample := low | (high << 8)
+
+		// Convert to float64 normalized to [-1.0, 1.0]
+		samples[i] = float64(sample) / 32768.0
+	}
+	return samples
+}
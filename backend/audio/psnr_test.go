@@ -0,0 +1,181 @@
+package audio
+
+import (
+	"bytes"
+	"math"
+	"slices"
+	"strings"
+	"testing"
+
+	"steganography-backend/mp3parser"
+)
+
+// ramp returns n samples rising from 0 in steps of 1/1024
+func ramp(n int) []float64 {
+	samples := make([]float64, n)
+	for i := range samples {
+		samples[i] = float64(i%1024) / 1024
+	}
+	return samples
+}
+
+func TestAlignPCMSamplesTrimsToShorter(t *testing.T) {
+	tests := []struct {
+		name                            string
+		originalLen, stegoLen           int
+		originalChannels, stegoChannels int
+		wantLen, wantChannels           int
+		wantErr                         bool
+	}{
+		{name: "same length", originalLen: 4608, stegoLen: 4608, originalChannels: 2, stegoChannels: 2, wantLen: 4608, wantChannels: 2},
+		{name: "stego shorter", originalLen: 4608, stegoLen: 2304, originalChannels: 2, stegoChannels: 2, wantLen: 2304, wantChannels: 2},
+		{name: "original shorter", originalLen: 2304, stegoLen: 4608, originalChannels: 2, stegoChannels: 2, wantLen: 2304, wantChannels: 2},
+		// A trailing half sample frame is dropped so channels stay in step
+		{name: "odd sample count", originalLen: 4608, stegoLen: 4607, originalChannels: 2, stegoChannels: 2, wantLen: 4606, wantChannels: 2},
+		{name: "largest trim", originalLen: MaxAlignableSamples + 2, stegoLen: 2, originalChannels: 2, stegoChannels: 2, wantLen: 2, wantChannels: 2},
+		{name: "mono upmixed", originalLen: 2304, stegoLen: 4608, originalChannels: 1, stegoChannels: 2, wantLen: 2304, wantChannels: 1},
+		{name: "too far apart", originalLen: MaxAlignableSamples + 3, stegoLen: 2, originalChannels: 2, stegoChannels: 2, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			original, stego := ramp(tt.originalLen), ramp(tt.stegoLen)
+			if tt.stegoChannels > tt.originalChannels {
+				// The stego decode repeats every original sample on each channel
+				stego = stego[:0]
+				for _, sample := range original {
+					stego = append(stego, sample, sample)
+				}
+			}
+			alignedOriginal, alignedStego, channels, err := AlignPCMSamples(original, tt.originalChannels, stego, tt.stegoChannels, mp3parser.ChannelModeStereo)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected a mismatch error")
+				}
+				if !strings.Contains(err.Error(), "sample count mismatch") {
+					t.Errorf("err = %v, want a sample count mismatch", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(alignedOriginal) != tt.wantLen || len(alignedStego) != tt.wantLen || channels != tt.wantChannels {
+				t.Errorf("aligned to %d/%d samples of %d channels, want %d of %d",
+					len(alignedOriginal), len(alignedStego), channels, tt.wantLen, tt.wantChannels)
+			}
+			// Both decodes hold the same audio, so the common region matches
+			if psnr := CalculatePSNRFloat64(alignedOriginal, alignedStego); !math.IsInf(psnr, 1) {
+				t.Errorf("PSNR = %v, want +Inf", psnr)
+			}
+		})
+	}
+
+	t.Run("unaligned lengths are not compared", func(t *testing.T) {
+		if psnr := CalculatePSNRFloat64(ramp(4608), ramp(2304)); psnr != 0 {
+			t.Errorf("PSNR = %v, want 0", psnr)
+		}
+	})
+}
+
+func TestAlignPCMBytesOddLength(t *testing.T) {
+	tests := []struct {
+		name         string
+		original     []byte
+		stego        []byte
+		policy       string
+		wantOriginal []byte
+		wantStego    []byte
+	}{
+		{name: "truncate odd stego", original: []byte{1, 2, 3, 4}, stego: []byte{1, 2, 3}, policy: OddBytesTruncate, wantOriginal: []byte{1, 2}, wantStego: []byte{1, 2}},
+		{name: "truncate odd original", original: []byte{1, 2, 3}, stego: []byte{1, 2, 3, 4}, policy: OddBytesTruncate, wantOriginal: []byte{1, 2}, wantStego: []byte{1, 2}},
+		{name: "truncate both odd", original: []byte{1, 2, 3}, stego: []byte{1, 2, 5}, policy: OddBytesTruncate, wantOriginal: []byte{1, 2}, wantStego: []byte{1, 2}},
+		{name: "pad odd stego", original: []byte{1, 2, 3, 4}, stego: []byte{1, 2, 3}, policy: OddBytesPad, wantOriginal: []byte{1, 2, 3, 4}, wantStego: []byte{1, 2, 3, 0}},
+		{name: "pad odd original", original: []byte{1, 2, 3}, stego: []byte{1, 2, 3, 4}, policy: OddBytesPad, wantOriginal: []byte{1, 2, 3, 0}, wantStego: []byte{1, 2, 3, 4}},
+		{name: "pad both odd", original: []byte{1, 2, 3}, stego: []byte{1, 2, 5}, policy: OddBytesPad, wantOriginal: []byte{1, 2, 3, 0}, wantStego: []byte{1, 2, 5, 0}},
+		// Lengths further apart are only made even, never padded to each other
+		{name: "truncate different streams", original: []byte{1, 2, 3, 4, 5, 6, 7}, stego: []byte{1, 2, 3}, policy: OddBytesTruncate, wantOriginal: []byte{1, 2, 3, 4, 5, 6}, wantStego: []byte{1, 2}},
+		{name: "pad different streams", original: []byte{1, 2, 3, 4, 5, 6, 7}, stego: []byte{1, 2, 3}, policy: OddBytesPad, wantOriginal: []byte{1, 2, 3, 4, 5, 6, 7, 0}, wantStego: []byte{1, 2, 3, 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			original, stego := AlignPCMBytes(tt.original, tt.stego, tt.policy)
+			if !bytes.Equal(original, tt.wantOriginal) || !bytes.Equal(stego, tt.wantStego) {
+				t.Errorf("aligned to %v and %v, want %v and %v", original, stego, tt.wantOriginal, tt.wantStego)
+			}
+			if len(BytesToFloat64(original)) != len(original)/2 || len(BytesToFloat64(stego)) != len(stego)/2 {
+				t.Error("aligned buffers do not convert to whole samples")
+			}
+		})
+	}
+
+	if err := ValidateOddBytePolicy("round"); err == nil {
+		t.Error("expected an unknown policy to be rejected")
+	}
+}
+
+func TestCalculatePSNRForChannelMode(t *testing.T) {
+	// Interleaved stereo where only the right channel is changed
+	original := ramp(2048)
+	stego := slices.Clone(original)
+	for i := 1; i < len(stego); i += 2 {
+		stego[i] += 0.01
+	}
+
+	left := CalculatePSNRFloat64(channelSamples(original, 2, 0), channelSamples(stego, 2, 0))
+	right := CalculatePSNRFloat64(channelSamples(original, 2, 1), channelSamples(stego, 2, 1))
+	whole := CalculatePSNRFloat64(original, stego)
+	if !math.IsInf(left, 1) || math.IsInf(right, 1) || right >= whole {
+		t.Fatalf("unexpected per-channel PSNR: left %v, right %v, whole %v", left, right, whole)
+	}
+
+	tests := []struct {
+		name        string
+		channels    int
+		channelMode int
+		want        float64
+	}{
+		{name: "stereo", channels: 2, channelMode: mp3parser.ChannelModeStereo, want: whole},
+		{name: "joint stereo", channels: 2, channelMode: mp3parser.ChannelModeJointStereo, want: whole},
+		// The two programs are unrelated, so the worse one is reported
+		{name: "dual channel", channels: 2, channelMode: mp3parser.ChannelModeDualChannel, want: right},
+		{name: "mono", channels: 1, channelMode: mp3parser.ChannelModeMono, want: whole},
+		{name: "dual channel decoded as mono", channels: 1, channelMode: mp3parser.ChannelModeDualChannel, want: whole},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if psnr := CalculatePSNRForChannelMode(original, stego, tt.channels, tt.channelMode); psnr != tt.want {
+				t.Errorf("PSNR = %v, want %v", psnr, tt.want)
+			}
+		})
+	}
+}
+
+func TestQualityGrade(t *testing.T) {
+	const epsilon = 1e-9
+
+	tests := []struct {
+		psnr float64
+		want string
+	}{
+		{psnr: math.Inf(1), want: "A"},
+		{psnr: GradeAThreshold + epsilon, want: "A"},
+		{psnr: GradeAThreshold, want: "A"},
+		{psnr: GradeAThreshold - epsilon, want: "B"},
+		{psnr: GradeBThreshold, want: "B"},
+		{psnr: GradeBThreshold - epsilon, want: "C"},
+		{psnr: GradeCThreshold, want: "C"},
+		{psnr: GradeCThreshold - epsilon, want: "D"},
+		{psnr: GradeDThreshold, want: "D"},
+		{psnr: GradeDThreshold - epsilon, want: "F"},
+		{psnr: 0, want: "F"},
+	}
+
+	for _, tt := range tests {
+		if got := QualityGrade(tt.psnr); got != tt.want {
+			t.Errorf("QualityGrade(%v) = %s, want %s", tt.psnr, got, tt.want)
+		}
+	}
+}
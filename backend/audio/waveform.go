@@ -0,0 +1,98 @@
+package audio
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+)
+
+const (
+	DefaultWaveformWidth  = 600
+	DefaultWaveformHeight = 120
+	MaxWaveformWidth      = 4096
+	MaxWaveformHeight     = 1024
+)
+
+var (
+	waveformBackground = color.RGBA{R: 0xFF, G: 0xFF, B: 0xFF, A: 0xFF}
+	waveformForeground = color.RGBA{R: 0x25, G: 0x63, B: 0xEB, A: 0xFF}
+)
+
+// RenderWaveformPNG renders the min/max envelope of 16-bit little-endian PCM
+// as a PNG image. Samples of all channels are folded into the same envelope.
+func RenderWaveformPNG(pcmData []byte, width, height int) ([]byte, error) {
+	if width < 1 || width > MaxWaveformWidth {
+		return nil, fmt.Errorf("width must be between 1 and %d", MaxWaveformWidth)
+	}
+	if height < 1 || height > MaxWaveformHeight {
+		return nil, fmt.Errorf("height must be between 1 and %d", MaxWaveformHeight)
+	}
+
+	samples := bytesToInt16(pcmData)
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.SetRGBA(x, y, waveformBackground)
+		}
+	}
+
+	if len(samples) > 0 {
+		for x := 0; x < width; x++ {
+			start := x * len(samples) / width
+			end := (x + 1) * len(samples) / width
+			if end <= start {
+				end = start + 1
+			}
+			if end > len(samples) {
+				end = len(samples)
+			}
+
+			lo, hi := samples[start], samples[start]
+			for _, s := range samples[start:end] {
+				if s < lo {
+					lo = s
+				}
+				if s > hi {
+					hi = s
+				}
+			}
+
+			// Higher amplitudes are drawn closer to the top of the image
+			top := sampleToRow(hi, height)
+			bottom := sampleToRow(lo, height)
+			for y := top; y <= bottom; y++ {
+				img.SetRGBA(x, y, waveformForeground)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("failed to encode PNG: %v", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+func sampleToRow(sample int16, height int) int {
+	// Map [-32768, 32767] onto [height-1, 0]
+	row := (32767 - int(sample)) * (height - 1) / 65535
+	if row < 0 {
+		return 0
+	}
+	if row > height-1 {
+		return height - 1
+	}
+	return row
+}
+
+func bytesToInt16(data []byte) []int16 {
+	samples := make([]int16, len(data)/2)
+	for i := range samples {
+		samples[i] = int16(uint16(data[i*2]) | uint16(data[i*2+1])<<8)
+	}
+	return samples
+}
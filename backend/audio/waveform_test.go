@@ -0,0 +1,59 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/png"
+	"math"
+	"testing"
+)
+
+// sinePCM returns n 16-bit little-endian samples of a full-scale sine
+func sinePCM(n int) []byte {
+	pcm := make([]byte, n*2)
+	for i := 0; i < n; i++ {
+		sample := int16(32767 * math.Sin(2*math.Pi*float64(i)/64))
+		binary.LittleEndian.PutUint16(pcm[i*2:], uint16(sample))
+	}
+	return pcm
+}
+
+func TestRenderWaveformPNGDimensions(t *testing.T) {
+	tests := []struct {
+		name          string
+		pcm           []byte
+		width, height int
+		wantErr       bool
+	}{
+		{name: "default size", pcm: sinePCM(44100), width: DefaultWaveformWidth, height: DefaultWaveformHeight},
+		{name: "wider than the samples", pcm: sinePCM(10), width: 300, height: 40},
+		{name: "single pixel", pcm: sinePCM(1000), width: 1, height: 1},
+		{name: "largest", pcm: sinePCM(1000), width: MaxWaveformWidth, height: MaxWaveformHeight},
+		{name: "silence", pcm: nil, width: 64, height: 32},
+		{name: "zero width", pcm: sinePCM(1000), width: 0, height: 32, wantErr: true},
+		{name: "too tall", pcm: sinePCM(1000), width: 64, height: MaxWaveformHeight + 1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded, err := RenderWaveformPNG(tt.pcm, tt.width, tt.height)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("render: %v", err)
+			}
+
+			img, err := png.Decode(bytes.NewReader(encoded))
+			if err != nil {
+				t.Fatalf("not a valid PNG: %v", err)
+			}
+			if bounds := img.Bounds(); bounds.Dx() != tt.width || bounds.Dy() != tt.height {
+				t.Errorf("size = %dx%d, want %dx%d", bounds.Dx(), bounds.Dy(), tt.width, tt.height)
+			}
+		})
+	}
+}
@@ -0,0 +1,220 @@
+// Command stego is the command line companion to the steganography API
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"steganography-backend/models"
+	"steganography-backend/stego"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	switch os.Args[1] {
+	case "capacity":
+		if err := runCapacity(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	case "roundtrip":
+		if err := runRoundTrip(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	default:
+		usage()
+		os.Exit(2)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: stego <command> [options]")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "Commands:")
+	fmt.Fprintln(os.Stderr, "  capacity [-lsb N] [-method M] [-size BYTES] <dir|glob|file>...")
+	fmt.Fprintln(os.Stderr, "      Print the capacity of every MP3 and which ones can hold a secret of BYTES")
+	fmt.Fprintln(os.Stderr, "  roundtrip [-max N] <dir|glob|file>...")
+	fmt.Fprintln(os.Stderr, "      Parse and rewrite every MP3 without embedding and print the offsets of any changed bytes")
+}
+
+// runCapacity prints the capacity of every MP3 matched by the arguments
+func runCapacity(args []string) error {
+	flags := flag.NewFlagSet("capacity", flag.ContinueOnError)
+	lsbBits := flags.Int("lsb", 1, "LSB bits per carrier byte (1-4)")
+	method := flags.String("method", models.MethodAncillary, "embedding method (ancillary or frame_lsb)")
+	size := flags.Int("size", 0, "secret size in bytes to check against, 0 to skip")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *lsbBits < 1 || *lsbBits > 4 {
+		return fmt.Errorf("LSB bits must be between 1 and 4")
+	}
+	if flags.NArg() == 0 {
+		return fmt.Errorf("at least one directory, glob or file is required")
+	}
+
+	files, err := collectMP3Files(flags.Args())
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no MP3 files found")
+	}
+
+	mp3Stego, err := stego.NewMP3Steganography(&models.StegoConfig{LSBBits: *lsbBits, Method: *method})
+	if err != nil {
+		return err
+	}
+
+	table := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "FILE\tCAPACITY (BYTES)\tFITS")
+
+	fitting := 0
+	for _, file := range files {
+		capacity, err := fileCapacity(mp3Stego, file)
+		if err != nil {
+			fmt.Fprintf(table, "%s\terror: %v\t-\n", file, err)
+			continue
+		}
+
+		fits := "-"
+		if *size > 0 {
+			fits = "no"
+			if capacity >= *size {
+				fits = "yes"
+				fitting++
+			}
+		}
+		fmt.Fprintf(table, "%s\t%d\t%s\n", file, capacity, fits)
+	}
+	table.Flush()
+
+	if *size > 0 {
+		fmt.Printf("\n%d of %d files can hold %d bytes at %d LSB bit(s) with the %s method\n",
+			fitting, len(files), *size, *lsbBits, *method)
+	}
+
+	return nil
+}
+
+// runRoundTrip checks that every MP3 matched by the arguments is rewritten
+// byte for byte when nothing is embedded. It fails when any file differs.
+func runRoundTrip(args []string) error {
+	flags := flag.NewFlagSet("roundtrip", flag.ContinueOnError)
+	maxRuns := flags.Int("max", 10, "differing runs to print per file, 0 prints all")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() == 0 {
+		return fmt.Errorf("at least one directory, glob or file is required")
+	}
+
+	files, err := collectMP3Files(flags.Args())
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no MP3 files found")
+	}
+
+	failed := 0
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			fmt.Printf("%s: error: %v\n", file, err)
+			failed++
+			continue
+		}
+		report, err := stego.RoundTrip(data)
+		if err != nil {
+			fmt.Printf("%s: error: %v\n", file, err)
+			failed++
+			continue
+		}
+		if report.Identical() {
+			fmt.Printf("%s: ok (%d bytes)\n", file, report.InputLength)
+			continue
+		}
+
+		failed++
+		fmt.Printf("%s: %d differing run(s), %d bytes in, %d bytes out\n",
+			file, len(report.Runs), report.InputLength, report.OutputLength)
+		for i, run := range report.Runs {
+			if *maxRuns > 0 && i == *maxRuns {
+				fmt.Printf("    ... %d more\n", len(report.Runs)-i)
+				break
+			}
+			fmt.Printf("    offset %d, %d byte(s)\n", run.Offset, run.Length)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d files did not round-trip", failed, len(files))
+	}
+	return nil
+}
+
+// fileCapacity returns the payload capacity of one MP3 file
+func fileCapacity(mp3Stego stego.MP3Steganography, path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return mp3Stego.CalculateCapacity(data)
+}
+
+// collectMP3Files expands directories and glob patterns into a sorted list of
+// .mp3 files without duplicates
+func collectMP3Files(args []string) ([]string, error) {
+	seen := make(map[string]bool)
+	files := make([]string, 0)
+	add := func(path string) {
+		if strings.ToLower(filepath.Ext(path)) == ".mp3" && !seen[path] {
+			seen[path] = true
+			files = append(files, path)
+		}
+	}
+
+	for _, arg := range args {
+		matches, err := filepath.Glob(arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %v", arg, err)
+		}
+
+		for _, match := range matches {
+			info, err := os.Stat(match)
+			if err != nil {
+				return nil, err
+			}
+			if !info.IsDir() {
+				add(match)
+				continue
+			}
+
+			entries, err := os.ReadDir(match)
+			if err != nil {
+				return nil, err
+			}
+			for _, entry := range entries {
+				if !entry.IsDir() {
+					add(filepath.Join(match, entry.Name()))
+				}
+			}
+		}
+	}
+
+	sort.Strings(files)
+	return files, nil
+}
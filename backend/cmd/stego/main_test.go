@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"steganography-backend/models"
+	"steganography-backend/stego"
+)
+
+// TestMain runs the command itself when re-executed by runCommand
+func TestMain(m *testing.M) {
+	if args, ok := os.LookupEnv("STEGO_TEST_ARGS"); ok {
+		os.Args = []string{"stego"}
+		if args != "" {
+			os.Args = append(os.Args, strings.Split(args, "\n")...)
+		}
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// runCommand runs the command with args in a subprocess and returns its
+// standard output and exit status
+func runCommand(t *testing.T, args ...string) (string, int) {
+	t.Helper()
+
+	cmd := exec.Command(os.Args[0])
+	cmd.Env = append(os.Environ(), "STEGO_TEST_ARGS="+strings.Join(args, "\n"))
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return stdout.String(), exitErr.ExitCode()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stdout.String(), 0
+}
+
+// generatedMP3 returns n silent MPEG-1 Layer III frames at 128 kbps and
+// 44.1 kHz. Their main data is empty, so the rest of each frame is padding.
+func generatedMP3(n int) []byte {
+	frame := make([]byte, 417)
+	binary.BigEndian.PutUint32(frame, 0xFFFB9000)
+	return bytes.Repeat(frame, n)
+}
+
+func TestCapacityCommand(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string][]byte{
+		"large.mp3":  generatedMP3(200),
+		"small.mp3":  generatedMP3(20),
+		"broken.mp3": []byte("not an mp3"),
+		"notes.txt":  []byte("skipped"),
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mp3Stego, err := stego.NewMP3Steganography(&models.StegoConfig{LSBBits: 1, Method: models.MethodAncillary})
+	if err != nil {
+		t.Fatal(err)
+	}
+	capacities := make(map[string]int)
+	for _, name := range []string{"large.mp3", "small.mp3"} {
+		if capacities[name], err = mp3Stego.CalculateCapacity(files[name]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, brokenErr := mp3Stego.CalculateCapacity(files["broken.mp3"])
+	if brokenErr == nil {
+		t.Fatal("expected the broken file to have no capacity")
+	}
+	size := (capacities["large.mp3"] + capacities["small.mp3"]) / 2
+
+	output, status := runCommand(t, "capacity", "-size", fmt.Sprint(size), dir)
+	if status != 0 {
+		t.Fatalf("exit status %d, output:\n%s", status, output)
+	}
+
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	wantLines := [][]string{
+		{"FILE", "CAPACITY", "(BYTES)", "FITS"},
+		append([]string{filepath.Join(dir, "broken.mp3"), "error:"}, append(strings.Fields(brokenErr.Error()), "-")...),
+		{filepath.Join(dir, "large.mp3"), fmt.Sprint(capacities["large.mp3"]), "yes"},
+		{filepath.Join(dir, "small.mp3"), fmt.Sprint(capacities["small.mp3"]), "no"},
+	}
+	if len(lines) != len(wantLines)+2 {
+		t.Fatalf("got %d lines, want %d:\n%s", len(lines), len(wantLines)+2, output)
+	}
+	for i, want := range wantLines {
+		if got := strings.Fields(lines[i]); strings.Join(got, " ") != strings.Join(want, " ") {
+			t.Errorf("line %d = %q, want fields %q", i, lines[i], want)
+		}
+	}
+	wantSummary := fmt.Sprintf("1 of 3 files can hold %d bytes at 1 LSB bit(s) with the ancillary method", size)
+	if summary := lines[len(lines)-1]; summary != wantSummary {
+		t.Errorf("summary = %q, want %q", summary, wantSummary)
+	}
+
+	tests := []struct {
+		name       string
+		args       []string
+		wantStatus int
+	}{
+		{name: "no command", args: nil, wantStatus: 2},
+		{name: "unknown command", args: []string{"embed"}, wantStatus: 2},
+		{name: "no paths", args: []string{"capacity"}, wantStatus: 1},
+		{name: "no MP3 files", args: []string{"capacity", filepath.Join(dir, "*.txt")}, wantStatus: 1},
+		{name: "LSB bits out of range", args: []string{"capacity", "-lsb", "5", dir}, wantStatus: 1},
+		{name: "single file", args: []string{"capacity", filepath.Join(dir, "small.mp3")}, wantStatus: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, status := runCommand(t, tt.args...); status != tt.wantStatus {
+				t.Errorf("exit status %d, want %d", status, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"compress/gzip"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DefaultGzipLevel is the gzip level used for compressible responses (1-9)
+const DefaultGzipLevel = 6
+
+// Compress gzips JSON and text responses for clients that accept it. Audio,
+// images and secrets served as byte ranges are sent as is: MP3 and PNG are
+// already compressed, and ranges must address the uncompressed bytes.
+func (h *StegoHandler) Compress() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if h.gzipLevel == 0 {
+			c.Next()
+			return
+		}
+
+		// Caches must key on Accept-Encoding whether or not this response is compressed
+		c.Header("Vary", "Accept-Encoding")
+		if !acceptsGzip(c.GetHeader("Accept-Encoding")) {
+			c.Next()
+			return
+		}
+
+		writer := &gzipResponseWriter{ResponseWriter: c.Writer, level: h.gzipLevel}
+		c.Writer = writer
+		defer writer.close()
+		c.Next()
+	}
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip
+func acceptsGzip(acceptEncoding string) bool {
+	for _, coding := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(coding, ";")
+		name = strings.TrimSpace(name)
+		if name != "gzip" && name != "*" {
+			continue
+		}
+
+		_, q, ok := strings.Cut(strings.ReplaceAll(params, " ", ""), "q=")
+		if !ok {
+			return true
+		}
+		weight, err := strconv.ParseFloat(q, 64)
+		return err == nil && weight > 0
+	}
+	return false
+}
+
+// compressible reports whether a response with these headers is worth
+// compressing
+func compressible(header http.Header) bool {
+	if header.Get("Content-Encoding") != "" || header.Get("Content-Range") != "" || header.Get("Accept-Ranges") != "" {
+		return false
+	}
+
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || strings.HasPrefix(mediaType, "text/")
+}
+
+// gzipResponseWriter decides on the first write whether to compress, once the
+// handler has set the response headers
+type gzipResponseWriter struct {
+	gin.ResponseWriter
+	level   int
+	decided bool
+	gz      *gzip.Writer // nil when the response is sent uncompressed
+}
+
+func (w *gzipResponseWriter) decide() {
+	if w.decided {
+		return
+	}
+	w.decided = true
+
+	header := w.Header()
+	if !compressible(header) || w.Status() == http.StatusNoContent || w.Status() == http.StatusNotModified {
+		return
+	}
+
+	gz, err := gzip.NewWriterLevel(w.ResponseWriter, w.level)
+	if err != nil {
+		return
+	}
+	header.Set("Content-Encoding", "gzip")
+	header.Del("Content-Length")
+	w.gz = gz
+}
+
+func (w *gzipResponseWriter) Write(data []byte) (int, error) {
+	w.decide()
+	if w.gz == nil {
+		return w.ResponseWriter.Write(data)
+	}
+	return w.gz.Write(data)
+}
+
+func (w *gzipResponseWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *gzipResponseWriter) Flush() {
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+func (w *gzipResponseWriter) close() {
+	if w.gz != nil {
+		w.gz.Close()
+	}
+}
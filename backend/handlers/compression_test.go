@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCompress(t *testing.T) {
+	h := newTestHandler()
+	jsonBody := gin.H{"message": strings.Repeat("compressible ", 200)}
+	text := []byte(strings.Repeat("secret text ", 200))
+
+	tests := []struct {
+		name           string
+		level          int
+		acceptEncoding string
+		rangeHeader    string
+		handler        gin.HandlerFunc
+		wantGzip       bool
+		wantBody       []byte // Checked after decompression when set
+	}{
+		{
+			name:           "JSON",
+			level:          DefaultGzipLevel,
+			acceptEncoding: "gzip, deflate",
+			handler:        func(c *gin.Context) { c.JSON(http.StatusOK, jsonBody) },
+			wantGzip:       true,
+		},
+		{
+			name:    "JSON without Accept-Encoding",
+			level:   DefaultGzipLevel,
+			handler: func(c *gin.Context) { c.JSON(http.StatusOK, jsonBody) },
+		},
+		{
+			name:           "gzip refused",
+			level:          DefaultGzipLevel,
+			acceptEncoding: "gzip;q=0, identity",
+			handler:        func(c *gin.Context) { c.JSON(http.StatusOK, jsonBody) },
+		},
+		{
+			name:           "compression disabled",
+			level:          0,
+			acceptEncoding: "gzip",
+			handler:        func(c *gin.Context) { c.JSON(http.StatusOK, jsonBody) },
+		},
+		{
+			name:           "audio",
+			level:          DefaultGzipLevel,
+			acceptEncoding: "gzip",
+			handler:        func(c *gin.Context) { h.sendOutput(c, "audio/mpeg", text, false) },
+			wantBody:       text,
+		},
+		{
+			name:           "PNG",
+			level:          DefaultGzipLevel,
+			acceptEncoding: "gzip",
+			handler:        func(c *gin.Context) { c.Data(http.StatusOK, "image/png", text) },
+			wantBody:       text,
+		},
+		{
+			name:           "text secret",
+			level:          DefaultGzipLevel,
+			acceptEncoding: "gzip",
+			handler:        func(c *gin.Context) { h.sendOutput(c, "text/plain", text, true) },
+			wantGzip:       true,
+			wantBody:       text,
+		},
+		{
+			name:           "ranged text secret",
+			level:          DefaultGzipLevel,
+			acceptEncoding: "gzip",
+			rangeHeader:    "bytes=0-9",
+			handler:        func(c *gin.Context) { h.sendOutput(c, "text/plain", text, true) },
+			wantBody:       text[:10],
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			compressor := newTestHandler()
+			compressor.gzipLevel = tt.level
+			req := httptest.NewRequest(http.MethodGet, "/output", nil)
+			if tt.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+			if tt.rangeHeader != "" {
+				req.Header.Set("Range", tt.rangeHeader)
+			}
+			resp := serve(req, compressor.Compress(), tt.handler)
+
+			gzipped := resp.Header().Get("Content-Encoding") == "gzip"
+			if gzipped != tt.wantGzip {
+				t.Fatalf("gzipped = %v, want %v (Content-Type %q)", gzipped, tt.wantGzip, resp.Header().Get("Content-Type"))
+			}
+			if tt.level > 0 && resp.Header().Get("Vary") != "Accept-Encoding" {
+				t.Errorf("Vary = %q, want Accept-Encoding", resp.Header().Get("Vary"))
+			}
+
+			body := resp.Body.Bytes()
+			if gzipped {
+				reader, err := gzip.NewReader(bytes.NewReader(body))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if body, err = io.ReadAll(reader); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.wantBody != nil && !bytes.Equal(body, tt.wantBody) {
+				t.Errorf("body = %q, want %q", body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		want           bool
+	}{
+		{"", false},
+		{"gzip", true},
+		{"deflate, gzip;q=0.5", true},
+		{"gzip;q=0", false},
+		{"gzip; q=0.0", false},
+		{"*", true},
+		{"br, identity", false},
+	}
+
+	for _, tt := range tests {
+		if got := acceptsGzip(tt.acceptEncoding); got != tt.want {
+			t.Errorf("acceptsGzip(%q) = %v, want %v", tt.acceptEncoding, got, tt.want)
+		}
+	}
+}
@@ -2,27 +2,154 @@
 package handlers
 
 import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math"
+	"mime"
+	"mime/multipart"
 	"net/http"
+	"net/textproto"
+	"os"
 	"path/filepath"
 	"steganography-backend/audio"
 	"steganography-backend/crypto"
 	"steganography-backend/models"
+	"steganography-backend/mp3parser"
 	"steganography-backend/stego"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
+// RecommendedCoverBitrate is the bitrate suggested for covers with too little ancillary space
+const RecommendedCoverBitrate = 128000
+
+// DefaultSpoolThreshold is the output size above which responses are spooled to disk
+const DefaultSpoolThreshold = 8 << 20 // 8MB
+
+// DefaultMaxConcurrent is how many embed/extract operations run at once
+const DefaultMaxConcurrent = 4
+
+// ConcurrencyRetryAfter is the Retry-After (seconds) sent when the server is saturated
+const ConcurrencyRetryAfter = 5
+
+// Content-Disposition types accepted for the stego output
+const (
+	DispositionAttachment = "attachment" // Download the file (default)
+	DispositionInline     = "inline"     // Let the client preview the file
+)
+
 type StegoHandler struct {
-	audioDecoder *audio.AudioDecoder
+	audioDecoder   *audio.AudioDecoder
+	debugEnabled   bool          // Enables debug-only options such as explicit positions
+	spoolThreshold int64         // Outputs of at least this size are streamed from a temp file, 0 disables
+	oddBytePolicy  string        // How a trailing odd PCM byte is handled before PSNR comparison
+	marker         string        // Preamble marker namespacing this deployment's files
+	slots          chan struct{} // Semaphore for embed/extract operations, nil disables the limit
+	gzipLevel      int           // Gzip level for JSON and text responses, 0 disables compression
+	tmpDir         string        // Directory for spooled outputs, empty uses the OS temp dir
 }
 
 func NewStegoHandler() *StegoHandler {
+	spoolThreshold := int64(DefaultSpoolThreshold)
+	if value := os.Getenv("STEGO_SPOOL_THRESHOLD"); value != "" {
+		if parsed, err := strconv.ParseInt(value, 10, 64); err == nil && parsed >= 0 {
+			spoolThreshold = parsed
+		} else {
+			fmt.Printf("Warning: invalid STEGO_SPOOL_THRESHOLD %q, using %d\n", value, spoolThreshold)
+		}
+	}
+
+	oddBytePolicy := audio.OddBytesTruncate
+	if value := os.Getenv("STEGO_PCM_ODD_BYTES"); value != "" {
+		if err := audio.ValidateOddBytePolicy(value); err == nil {
+			oddBytePolicy = value
+		} else {
+			fmt.Printf("Warning: invalid STEGO_PCM_ODD_BYTES %q, using %q\n", value, oddBytePolicy)
+		}
+	}
+
+	marker := stego.DefaultMarker
+	if value := os.Getenv("STEGO_MARKER"); value != "" {
+		if err := stego.ValidateMarker(value); err == nil {
+			marker = value
+		} else {
+			fmt.Printf("Warning: invalid STEGO_MARKER %q (%v), using %q\n", value, err, marker)
+		}
+	}
+
+	maxConcurrent := DefaultMaxConcurrent
+	if value := os.Getenv("STEGO_MAX_CONCURRENT"); value != "" {
+		if parsed, err := strconv.Atoi(value); err == nil && parsed >= 0 {
+			maxConcurrent = parsed
+		} else {
+			fmt.Printf("Warning: invalid STEGO_MAX_CONCURRENT %q, using %d\n", value, maxConcurrent)
+		}
+	}
+	var slots chan struct{}
+	if maxConcurrent > 0 {
+		slots = make(chan struct{}, maxConcurrent)
+	}
+
+	tmpDir := os.Getenv("STEGO_TMPDIR")
+	if tmpDir != "" {
+		if info, err := os.Stat(tmpDir); err != nil || !info.IsDir() {
+			fmt.Printf("Warning: STEGO_TMPDIR %q is not a directory, using %q\n", tmpDir, os.TempDir())
+			tmpDir = ""
+		}
+	}
+
+	gzipLevel := DefaultGzipLevel
+	if value := os.Getenv("STEGO_GZIP_LEVEL"); value != "" {
+		if parsed, err := strconv.Atoi(value); err == nil && parsed >= 0 && parsed <= gzip.BestCompression {
+			gzipLevel = parsed
+		} else {
+			fmt.Printf("Warning: invalid STEGO_GZIP_LEVEL %q, using %d\n", value, gzipLevel)
+		}
+	}
+
 	return &StegoHandler{
-		audioDecoder: audio.NewAudioDecoder(),
+		audioDecoder:   audio.NewAudioDecoder(),
+		debugEnabled:   os.Getenv("STEGO_DEBUG") == "true",
+		spoolThreshold: spoolThreshold,
+		oddBytePolicy:  oddBytePolicy,
+		marker:         marker,
+		slots:          slots,
+		gzipLevel:      gzipLevel,
+		tmpDir:         tmpDir,
+	}
+}
+
+// LimitConcurrency caps how many heavy operations run at once. Requests
+// arriving while every slot is taken are rejected with 503 and a Retry-After
+// instead of queueing, so a burst of large embeds cannot exhaust the server.
+func (h *StegoHandler) LimitConcurrency() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if h.slots == nil {
+			c.Next()
+			return
+		}
+
+		select {
+		case h.slots <- struct{}{}:
+			defer func() { <-h.slots }()
+			c.Next()
+		default:
+			c.Header("Retry-After", strconv.Itoa(ConcurrencyRetryAfter))
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, models.StegoResponse{
+				Success: false,
+				Message: "Server is busy, please retry later",
+			})
+		}
 	}
 }
 
@@ -30,11 +157,13 @@ func (h *StegoHandler) HealthCheck(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"status":  "healthy",
 		"message": "Steganography API is running",
-		"version": "1.0.0",
+		"version": Version,
 	})
 }
 
 func (h *StegoHandler) InsertMessage(c *gin.Context) {
+	timer := newStageTimer()
+
 	if err := c.Request.ParseMultipartForm(32 << 20); err != nil { // 32MB limit
 		c.JSON(http.StatusBadRequest, models.StegoResponse{
 			Success: false,
@@ -47,6 +176,12 @@ func (h *StegoHandler) InsertMessage(c *gin.Context) {
 	useEncryption := c.PostForm("use_encryption") == "true"
 	useRandomStart := c.PostForm("use_random_start") == "true"
 	lsbBitsStr := c.PostForm("lsb_bits")
+	salt := c.PostForm("salt")
+	seedHash := c.DefaultPostForm("seed_hash", models.SeedHashSHA256)
+	metadata := c.PostForm("metadata")
+	fileID := c.PostForm("file_id")
+	expiresAt := c.PostForm("expires_at")
+	disposition := c.DefaultPostForm("disposition", DispositionAttachment)
 
 	if key == "" {
 		c.JSON(http.StatusBadRequest, models.StegoResponse{
@@ -73,6 +208,111 @@ func (h *StegoHandler) InsertMessage(c *gin.Context) {
 		return
 	}
 
+	if err := stego.ValidateSalt(salt); err != nil {
+		c.JSON(http.StatusBadRequest, models.StegoResponse{
+			Success: false,
+			Message: fmt.Sprintf("Invalid salt: %v", err),
+		})
+		return
+	}
+
+	if err := stego.ValidateSeedHash(seedHash); err != nil {
+		c.JSON(http.StatusBadRequest, models.StegoResponse{
+			Success: false,
+			Message: fmt.Sprintf("Invalid seed hash: %v", err),
+		})
+		return
+	}
+
+	if disposition != DispositionAttachment && disposition != DispositionInline {
+		c.JSON(http.StatusBadRequest, models.StegoResponse{
+			Success: false,
+			Message: fmt.Sprintf("Disposition must be %q or %q", DispositionAttachment, DispositionInline),
+		})
+		return
+	}
+
+	if metadata != "" {
+		if err := stego.ValidateMetadata([]byte(metadata)); err != nil {
+			c.JSON(http.StatusBadRequest, models.StegoResponse{
+				Success: false,
+				Message: fmt.Sprintf("Invalid metadata: %v", err),
+			})
+			return
+		}
+	}
+
+	// "auto" asks for a generated ID; leaving the field out embeds none so
+	// repeated inserts stay deterministic
+	generatedFileID := fileID == "auto"
+	if generatedFileID {
+		if fileID, err = stego.NewFileID(); err != nil {
+			c.JSON(http.StatusInternalServerError, models.StegoResponse{
+				Success: false,
+				Message: err.Error(),
+			})
+			return
+		}
+	}
+	if fileID != "" {
+		if err := stego.ValidateFileID(fileID); err != nil {
+			c.JSON(http.StatusBadRequest, models.StegoResponse{
+				Success: false,
+				Message: fmt.Sprintf("Invalid file ID: %v", err),
+			})
+			return
+		}
+	}
+
+	var expires time.Time
+	if expiresAt != "" {
+		if expires, err = time.Parse(time.RFC3339, expiresAt); err != nil {
+			c.JSON(http.StatusBadRequest, models.StegoResponse{
+				Success: false,
+				Message: "Expiry must be an RFC 3339 timestamp, e.g. 2030-01-01T00:00:00Z",
+			})
+			return
+		}
+	}
+
+	part, err := parsePartManifest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.StegoResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	maxFilenameBytes, err := parseMaxFilenameBytes(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.StegoResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	minPSNR := 0.0
+	if value := c.PostForm("min_psnr"); value != "" {
+		minPSNR, err = strconv.ParseFloat(value, 64)
+		if err != nil || minPSNR <= 0 || math.IsInf(minPSNR, 0) {
+			c.JSON(http.StatusBadRequest, models.StegoResponse{
+				Success: false,
+				Message: "Minimum PSNR must be a positive number of dB",
+			})
+			return
+		}
+	}
+	precheck := c.PostForm("precheck") == "true"
+	if precheck && minPSNR == 0 {
+		c.JSON(http.StatusBadRequest, models.StegoResponse{
+			Success: false,
+			Message: "Precheck needs a min_psnr floor to check against",
+		})
+		return
+	}
+
 	// Get uploaded files
 	audioFile, audioHeader, err := c.Request.FormFile("audio_file")
 	if err != nil {
@@ -102,6 +342,14 @@ func (h *StegoHandler) InsertMessage(c *gin.Context) {
 		return
 	}
 
+	if limit := filenameLimit(maxFilenameBytes); len(secretHeader.Filename) > limit {
+		c.JSON(http.StatusBadRequest, models.StegoResponse{
+			Success: false,
+			Message: fmt.Sprintf("Secret filename is %d bytes, the limit is %d (max_filename_bytes)", len(secretHeader.Filename), limit),
+		})
+		return
+	}
+
 	// Read audio file
 	audioData, err := io.ReadAll(audioFile)
 	if err != nil {
@@ -114,6 +362,9 @@ func (h *StegoHandler) InsertMessage(c *gin.Context) {
 
 	// Analyze MP3 structure
 	mp3Info, err := h.audioDecoder.AnalyzeMP3(audioData)
+	if writeUnsupportedFormat(c, err) {
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, models.StegoResponse{
 			Success: false,
@@ -132,17 +383,76 @@ func (h *StegoHandler) InsertMessage(c *gin.Context) {
 		return
 	}
 
+	positions, status, err := h.readPositionsFile(c)
+	if err != nil {
+		c.JSON(status, models.StegoResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
 	config := &models.StegoConfig{
 		Key:            key,
 		UseEncryption:  useEncryption,
 		UseRandomStart: useRandomStart,
 		LSBBits:        lsbBits,
 		SecretFilename: secretHeader.Filename,
+		SecretMIMEType: stego.DetectMIMEType(secretHeader.Filename, secretData),
+		Salt:           salt,
+		SeedHash:       seedHash,
+		Marker:         h.marker,
+		Positions:      positions,
+		FileID:         fileID,
+		Expires:        expires,
+		Part:           part,
+		ID3Checksum:    c.PostForm("id3_checksum") == "true",
+
+		MaxFilenameBytes: maxFilenameBytes,
+		StageHook:        timer.mark,
+	}
+	if metadata != "" {
+		config.Metadata = json.RawMessage(metadata)
 	}
 
-	mp3Stego := stego.NewMP3AncillaryLSBSteganography(config)
-	capacity, err := mp3Stego.CalculateCapacity(audioData)
+	if err := parseMethodOptions(c, config); err != nil {
+		c.JSON(http.StatusBadRequest, models.StegoResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	if config.Method == models.MethodAuto {
+		c.JSON(http.StatusBadRequest, models.StegoResponse{
+			Success: false,
+			Message: "Invalid method: auto detection is only available for extraction",
+		})
+		return
+	}
+
+	mp3Stego, err := stego.NewMP3Steganography(config)
 	if err != nil {
+		c.JSON(http.StatusBadRequest, models.StegoResponse{
+			Success: false,
+			Message: fmt.Sprintf("Invalid method: %v", err),
+		})
+		return
+	}
+	timer.mark("parse")
+
+	capacity, err := mp3Stego.CalculateCapacity(audioData)
+	if err != nil || capacity <= 0 {
+		// Heavily compressed covers leave (almost) no ancillary space; tell the
+		// user how to get a usable cover instead of failing deep in the embed
+		if config.Method != models.MethodFrameLSB {
+			c.JSON(http.StatusBadRequest, models.StegoResponse{
+				Success: false,
+				Message: fmt.Sprintf("This MP3 has no usable ancillary space for embedding. %s", lowCapacityGuidance(mp3Info)),
+			})
+			return
+		}
+
 		c.JSON(http.StatusInternalServerError, models.StegoResponse{
 			Success: false,
 			Message: fmt.Sprintf("Failed to calculate capacity: %v", err),
@@ -150,16 +460,72 @@ func (h *StegoHandler) InsertMessage(c *gin.Context) {
 		return
 	}
 
-	if len(secretData)+len(secretHeader.Filename)+8 > capacity {
+	// The capacity excludes the fixed length fields; add them back so both
+	// sides count the whole payload header
+	overhead := stego.PayloadOverhead(config)
+	available := capacity + overhead.FilenameLength + overhead.ExtensionsLength + overhead.DataLength
+	required := overhead.HeaderBytes + len(secretData)
+	if required > available {
+		message := fmt.Sprintf("Secret data too large. Maximum capacity: %d bytes, required: %d bytes",
+			available, required)
+		if config.Method != models.MethodFrameLSB {
+			message += ". " + lowCapacityGuidance(mp3Info)
+		}
+
 		c.JSON(http.StatusBadRequest, models.StegoResponse{
 			Success: false,
-			Message: fmt.Sprintf("Secret data too large. Maximum capacity: %d bytes, required: %d bytes",
-				capacity, len(secretData)+len(secretHeader.Filename)+8),
+			Message: message,
 		})
 		return
 	}
+	timer.mark("analyze")
+
+	// Estimate the PSNR on a short sample first so a too aggressive embed is
+	// refused before the full embed runs
+	if precheck {
+		sampleCover, sampleStego, err := stego.PrecheckSample(audioData, config, len(secretData))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, models.StegoResponse{
+				Success: false,
+				Message: fmt.Sprintf("PSNR precheck failed: %v", err),
+			})
+			return
+		}
+		// A sample that no longer decodes cleanly is as degraded as it gets
+		estimate, err := h.calculatePSNR(sampleCover, sampleStego, mp3Info.ChannelMode)
+		if err != nil {
+			c.JSON(http.StatusUnprocessableEntity, models.StegoResponse{
+				Success: false,
+				Message: fmt.Sprintf("PSNR precheck could not verify the minimum of %.2f dB (%v), nothing was embedded", minPSNR, err),
+			})
+			return
+		}
+		if !math.IsInf(estimate, 1) {
+			c.Header("X-Stego-Estimated-PSNR", fmt.Sprintf("%.2f", estimate))
+		}
+		if !audio.ValidatePSNR(estimate, minPSNR) {
+			c.JSON(http.StatusUnprocessableEntity, models.StegoResponse{
+				Success: false,
+				Message: fmt.Sprintf("Estimated PSNR %.2f dB is below the minimum of %.2f dB, nothing was embedded. Use fewer LSB bits, a higher density, the ancillary method or a smaller secret", estimate, minPSNR),
+				PSNR:    estimate,
+			})
+			return
+		}
+		timer.mark("precheck")
+	}
+
+	var warnings warningList
+	if mp3Info.SkippedBytes > 0 {
+		warnings.add("%d bytes in %d regions were skipped while resyncing to frame headers; they carry no data and are removed from the stego file", mp3Info.SkippedBytes, mp3Info.SkippedRegions)
+	}
+	if mp3Info.UnanalyzableFrames > 0 && config.Method != models.MethodFrameLSB {
+		warnings.add("%d of %d frames could not be analyzed and carry no data; the capacity only counts the remaining frames", mp3Info.UnanalyzableFrames, mp3Info.TotalFrames)
+	}
+	if !mp3Info.HasInfoFrame {
+		warnings.add("No Xing/Info frame detected; players may misreport the duration of VBR files")
+	}
 
-	// Embed secret data into MP3 ancillary areas only
+	// Embed secret data with the selected method
 	stegoAudio, err := mp3Stego.EmbedInMP3(audioData, secretData)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, models.StegoResponse{
@@ -168,45 +534,224 @@ func (h *StegoHandler) InsertMessage(c *gin.Context) {
 		})
 		return
 	}
+	timer.mark("encode")
 
 	// Calculate PSNR by decoding both original and stego audio
-	psnr := 0.0
-	originalPCM, _, pcmErr1 := h.audioDecoder.DecodeMP3ToPCM(audioData)
-	stegoPCM, _, pcmErr2 := h.audioDecoder.DecodeMP3ToPCM(stegoAudio)
+	psnr, psnrErr := h.calculatePSNR(audioData, stegoAudio, mp3Info.ChannelMode)
+	if psnrErr != nil {
+		warnings.add("Could not calculate PSNR: %v", psnrErr)
+	}
+	timer.mark("psnr")
 
-	if pcmErr1 == nil && pcmErr2 == nil {
-		psnr = audio.CalculatePSNRFloat64(bytesToFloat64(originalPCM), bytesToFloat64(stegoPCM))
-	} else {
-		fmt.Printf("Warning: Could not calculate PSNR: original decode error: %v, stego decode error: %v\n", pcmErr1, pcmErr2)
+	// Never hand out a file below the requested floor, or one whose PSNR
+	// cannot be verified, even if the precheck estimate passed
+	if minPSNR > 0 && psnrErr != nil {
+		c.JSON(http.StatusUnprocessableEntity, models.StegoResponse{
+			Success: false,
+			Message: fmt.Sprintf("Could not verify the minimum PSNR of %.2f dB (%v), the stego file was discarded", minPSNR, psnrErr),
+		})
+		return
+	}
+	if minPSNR > 0 && !audio.ValidatePSNR(psnr, minPSNR) {
+		c.JSON(http.StatusUnprocessableEntity, models.StegoResponse{
+			Success: false,
+			Message: fmt.Sprintf("PSNR %.2f dB is below the minimum of %.2f dB, the stego file was discarded", psnr, minPSNR),
+			PSNR:    psnr,
+		})
+		return
 	}
 
 	baseFilename := strings.TrimSuffix(audioHeader.Filename, filepath.Ext(audioHeader.Filename))
 	outputFilename := fmt.Sprintf("%s_stego.mp3", baseFilename)
 
+	originalHash := sha256.Sum256(audioData)
+	stegoHash := sha256.Sum256(stegoAudio)
+	result := models.InsertResult{
+		Filename:       outputFilename,
+		Method:         "MP3 Ancillary Data LSB",
+		Message:        "Secret message embedded in MP3 ancillary data only - audio quality preserved",
+		FileID:         fileID,
+		Capacity:       capacity,
+		Frames:         mp3Info.TotalFrames,
+		Unanalyzable:   mp3Info.UnanalyzableFrames,
+		OriginalSHA256: hex.EncodeToString(originalHash[:]),
+		StegoSHA256:    hex.EncodeToString(stegoHash[:]),
+		Warnings:       warnings,
+	}
+	if config.Method == models.MethodFrameLSB {
+		result.Unanalyzable = 0 // Frame LSB does not depend on frame analysis
+		result.Method = "MP3 Frame Data LSB"
+		result.Message = "Secret message embedded in MP3 frame data - audio quality may be affected"
+	}
+	if psnrErr == nil {
+		if math.IsInf(psnr, 1) {
+			result.AudioIdentical = true
+		} else {
+			result.PSNR = &psnr
+		}
+		result.QualityGrade = audio.QualityGrade(psnr)
+	}
+
+	// Include metadata about the steganography operation
+	c.Header("X-Stego-Method", result.Method)
+	c.Header("X-Stego-Message", result.Message)
+	c.Header("X-Stego-Capacity", fmt.Sprintf("%d", capacity))
+	c.Header("X-Stego-Frames", fmt.Sprintf("%d", mp3Info.TotalFrames))
+	if result.Unanalyzable > 0 {
+		c.Header("X-Stego-Unanalyzable-Frames", strconv.Itoa(result.Unanalyzable))
+	}
+	if psnrErr == nil {
+		c.Header("X-Stego-PSNR", fmt.Sprintf("%.2f", psnr))
+		c.Header("X-Stego-Quality-Grade", result.QualityGrade)
+	}
+	c.Header("X-Original-SHA256", result.OriginalSHA256)
+	c.Header("X-Stego-SHA256", result.StegoSHA256)
+	if fileID != "" {
+		c.Header("X-Stego-File-ID", fileID)
+	}
+	warnings.writeHeader(c)
+	timer.writeHeaders(c)
+
+	if c.PostForm("multipart") == "true" {
+		sendMultipartResult(c, result, disposition, stegoAudio)
+		return
+	}
+
 	// Set headers for file download
 	c.Header("Content-Description", "File Transfer")
 	c.Header("Content-Transfer-Encoding", "binary")
-	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", outputFilename))
+	c.Header("Content-Disposition", contentDisposition(disposition, outputFilename))
 	c.Header("Content-Type", "audio/mpeg")
 	c.Header("Content-Length", fmt.Sprintf("%d", len(stegoAudio)))
 
-	// Include metadata about the steganography operation
-	c.Header("X-Stego-Method", "MP3 Ancillary Data LSB")
-	c.Header("X-Stego-Message", "Secret message embedded in MP3 ancillary data only - audio quality preserved")
-	c.Header("X-Stego-Capacity", fmt.Sprintf("%d", capacity))
-	c.Header("X-Stego-Frames", fmt.Sprintf("%d", mp3Info.TotalFrames))
-	c.Header("X-Stego-PSNR", fmt.Sprintf("%.2f", psnr))
+	// A generated file ID makes every response different, so it cannot be resumed
+	h.sendOutput(c, "audio/mpeg", stegoAudio, !generatedFileID)
+}
+
+// sendMultipartResult writes a multipart/mixed response holding a "metadata"
+// part with the insert result as JSON followed by a "stego_file" part with
+// the stego MP3. It is always sent whole: Range headers are ignored.
+func sendMultipartResult(c *gin.Context, result models.InsertResult, disposition string, stegoAudio []byte) {
+	body, contentType, err := buildMultipartResult(result, disposition, stegoAudio)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.StegoResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to build multipart response: %v", err),
+		})
+		return
+	}
 
-	c.Data(http.StatusOK, "audio/mpeg", stegoAudio)
+	c.Data(http.StatusOK, contentType, body)
 }
 
-func (h *StegoHandler) ExtractMessage(c *gin.Context) {
+func buildMultipartResult(result models.InsertResult, disposition string, stegoAudio []byte) ([]byte, string, error) {
+	metadata, err := json.Marshal(result)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+
+	metadataPart, err := writer.CreatePart(textproto.MIMEHeader{
+		"Content-Type":        {"application/json"},
+		"Content-Disposition": {`inline; name="metadata"`},
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	if _, err := metadataPart.Write(metadata); err != nil {
+		return nil, "", err
+	}
+
+	filePart, err := writer.CreatePart(textproto.MIMEHeader{
+		"Content-Type":        {"audio/mpeg"},
+		"Content-Disposition": {mime.FormatMediaType(disposition, map[string]string{"name": "stego_file", "filename": result.Filename})},
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	if _, err := filePart.Write(stegoAudio); err != nil {
+		return nil, "", err
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, "", err
+	}
+
+	return body.Bytes(), "multipart/mixed; boundary=" + writer.Boundary(), nil
+}
+
+// extractRequest is a parsed extraction form: the config, the method it
+// selects and the uploaded stego file
+type extractRequest struct {
+	config *models.StegoConfig
+	method stego.MP3Steganography
+	audio  []byte
+	header *multipart.FileHeader
+}
+
+// readExtractRequest parses the extraction form shared by the extract and
+// verify endpoints. On failure the error response has already been written.
+func (h *StegoHandler) readExtractRequest(c *gin.Context) (*extractRequest, bool) {
+	request, ok := h.readExtractConfig(c)
+	if !ok {
+		return nil, false
+	}
+
+	stegoFile, stegoHeader, err := c.Request.FormFile("stego_file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ExtractResponse{
+			Success: false,
+			Message: "Stego audio file is required",
+		})
+		return nil, false
+	}
+	defer stegoFile.Close()
+
+	stegoAudio, ok := readStegoUpload(c, stegoFile, stegoHeader)
+	if !ok {
+		return nil, false
+	}
+
+	request.audio = stegoAudio
+	request.header = stegoHeader
+	return request, true
+}
+
+// readStegoUpload reads one uploaded stego file. On failure the error
+// response has already been written.
+func readStegoUpload(c *gin.Context, stegoFile io.Reader, stegoHeader *multipart.FileHeader) ([]byte, bool) {
+	if !isValidMP3File(stegoHeader.Filename) {
+		c.JSON(http.StatusBadRequest, models.ExtractResponse{
+			Success: false,
+			Message: "Invalid audio file format. Only MP3 and WAV files are supported for extraction",
+		})
+		return nil, false
+	}
+
+	stegoAudio, err := io.ReadAll(stegoFile)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ExtractResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to read stego audio file: %v", err),
+		})
+		return nil, false
+	}
+
+	return stegoAudio, true
+}
+
+// readExtractConfig parses the key and method fields of an extraction form
+// into a request without the stego file. On failure the error response has
+// already been written.
+func (h *StegoHandler) readExtractConfig(c *gin.Context) (*extractRequest, bool) {
 	if err := c.Request.ParseMultipartForm(32 << 20); err != nil { // 32MB limit
 		c.JSON(http.StatusBadRequest, models.ExtractResponse{
 			Success: false,
 			Message: fmt.Sprintf("Failed to parse form: %v", err),
 		})
-		return
+		return nil, false
 	}
 
 	key := c.PostForm("key")
@@ -219,7 +764,7 @@ func (h *StegoHandler) ExtractMessage(c *gin.Context) {
 			Success: false,
 			Message: "Key is required",
 		})
-		return
+		return nil, false
 	}
 
 	if err := crypto.ValidateKey(key); err != nil {
@@ -227,7 +772,7 @@ func (h *StegoHandler) ExtractMessage(c *gin.Context) {
 			Success: false,
 			Message: fmt.Sprintf("Invalid key: %v", err),
 		})
-		return
+		return nil, false
 	}
 
 	lsbBits, err := strconv.Atoi(lsbBitsStr)
@@ -236,34 +781,37 @@ func (h *StegoHandler) ExtractMessage(c *gin.Context) {
 			Success: false,
 			Message: "LSB bits must be between 1 and 4",
 		})
-		return
+		return nil, false
 	}
 
-	stegoFile, stegoHeader, err := c.Request.FormFile("stego_file")
-	if err != nil {
-		c.JSON(http.StatusBadRequest, models.ExtractResponse{
-			Success: false,
-			Message: "Stego audio file is required",
-		})
-		return
+	maxOutputBytes := 0
+	if value := c.PostForm("max_output_bytes"); value != "" {
+		maxOutputBytes, err = strconv.Atoi(value)
+		if err != nil || maxOutputBytes < 1 {
+			c.JSON(http.StatusBadRequest, models.ExtractResponse{
+				Success: false,
+				Message: "Max output bytes must be a positive integer",
+			})
+			return nil, false
+		}
 	}
-	defer stegoFile.Close()
 
-	if !isValidMP3File(stegoHeader.Filename) {
+	maxFilenameBytes, err := parseMaxFilenameBytes(c)
+	if err != nil {
 		c.JSON(http.StatusBadRequest, models.ExtractResponse{
 			Success: false,
-			Message: "Invalid audio file format. Only MP3 and WAV files are supported for extraction",
+			Message: err.Error(),
 		})
-		return
+		return nil, false
 	}
 
-	stegoAudio, err := io.ReadAll(stegoFile)
+	positions, status, err := h.readPositionsFile(c)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.ExtractResponse{
+		c.JSON(status, models.ExtractResponse{
 			Success: false,
-			Message: fmt.Sprintf("Failed to read stego audio file: %v", err),
+			Message: err.Error(),
 		})
-		return
+		return nil, false
 	}
 
 	config := &models.StegoConfig{
@@ -271,19 +819,52 @@ func (h *StegoHandler) ExtractMessage(c *gin.Context) {
 		UseEncryption:  useEncryption,
 		UseRandomStart: useRandomStart,
 		LSBBits:        lsbBits,
+		Marker:         h.marker,
+		Positions:      positions,
+		MaxOutputBytes: maxOutputBytes,
+		IgnoreExpiry:   c.PostForm("ignore_expiry") == "true",
+		CheckLegacy:    c.PostForm("legacy") == "true",
+
+		MaxFilenameBytes: maxFilenameBytes,
+	}
+
+	if err := parseMethodOptions(c, config); err != nil {
+		c.JSON(http.StatusBadRequest, models.ExtractResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return nil, false
 	}
 
-	// Extract from MP3 ancillary areas only
-	mp3Stego := stego.NewMP3AncillaryLSBSteganography(config)
-	secretData, secretFilename, err := mp3Stego.ExtractFromMP3(stegoAudio)
+	// Extract with the method used for embedding
+	mp3Stego, err := stego.NewMP3Steganography(config)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.ExtractResponse{
+		c.JSON(http.StatusBadRequest, models.ExtractResponse{
 			Success: false,
-			Message: fmt.Sprintf("Failed to extract secret data: %v", err),
+			Message: fmt.Sprintf("Invalid method: %v", err),
 		})
+		return nil, false
+	}
+
+	return &extractRequest{config: config, method: mp3Stego}, true
+}
+
+func (h *StegoHandler) ExtractMessage(c *gin.Context) {
+	timer := newStageTimer()
+
+	request, ok := h.readExtractRequest(c)
+	if !ok {
+		return
+	}
+	timer.mark("parse")
+
+	payload, err := request.method.ExtractPayloadFromMP3(request.audio)
+	if err != nil {
+		writeExtractError(c, err)
 		return
 	}
 
+	secretData, secretFilename := payload.Data, payload.Filename
 	if len(secretData) == 0 {
 		c.JSON(http.StatusInternalServerError, models.ExtractResponse{
 			Success: false,
@@ -295,33 +876,984 @@ func (h *StegoHandler) ExtractMessage(c *gin.Context) {
 	// Set headers for file download
 	c.Header("Content-Description", "File Transfer")
 	c.Header("Content-Transfer-Encoding", "binary")
-	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", secretFilename))
-	c.Header("Content-Type", "application/octet-stream")
+	if secretFilename == "" {
+		secretFilename = fallbackFilename(c.PostForm("fallback_filename"), payload.MIMEType)
+	}
+	c.Header("Content-Disposition", contentDisposition(DispositionAttachment, secretFilename))
+	contentType := payload.MIMEType
+	if contentType == "" {
+		contentType = stego.DefaultMIMEType
+	}
+	c.Header("Content-Type", contentType)
 	c.Header("Content-Length", fmt.Sprintf("%d", len(secretData)))
+	if payload.Metadata != nil {
+		// Base64 keeps arbitrary JSON (newlines, non-ASCII) header safe
+		c.Header("X-Stego-Metadata", base64.StdEncoding.EncodeToString(payload.Metadata))
+	}
+	if payload.Method != "" {
+		c.Header("X-Stego-Method", payload.Method)
+	}
+	if payload.Placement != "" {
+		c.Header("X-Stego-Placement", payload.Placement)
+	}
+	if payload.FileID != "" {
+		c.Header("X-Stego-File-ID", payload.FileID)
+	}
+	if !payload.Expires.IsZero() {
+		c.Header("X-Stego-Expires-At", payload.Expires.Format(time.RFC3339))
+	}
+	if payload.Part != nil {
+		c.Header("X-Stego-Part", fmt.Sprintf("%d/%d", payload.Part.Index+1, payload.Part.Count))
+	}
+	warningList(payload.Warnings).writeHeader(c)
+	timer.mark("extract")
+	timer.writeHeaders(c)
 
-	c.Data(http.StatusOK, "application/octet-stream", secretData)
-}
-
-func isValidMP3File(filename string) bool {
-	ext := strings.ToLower(filepath.Ext(filename))
-	return ext == ".mp3"
+	h.sendOutput(c, contentType, secretData, true)
 }
 
-func bytesToFloat64(data []byte) []float64 {
-	if len(data)%2 != 0 {
-		// Handle odd length by ignoring the last byte
-		data = data[:len(data)-1]
+// UpdateHeader replaces the filename and/or metadata stored with an embedded
+// secret without re-embedding it, and returns the updated stego MP3
+func (h *StegoHandler) UpdateHeader(c *gin.Context) {
+	request, ok := h.readExtractRequest(c)
+	if !ok {
+		return
 	}
 
-	samples := make([]float64, len(data)/2)
-	for i := range samples {
-		// Read little-endian 16-bit sample
-		low := int16(data[i*2])
-		high := int16(data[i*2+1])
-		sample := low | (high << 8)
-
-		// Convert to float64 normalized to [-1.0, 1.0]
-		samples[i] = float64(sample) / 32768.0
+	var update stego.HeaderUpdate
+	if values, ok := c.GetPostFormArray("secret_filename"); ok {
+		update.Filename = &values[0]
 	}
-	return samples
+	if metadata := c.PostForm("metadata"); metadata != "" {
+		update.Metadata = json.RawMessage(metadata)
+	}
+	if update.Filename == nil && update.Metadata == nil {
+		c.JSON(http.StatusBadRequest, models.StegoResponse{
+			Success: false,
+			Message: "Nothing to update: provide secret_filename and/or metadata",
+		})
+		return
+	}
+
+	updatedAudio, err := request.method.UpdateHeaderInMP3(request.audio, update)
+	if err != nil {
+		status := http.StatusUnprocessableEntity
+		switch {
+		case errors.Is(err, stego.ErrNoPayload):
+			status = http.StatusNotFound
+		case errors.Is(err, stego.ErrKeyMismatch):
+			status = http.StatusForbidden
+		}
+		c.JSON(status, models.StegoResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to update payload header: %v", err),
+		})
+		return
+	}
+
+	c.Header("Content-Description", "File Transfer")
+	c.Header("Content-Transfer-Encoding", "binary")
+	c.Header("Content-Disposition", contentDisposition(DispositionAttachment, request.header.Filename))
+	c.Header("Content-Type", "audio/mpeg")
+	c.Header("Content-Length", fmt.Sprintf("%d", len(updatedAudio)))
+
+	h.sendOutput(c, "audio/mpeg", updatedAudio, true)
+}
+
+// AssembleSecret extracts the chunk held by every uploaded part of a split
+// secret, checks the set against the part manifest and returns the
+// reassembled secret
+func (h *StegoHandler) AssembleSecret(c *gin.Context) {
+	request, ok := h.readExtractConfig(c)
+	if !ok {
+		return
+	}
+
+	partHeaders := c.Request.MultipartForm.File["stego_files"]
+	if len(partHeaders) == 0 {
+		c.JSON(http.StatusBadRequest, models.ExtractResponse{
+			Success: false,
+			Message: "At least one stego audio file is required in stego_files",
+		})
+		return
+	}
+
+	payloads := make([]*stego.Payload, 0, len(partHeaders))
+	for _, partHeader := range partHeaders {
+		partFile, err := partHeader.Open()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, models.ExtractResponse{
+				Success: false,
+				Message: fmt.Sprintf("Failed to open %s: %v", partHeader.Filename, err),
+			})
+			return
+		}
+		stegoAudio, ok := readStegoUpload(c, partFile, partHeader)
+		partFile.Close()
+		if !ok {
+			return
+		}
+
+		payload, err := request.method.ExtractPayloadFromMP3(stegoAudio)
+		if err != nil {
+			writeExtractError(c, fmt.Errorf("part %s: %w", partHeader.Filename, err))
+			return
+		}
+		payloads = append(payloads, payload)
+	}
+
+	payload, err := stego.AssembleParts(payloads)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, models.ExtractResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to assemble secret: %v", err),
+		})
+		return
+	}
+
+	secretFilename := payload.Filename
+	if secretFilename == "" {
+		secretFilename = fallbackFilename(c.PostForm("fallback_filename"), payload.MIMEType)
+	}
+	contentType := payload.MIMEType
+	if contentType == "" {
+		contentType = stego.DefaultMIMEType
+	}
+	c.Header("Content-Description", "File Transfer")
+	c.Header("Content-Transfer-Encoding", "binary")
+	c.Header("Content-Disposition", contentDisposition(DispositionAttachment, secretFilename))
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Length", fmt.Sprintf("%d", len(payload.Data)))
+	c.Header("X-Stego-Parts", strconv.Itoa(payload.Part.Count))
+	if payload.Metadata != nil {
+		c.Header("X-Stego-Metadata", base64.StdEncoding.EncodeToString(payload.Metadata))
+	}
+	warningList(payload.Warnings).writeHeader(c)
+
+	h.sendOutput(c, contentType, payload.Data, true)
+}
+
+// CheckKey confirms the key against the embedded preamble without
+// reconstructing the secret, so clients can validate it before a download
+func (h *StegoHandler) CheckKey(c *gin.Context) {
+	request, ok := h.readExtractRequest(c)
+	if !ok {
+		return
+	}
+
+	err := request.method.CheckKeyInMP3(request.audio)
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, models.KeyCheckResponse{
+			Success: true,
+			Message: "Key matches the embedded data",
+			Valid:   true,
+		})
+	case errors.Is(err, stego.ErrKeyMismatch), errors.Is(err, stego.ErrNoPayload):
+		// The preamble only decodes with the right key, so a wrong key and a
+		// file without a payload cannot be told apart
+		c.JSON(http.StatusOK, models.KeyCheckResponse{
+			Success: true,
+			Message: "No data embedded with this key was found",
+		})
+	default:
+		c.JSON(http.StatusUnprocessableEntity, models.KeyCheckResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to check key: %v", err),
+		})
+	}
+}
+
+// VerifyMessage extracts the payload with the given key and reports its
+// stored parameters, provenance metadata and a fingerprint of the secret
+// without returning the secret itself
+func (h *StegoHandler) VerifyMessage(c *gin.Context) {
+	request, ok := h.readExtractRequest(c)
+	if !ok {
+		return
+	}
+
+	payload, err := request.method.ExtractPayloadFromMP3(request.audio)
+	if err != nil {
+		writeExtractError(c, err)
+		return
+	}
+
+	if len(payload.Data) == 0 {
+		c.JSON(http.StatusUnprocessableEntity, models.VerifyResponse{
+			Success: false,
+			Message: "No valid payload found: no secret data extracted",
+		})
+		return
+	}
+
+	method := payload.Method
+	if method == "" {
+		method = request.config.Method
+	}
+	if method == "" {
+		method = models.MethodAncillary
+	}
+
+	fingerprint := sha256.Sum256(payload.Data)
+	expiresAt := ""
+	if !payload.Expires.IsZero() {
+		expiresAt = payload.Expires.Format(time.RFC3339)
+	}
+	c.JSON(http.StatusOK, models.VerifyResponse{
+		Success:        true,
+		Message:        "Payload verified successfully",
+		SecretFilename: payload.Filename,
+		SecretSize:     len(payload.Data),
+		SecretMIMEType: payload.MIMEType,
+		SecretSHA256:   hex.EncodeToString(fingerprint[:]),
+		Method:         payload.Method,
+		LSBBits:        request.config.LSBBits,
+		Placement:      payload.Placement,
+		SeedHash:       payload.SeedHash,
+		Salt:           payload.Salt,
+		FileID:         payload.FileID,
+		ExpiresAt:      expiresAt,
+		Retagged:       payload.Retagged,
+		Metadata:       payload.Metadata,
+		Warnings:       payload.Warnings,
+
+		ParameterFingerprint: parameterFingerprint(method, request.config.LSBBits, payload.SeedHash),
+	})
+}
+
+// parameterFingerprint hashes the parameters a payload was embedded with, so
+// files marked with the same settings can be matched without comparing them
+// field by field
+func parameterFingerprint(method string, lsbBits int, seedHash string) string {
+	fingerprint := sha256.Sum256([]byte(fmt.Sprintf("method=%s\nlsb_bits=%d\nseed_hash=%s", method, lsbBits, seedHash)))
+	return hex.EncodeToString(fingerprint[:])
+}
+
+// CompareAudio decodes an original and a stego MP3 and reports the PSNR
+// between them. Files with different frame counts are compared over their
+// common region unless frame_mismatch=reject is given.
+func (h *StegoHandler) CompareAudio(c *gin.Context) {
+	if err := c.Request.ParseMultipartForm(64 << 20); err != nil { // 64MB limit for both files
+		c.JSON(http.StatusBadRequest, models.CompareResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to parse form: %v", err),
+		})
+		return
+	}
+
+	mismatchPolicy := c.DefaultPostForm("frame_mismatch", models.FrameMismatchAlign)
+	if mismatchPolicy != models.FrameMismatchAlign && mismatchPolicy != models.FrameMismatchReject {
+		c.JSON(http.StatusBadRequest, models.CompareResponse{
+			Success: false,
+			Message: fmt.Sprintf("Frame mismatch must be %q or %q", models.FrameMismatchAlign, models.FrameMismatchReject),
+		})
+		return
+	}
+
+	originalData, ok := readMP3FormFile(c, "original_file")
+	if !ok {
+		return
+	}
+	stegoData, ok := readMP3FormFile(c, "stego_file")
+	if !ok {
+		return
+	}
+
+	originalInfo, err := h.audioDecoder.AnalyzeMP3(originalData)
+	if writeUnsupportedFormat(c, err) {
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.CompareResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to analyze original MP3 file: %v", err),
+		})
+		return
+	}
+	stegoInfo, err := h.audioDecoder.AnalyzeMP3(stegoData)
+	if writeUnsupportedFormat(c, err) {
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.CompareResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to analyze stego MP3 file: %v", err),
+		})
+		return
+	}
+
+	response := models.CompareResponse{
+		OriginalFrames:  originalInfo.TotalFrames,
+		StegoFrames:     stegoInfo.TotalFrames,
+		FrameDifference: stegoInfo.TotalFrames - originalInfo.TotalFrames,
+	}
+
+	if response.FrameDifference != 0 && mismatchPolicy == models.FrameMismatchReject {
+		response.Message = fmt.Sprintf("Frame count mismatch: original has %d frames, stego has %d",
+			response.OriginalFrames, response.StegoFrames)
+		c.JSON(http.StatusBadRequest, response)
+		return
+	}
+
+	originalPCM, originalMeta, err := h.audioDecoder.DecodeMP3ToPCM(originalData)
+	if err != nil {
+		response.Message = fmt.Sprintf("Failed to decode original MP3 file: %v", err)
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	stegoPCM, stegoMeta, err := h.audioDecoder.DecodeMP3ToPCM(stegoData)
+	if err != nil {
+		response.Message = fmt.Sprintf("Failed to decode stego MP3 file: %v", err)
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+
+	originalPCM, stegoPCM = audio.AlignPCMBytes(originalPCM, stegoPCM, h.oddBytePolicy)
+	originalSamples, stegoSamples, channels, err := audio.AlignPCMSamplesToShorter(
+		audio.BytesToFloat64(originalPCM), originalMeta.Channels,
+		audio.BytesToFloat64(stegoPCM), stegoMeta.Channels,
+		originalInfo.ChannelMode,
+	)
+	if err != nil {
+		response.Message = fmt.Sprintf("Failed to align audio: %v", err)
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+
+	psnr := audio.CalculatePSNRForChannelMode(originalSamples, stegoSamples, channels, originalInfo.ChannelMode)
+	response.Success = true
+	response.Message = "Audio compared successfully"
+	response.ComparedSamples = len(originalSamples)
+	if math.IsInf(psnr, 1) {
+		response.Identical = true
+	} else {
+		response.PSNR = psnr
+	}
+	response.QualityGrade = audio.QualityGrade(psnr)
+	if response.FrameDifference != 0 {
+		response.Message = fmt.Sprintf("Audio compared over the common region; frame counts differ by %d", response.FrameDifference)
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// DiffAudio returns a binary diff of the bytes that differ between an
+// original and a stego MP3, for studying the embedding footprint
+func (h *StegoHandler) DiffAudio(c *gin.Context) {
+	if err := c.Request.ParseMultipartForm(64 << 20); err != nil { // 64MB limit for both files
+		c.JSON(http.StatusBadRequest, models.StegoResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to parse form: %v", err),
+		})
+		return
+	}
+
+	originalData, ok := readMP3FormFile(c, "original_file")
+	if !ok {
+		return
+	}
+	stegoData, ok := readMP3FormFile(c, "stego_file")
+	if !ok {
+		return
+	}
+
+	modifiedFrames, err := stego.CountModifiedFrames(originalData, stegoData)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.StegoResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to compare frames: %v", err),
+		})
+		return
+	}
+
+	diff, err := stego.DiffFiles(originalData, stegoData)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.StegoResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to diff files: %v", err),
+		})
+		return
+	}
+
+	c.Header("Content-Disposition", "attachment; filename=stego.diff")
+	c.Header("X-Diff-Runs", strconv.Itoa(diff.Runs))
+	c.Header("X-Diff-Changed-Bytes", strconv.Itoa(diff.ChangedBytes))
+	c.Header("X-Diff-Modified-Frames", strconv.Itoa(modifiedFrames))
+	c.Data(http.StatusOK, "application/octet-stream", diff.Bytes())
+}
+
+// readMP3FormFile reads an uploaded MP3 form file. On failure the error
+// response has already been written.
+func readMP3FormFile(c *gin.Context, field string) ([]byte, bool) {
+	file, header, err := c.Request.FormFile(field)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.CompareResponse{
+			Success: false,
+			Message: fmt.Sprintf("%s is required", field),
+		})
+		return nil, false
+	}
+	defer file.Close()
+
+	if !isValidMP3File(header.Filename) {
+		c.JSON(http.StatusBadRequest, models.CompareResponse{
+			Success: false,
+			Message: fmt.Sprintf("Invalid %s format. Only MP3 files are supported", field),
+		})
+		return nil, false
+	}
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.CompareResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to read %s: %v", field, err),
+		})
+		return nil, false
+	}
+
+	return data, true
+}
+
+func (h *StegoHandler) GenerateWaveform(c *gin.Context) {
+	if err := c.Request.ParseMultipartForm(32 << 20); err != nil { // 32MB limit
+		c.JSON(http.StatusBadRequest, models.StegoResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to parse form: %v", err),
+		})
+		return
+	}
+
+	width, err := parseDimension(c.PostForm("width"), audio.DefaultWaveformWidth, audio.MaxWaveformWidth)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.StegoResponse{
+			Success: false,
+			Message: fmt.Sprintf("Invalid width: %v", err),
+		})
+		return
+	}
+
+	height, err := parseDimension(c.PostForm("height"), audio.DefaultWaveformHeight, audio.MaxWaveformHeight)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.StegoResponse{
+			Success: false,
+			Message: fmt.Sprintf("Invalid height: %v", err),
+		})
+		return
+	}
+
+	audioFile, audioHeader, err := c.Request.FormFile("audio_file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.StegoResponse{
+			Success: false,
+			Message: "Audio file is required",
+		})
+		return
+	}
+	defer audioFile.Close()
+
+	if !isValidMP3File(audioHeader.Filename) {
+		c.JSON(http.StatusBadRequest, models.StegoResponse{
+			Success: false,
+			Message: "Invalid audio file format. Only MP3 files are supported",
+		})
+		return
+	}
+
+	audioData, err := io.ReadAll(audioFile)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.StegoResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to read audio file: %v", err),
+		})
+		return
+	}
+
+	pcmData, _, err := h.audioDecoder.DecodeMP3ToPCM(audioData)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.StegoResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to decode MP3 file: %v", err),
+		})
+		return
+	}
+
+	image, err := audio.RenderWaveformPNG(pcmData, width, height)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.StegoResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to render waveform: %v", err),
+		})
+		return
+	}
+
+	c.Data(http.StatusOK, "image/png", image)
+}
+
+// AnalyzeCapacity reports the safe capacity next to the raw capacity of an MP3
+// so the cost of the safety reservations can be compared
+func (h *StegoHandler) AnalyzeCapacity(c *gin.Context) {
+	if err := c.Request.ParseMultipartForm(32 << 20); err != nil { // 32MB limit
+		c.JSON(http.StatusBadRequest, models.AnalyzeResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to parse form: %v", err),
+		})
+		return
+	}
+
+	decodeDuration := c.PostForm("decode_duration") == "true"
+
+	lsbBits := 1
+	if value := c.PostForm("lsb_bits"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > 4 {
+			c.JSON(http.StatusBadRequest, models.AnalyzeResponse{
+				Success: false,
+				Message: "LSB bits must be between 1 and 4",
+			})
+			return
+		}
+		lsbBits = parsed
+	}
+
+	audioFile, audioHeader, err := c.Request.FormFile("audio_file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.AnalyzeResponse{
+			Success: false,
+			Message: "Audio file is required",
+		})
+		return
+	}
+	defer audioFile.Close()
+
+	if !isValidMP3File(audioHeader.Filename) {
+		c.JSON(http.StatusBadRequest, models.AnalyzeResponse{
+			Success: false,
+			Message: "Invalid audio file format. Only MP3 files are supported",
+		})
+		return
+	}
+
+	audioData, err := io.ReadAll(audioFile)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.AnalyzeResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to read audio file: %v", err),
+		})
+		return
+	}
+
+	config := &models.StegoConfig{
+		LSBBits:        lsbBits,
+		Salt:           c.PostForm("salt"),
+		SecretFilename: c.PostForm("secret_filename"),
+		Marker:         h.marker,
+		ID3Checksum:    c.PostForm("id3_checksum") == "true",
+	}
+	if err := stego.ValidateSalt(config.Salt); err != nil {
+		c.JSON(http.StatusBadRequest, models.AnalyzeResponse{
+			Success: false,
+			Message: fmt.Sprintf("Invalid salt: %v", err),
+		})
+		return
+	}
+	// Insert also sniffs the content; without it only the extension is known
+	config.SecretMIMEType = mime.TypeByExtension(filepath.Ext(config.SecretFilename))
+
+	// Density, bit order and the frame-LSB reservation affect the per-method capacities
+	if err := parseMethodOptions(c, config); err != nil {
+		c.JSON(http.StatusBadRequest, models.AnalyzeResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	diagnostics, err := stego.AnalyzeCapacity(audioData, config)
+	if writeUnsupportedFormat(c, err) {
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.AnalyzeResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to analyze MP3 file: %v", err),
+		})
+		return
+	}
+
+	consistency, err := stego.CheckStreamConsistency(audioData)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.AnalyzeResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to analyze MP3 file: %v", err),
+		})
+		return
+	}
+
+	mp3Info, err := h.audioDecoder.AnalyzeMP3(audioData)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.AnalyzeResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to analyze MP3 file: %v", err),
+		})
+		return
+	}
+	duration, durationSource := mp3Info.Duration, models.DurationEstimated
+
+	// Decoding is exact but costs a full decode, so it is only done on request
+	if decodeDuration {
+		_, metadata, err := h.audioDecoder.DecodeMP3ToPCM(audioData)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, models.AnalyzeResponse{
+				Success: false,
+				Message: fmt.Sprintf("Failed to decode MP3 file: %v", err),
+			})
+			return
+		}
+		duration, durationSource = metadata.Duration, models.DurationDecoded
+	}
+
+	c.JSON(http.StatusOK, models.AnalyzeResponse{
+		Success:        true,
+		Message:        "Capacity analyzed successfully",
+		Capacity:       diagnostics,
+		Methods:        stego.MethodCapacities(audioData, config),
+		Consistency:    consistency,
+		Duration:       duration,
+		DurationSource: durationSource,
+	})
+}
+
+// sendOutput writes a binary response and takes ownership of data, which the
+// caller must not use afterwards. Outputs at or above the spool threshold are
+// written to a temporary file and streamed from disk; sendOutput drops its
+// reference to data once it is spooled, so the buffer can be collected while
+// a slow client downloads. The file is always removed.
+// Range requests for resumable outputs are always served from a spooled file
+// with a 206 Partial Content response, so interrupted downloads can be
+// resumed. Outputs that differ between identical requests are not resumable:
+// stitching ranges of two different files would corrupt the download, so
+// their Range headers are ignored and no Accept-Ranges is advertised.
+func (h *StegoHandler) sendOutput(c *gin.Context, contentType string, data []byte, resumable bool) {
+	ranged := resumable && c.GetHeader("Range") != ""
+	if !ranged && (h.spoolThreshold <= 0 || int64(len(data)) < h.spoolThreshold) {
+		c.Data(http.StatusOK, contentType, data)
+		return
+	}
+
+	c.Header("Content-Type", contentType)
+	size := len(data)
+
+	var content io.ReadSeeker
+	if file, err := spoolToTempFile(h.tmpDir, data); err != nil {
+		fmt.Printf("Warning: Could not spool output, sending from memory: %v\n", err)
+		content = bytes.NewReader(data)
+	} else {
+		defer os.Remove(file.Name())
+		defer file.Close()
+		content = file
+	}
+	// Only content refers to the output from here on
+	data = nil
+
+	if !resumable {
+		c.Header("Content-Length", strconv.Itoa(size))
+		c.Status(http.StatusOK)
+		io.Copy(c.Writer, content)
+		return
+	}
+	http.ServeContent(c.Writer, c.Request, "", time.Time{}, content)
+}
+
+// spoolToTempFile writes data to a new file in dir (the OS temp dir when
+// empty) and returns it open and rewound. The caller closes and removes the
+// file; nothing is left behind when spooling fails.
+func spoolToTempFile(dir string, data []byte) (*os.File, error) {
+	file, err := os.CreateTemp(dir, "stego-output-*")
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = file.Write(data)
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+	return file, nil
+}
+
+// calculatePSNR decodes both MP3s and compares them, aligning channel counts
+// and small length differences between the decodes
+func (h *StegoHandler) calculatePSNR(originalMP3, stegoMP3 []byte, channelMode int) (float64, error) {
+	originalPCM, originalMeta, err := h.audioDecoder.DecodeMP3ToPCM(originalMP3)
+	if err != nil {
+		return 0, fmt.Errorf("original decode error: %v", err)
+	}
+
+	stegoPCM, stegoMeta, err := h.audioDecoder.DecodeMP3ToPCM(stegoMP3)
+	if err != nil {
+		return 0, fmt.Errorf("stego decode error: %v", err)
+	}
+
+	originalPCM, stegoPCM = audio.AlignPCMBytes(originalPCM, stegoPCM, h.oddBytePolicy)
+	originalSamples, stegoSamples, channels, err := audio.AlignPCMSamples(
+		audio.BytesToFloat64(originalPCM), originalMeta.Channels,
+		audio.BytesToFloat64(stegoPCM), stegoMeta.Channels,
+		channelMode,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return audio.CalculatePSNRForChannelMode(originalSamples, stegoSamples, channels, channelMode), nil
+}
+
+// writeUnsupportedFormat answers 415 with the parser's message when err means
+// the MP3 uses an MPEG version or layer the parser does not support yet, and
+// reports whether it did
+func writeUnsupportedFormat(c *gin.Context, err error) bool {
+	var format *mp3parser.UnsupportedFormatError
+	if !errors.As(err, &format) {
+		return false
+	}
+	c.JSON(http.StatusUnsupportedMediaType, models.StegoResponse{
+		Success: false,
+		Message: format.Error(),
+	})
+	return true
+}
+
+// writeExtractError answers a failed extraction: 404 when the file holds no
+// payload, 403 when the key does not match, 410 when the payload expired, 413
+// when it exceeds the output limit and 415 for an unsupported format. Anything
+// else is a 422; a truncated payload still reports the filename and size its
+// header promised
+func writeExtractError(c *gin.Context, err error) {
+	if writeUnsupportedFormat(c, err) {
+		return
+	}
+
+	response := models.ExtractResponse{
+		Success: false,
+		Message: fmt.Sprintf("Failed to extract secret data: %v", err),
+	}
+	status := http.StatusUnprocessableEntity
+	var expired *stego.ExpiredPayloadError
+	var limit *stego.OutputLimitError
+	var truncated *stego.TruncatedPayloadError
+	switch {
+	case errors.Is(err, stego.ErrNoPayload):
+		status = http.StatusNotFound
+		response.Message = "No embedded data found in this file"
+	case errors.Is(err, stego.ErrKeyMismatch):
+		status = http.StatusForbidden
+		response.Message = "The key does not match the embedded data"
+	case errors.As(err, &expired):
+		status = http.StatusGone
+	case errors.As(err, &limit):
+		status = http.StatusRequestEntityTooLarge
+	case errors.As(err, &truncated):
+		c.Header("X-Stego-Filename", truncated.Filename)
+		c.Header("X-Stego-Expected-Size", strconv.Itoa(truncated.ExpectedSize))
+		response.SecretFilename = truncated.Filename
+		response.ExpectedSize = truncated.ExpectedSize
+	}
+	c.JSON(status, response)
+}
+
+func isValidMP3File(filename string) bool {
+	ext := strings.ToLower(filepath.Ext(filename))
+	return ext == ".mp3"
+}
+
+// contentDisposition formats a Content-Disposition value. Printable ASCII
+// filenames are always sent as a quoted string, since some clients mishandle
+// bare tokens; other names switch to RFC 2231 encoding. A value that cannot
+// be formatted drops the filename rather than the header.
+func contentDisposition(disposition, filename string) string {
+	if isPrintableASCII(filename) {
+		return disposition + "; filename=" + strconv.Quote(filename)
+	}
+	if value := mime.FormatMediaType(disposition, map[string]string{"filename": filename}); value != "" {
+		return value
+	}
+	return disposition
+}
+
+func isPrintableASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < 0x20 || s[i] > 0x7E {
+			return false
+		}
+	}
+	return true
+}
+
+// fallbackFilename names an extracted secret stored without a filename: the
+// client supplied name when it is a plain file name, otherwise "extracted"
+// with .txt for text secrets and .bin for everything else
+func fallbackFilename(requested, mimeType string) string {
+	if requested != "" && requested == filepath.Base(requested) && !strings.ContainsAny(requested, "\"\\;\r\n") {
+		return requested
+	}
+
+	if strings.HasPrefix(mimeType, "text/") {
+		return "extracted.txt"
+	}
+	return "extracted.bin"
+}
+
+// lowCapacityGuidance suggests how to obtain more capacity than the ancillary
+// method offers for the given cover
+func lowCapacityGuidance(mp3Info *audio.MP3Info) string {
+	if mp3Info.Bitrate < RecommendedCoverBitrate {
+		return fmt.Sprintf("The cover is encoded at only %d kbps; use a higher-bitrate cover (%d kbps or more), "+
+			"re-encode the cover at a higher bitrate, or use the %q method which embeds into the frame data itself",
+			mp3Info.Bitrate/1000, RecommendedCoverBitrate/1000, models.MethodFrameLSB)
+	}
+
+	return fmt.Sprintf("Use a longer cover or the %q method which embeds into the frame data itself",
+		models.MethodFrameLSB)
+}
+
+// parseMethodOptions reads the density, the bit order, the embedding method
+// and, for the frame-LSB method, its reservation strategy from the form into
+// the config
+func parseMethodOptions(c *gin.Context, config *models.StegoConfig) error {
+	config.BitOrder = c.DefaultPostForm("bit_order", models.BitOrderLowToHigh)
+	if err := stego.ValidateBitOrder(config.BitOrder); err != nil {
+		return fmt.Errorf("Invalid bit order: %v", err)
+	}
+
+	if densityStr := c.PostForm("density"); densityStr != "" {
+		density, err := strconv.Atoi(densityStr)
+		if err != nil || stego.ValidateDensity(density) != nil {
+			return fmt.Errorf("Density must be between 1 and %d", stego.MaxDensity)
+		}
+		config.Density = density
+	}
+
+	config.Method = c.DefaultPostForm("method", models.MethodAncillary)
+	if config.Method != models.MethodFrameLSB && config.Method != models.MethodAuto {
+		return nil
+	}
+
+	config.FrameReservation = c.DefaultPostForm("frame_reservation", models.ReserveSideInfo)
+	config.FrameReservedBytes = stego.DefaultFrameReservedBytes
+	if reservedBytesStr := c.PostForm("frame_reserved_bytes"); reservedBytesStr != "" {
+		reservedBytes, err := strconv.Atoi(reservedBytesStr)
+		if err != nil {
+			return fmt.Errorf("Frame reserved bytes must be a number")
+		}
+		config.FrameReservedBytes = reservedBytes
+	}
+
+	if err := stego.ValidateFrameReservation(config.FrameReservation, config.FrameReservedBytes); err != nil {
+		return fmt.Errorf("Invalid frame reservation: %v", err)
+	}
+
+	return nil
+}
+
+// parseMaxFilenameBytes reads the optional "max_filename_bytes" limit on the
+// stored secret filename; 0 keeps the default
+func parseMaxFilenameBytes(c *gin.Context) (int, error) {
+	value := c.PostForm("max_filename_bytes")
+	if value == "" {
+		return 0, nil
+	}
+
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 1 || stego.ValidateMaxFilenameBytes(limit) != nil {
+		return 0, fmt.Errorf("Max filename bytes must be between 1 and %d", stego.MaxFilenameBytes)
+	}
+	return limit, nil
+}
+
+// filenameLimit resolves a parsed filename limit to the one in effect
+func filenameLimit(maxFilenameBytes int) int {
+	if maxFilenameBytes > 0 {
+		return maxFilenameBytes
+	}
+	return stego.DefaultMaxFilenameBytes
+}
+
+// parsePartManifest reads the optional "part_index", "part_count" and
+// "part_sha256" fields marking the secret as one chunk of a split secret.
+// They must be given together; nil is returned when none is set.
+func parsePartManifest(c *gin.Context) (*models.PartManifest, error) {
+	indexStr, countStr, hashStr := c.PostForm("part_index"), c.PostForm("part_count"), c.PostForm("part_sha256")
+	if indexStr == "" && countStr == "" && hashStr == "" {
+		return nil, nil
+	}
+	if indexStr == "" || countStr == "" || hashStr == "" {
+		return nil, fmt.Errorf("Part fields part_index, part_count and part_sha256 must be given together")
+	}
+
+	part := &models.PartManifest{}
+	var err error
+	if part.Index, err = strconv.Atoi(indexStr); err != nil {
+		return nil, fmt.Errorf("Part index must be a number")
+	}
+	if part.Count, err = strconv.Atoi(countStr); err != nil {
+		return nil, fmt.Errorf("Part count must be a number")
+	}
+	if err := stego.ValidatePart(part); err != nil {
+		return nil, fmt.Errorf("Invalid part: %v", err)
+	}
+
+	hash, err := hex.DecodeString(hashStr)
+	if err != nil || len(hash) != len(part.SHA256) {
+		return nil, fmt.Errorf("Part SHA-256 must be %d hex characters", 2*len(part.SHA256))
+	}
+	copy(part.SHA256[:], hash)
+
+	return part, nil
+}
+
+// readPositionsFile reads the optional "positions_file" upload, a JSON array of
+// safe-byte positions overriding the generated ones. It is only honored when
+// debug mode is enabled; the positions are range-checked by the stego codec.
+func (h *StegoHandler) readPositionsFile(c *gin.Context) ([]int, int, error) {
+	positionsFile, _, err := c.Request.FormFile("positions_file")
+	if err == http.ErrMissingFile {
+		return nil, http.StatusOK, nil
+	}
+	if err != nil {
+		return nil, http.StatusBadRequest, fmt.Errorf("Failed to read positions file: %v", err)
+	}
+	defer positionsFile.Close()
+
+	if !h.debugEnabled {
+		return nil, http.StatusForbidden, fmt.Errorf("Explicit positions are only available when STEGO_DEBUG=true")
+	}
+
+	var positions []int
+	if err := json.NewDecoder(positionsFile).Decode(&positions); err != nil {
+		return nil, http.StatusBadRequest, fmt.Errorf("Invalid positions file: %v", err)
+	}
+	if len(positions) == 0 {
+		return nil, http.StatusBadRequest, fmt.Errorf("Invalid positions file: no positions given")
+	}
+
+	return positions, http.StatusOK, nil
+}
+
+// parseDimension parses an optional positive integer form value bounded by max
+func parseDimension(value string, defaultValue, max int) (int, error) {
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 || n > max {
+		return 0, fmt.Errorf("must be between 1 and %d", max)
+	}
+
+	return n, nil
 }
@@ -0,0 +1,1334 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"steganography-backend/audio"
+	"steganography-backend/models"
+	"steganography-backend/mp3parser"
+	"steganography-backend/stego"
+)
+
+const testCoverPath = "../../test_cases/file_example_MP3_700KB.mp3"
+
+// upload is a file part of a multipart test request
+type upload struct {
+	field    string
+	filename string
+	data     []byte
+}
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+func newTestHandler() *StegoHandler {
+	return &StegoHandler{
+		audioDecoder:  audio.NewAudioDecoder(),
+		oddBytePolicy: audio.OddBytesTruncate,
+		marker:        stego.DefaultMarker,
+	}
+}
+
+func readCover(t *testing.T) []byte {
+	t.Helper()
+	data, err := os.ReadFile(testCoverPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func newMultipartRequest(t *testing.T, target string, fields map[string]string, files ...upload) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for name, value := range fields {
+		if err := writer.WriteField(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, file := range files {
+		part, err := writer.CreateFormFile(file.field, file.filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write(file.data)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, target, &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req
+}
+
+// serve runs the handlers as one route and records the response
+func serve(req *http.Request, handlers ...gin.HandlerFunc) *httptest.ResponseRecorder {
+	router := gin.New()
+	router.Handle(req.Method, req.URL.Path, handlers...)
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestGenerateWaveformDimensions(t *testing.T) {
+	cover := readCover(t)
+	tests := []struct {
+		name                  string
+		width, height         string
+		wantStatus            int
+		wantWidth, wantHeight int
+	}{
+		{name: "defaults", wantStatus: http.StatusOK, wantWidth: audio.DefaultWaveformWidth, wantHeight: audio.DefaultWaveformHeight},
+		{name: "requested size", width: "320", height: "48", wantStatus: http.StatusOK, wantWidth: 320, wantHeight: 48},
+		{name: "too wide", width: strconv.Itoa(audio.MaxWaveformWidth + 1), wantStatus: http.StatusBadRequest},
+		{name: "not a number", height: "tall", wantStatus: http.StatusBadRequest},
+	}
+
+	h := newTestHandler()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fields := map[string]string{}
+			if tt.width != "" {
+				fields["width"] = tt.width
+			}
+			if tt.height != "" {
+				fields["height"] = tt.height
+			}
+			req := newMultipartRequest(t, "/waveform", fields, upload{"audio_file", "cover.mp3", cover})
+			resp := serve(req, h.GenerateWaveform)
+
+			if resp.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", resp.Code, tt.wantStatus, resp.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			if ct := resp.Header().Get("Content-Type"); ct != "image/png" {
+				t.Errorf("Content-Type = %q, want image/png", ct)
+			}
+			img, err := png.Decode(resp.Body)
+			if err != nil {
+				t.Fatalf("not a valid PNG: %v", err)
+			}
+			if bounds := img.Bounds(); bounds.Dx() != tt.wantWidth || bounds.Dy() != tt.wantHeight {
+				t.Errorf("size = %dx%d, want %dx%d", bounds.Dx(), bounds.Dy(), tt.wantWidth, tt.wantHeight)
+			}
+		})
+	}
+}
+
+// embedForTest embeds secret into the test cover with the config
+func embedForTest(t *testing.T, config *models.StegoConfig, secret []byte) []byte {
+	t.Helper()
+	method, err := stego.NewMP3Steganography(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stegoData, err := method.EmbedInMP3(readCover(t), secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stegoData
+}
+
+func TestExtractPositionsFileRequiresDebug(t *testing.T) {
+	positions := []int{900, 13, 400, 77, 2500}
+	for i := 0; len(positions) < 300; i++ {
+		positions = append(positions, 1000+i*3)
+	}
+	secret := []byte("hand placed")
+	stegoData := embedForTest(t, &models.StegoConfig{Key: "debug-key", LSBBits: 1, Positions: positions}, secret)
+	positionsJSON, err := json.Marshal(positions)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		debug      bool
+		positions  []byte
+		wantStatus int
+	}{
+		{name: "debug disabled", debug: false, positions: positionsJSON, wantStatus: http.StatusForbidden},
+		{name: "debug enabled", debug: true, positions: positionsJSON, wantStatus: http.StatusOK},
+		{name: "empty list", debug: true, positions: []byte("[]"), wantStatus: http.StatusBadRequest},
+		{name: "not JSON", debug: true, positions: []byte("1,2,3"), wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandler()
+			h.debugEnabled = tt.debug
+			req := newMultipartRequest(t, "/extract", map[string]string{"key": "debug-key", "lsb_bits": "1"},
+				upload{"stego_file", "stego.mp3", stegoData},
+				upload{"positions_file", "positions.json", tt.positions})
+			resp := serve(req, h.ExtractMessage)
+
+			if resp.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", resp.Code, tt.wantStatus, resp.Body.String())
+			}
+			if tt.wantStatus == http.StatusOK && !bytes.Equal(resp.Body.Bytes(), secret) {
+				t.Errorf("body = %q, want %q", resp.Body.Bytes(), secret)
+			}
+		})
+	}
+}
+
+func TestSpooledOutputMatchesMemory(t *testing.T) {
+	secret := bytes.Repeat([]byte("spooled secret "), 40)
+	stegoData := embedForTest(t, &models.StegoConfig{Key: "spool-key", LSBBits: 2, Marker: stego.DefaultMarker}, secret)
+
+	tests := []struct {
+		name    string
+		target  string
+		fields  map[string]string
+		files   []upload
+		handler func(h *StegoHandler) gin.HandlerFunc
+	}{
+		{
+			name:    "insert",
+			target:  "/insert",
+			fields:  map[string]string{"key": "spool-key", "lsb_bits": "2"},
+			files:   []upload{{"audio_file", "cover.mp3", readCover(t)}, {"secret_file", "secret.txt", secret}},
+			handler: func(h *StegoHandler) gin.HandlerFunc { return h.InsertMessage },
+		},
+		{
+			name:    "extract",
+			target:  "/extract",
+			fields:  map[string]string{"key": "spool-key", "lsb_bits": "2"},
+			files:   []upload{{"stego_file", "stego.mp3", stegoData}},
+			handler: func(h *StegoHandler) gin.HandlerFunc { return h.ExtractMessage },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inMemory := newTestHandler()
+			memoryResp := serve(newMultipartRequest(t, tt.target, tt.fields, tt.files...), tt.handler(inMemory))
+
+			spooling := newTestHandler()
+			spooling.spoolThreshold = 1
+			spooledResp := serve(newMultipartRequest(t, tt.target, tt.fields, tt.files...), tt.handler(spooling))
+
+			if memoryResp.Code != http.StatusOK || spooledResp.Code != http.StatusOK {
+				t.Fatalf("status = %d in memory, %d spooled: %s", memoryResp.Code, spooledResp.Code, spooledResp.Body.String())
+			}
+			if !bytes.Equal(spooledResp.Body.Bytes(), memoryResp.Body.Bytes()) {
+				t.Errorf("spooled body (%d bytes) differs from the in-memory body (%d bytes)", spooledResp.Body.Len(), memoryResp.Body.Len())
+			}
+			for _, header := range []string{"Content-Type", "Content-Length"} {
+				if spooled, memory := spooledResp.Header().Get(header), memoryResp.Header().Get(header); spooled != memory {
+					t.Errorf("%s = %q spooled, %q in memory", header, spooled, memory)
+				}
+			}
+		})
+	}
+}
+
+// releaseProbe records whether an output buffer has been collected by the
+// time the response body starts
+type releaseProbe struct {
+	*httptest.ResponseRecorder
+	collected <-chan struct{}
+	probed    bool
+	released  bool
+}
+
+func (p *releaseProbe) Write(data []byte) (int, error) {
+	if !p.probed {
+		p.probed = true
+		for i := 0; i < 50 && !p.released; i++ {
+			runtime.GC()
+			select {
+			case <-p.collected:
+				p.released = true
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}
+	return p.ResponseRecorder.Write(data)
+}
+
+func TestSpooledOutputIsReleased(t *testing.T) {
+	tests := []struct {
+		name      string
+		resumable bool
+	}{
+		{name: "streamed", resumable: false},
+		{name: "resumable", resumable: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			collected := make(chan struct{})
+			output := func() []byte {
+				data := bytes.Repeat([]byte{0xFF, 0xFB}, 1<<16)
+				runtime.SetFinalizer(&data[0], func(*byte) { close(collected) })
+				return data
+			}
+
+			h := newTestHandler()
+			h.spoolThreshold, h.tmpDir = 1024, t.TempDir()
+			router := gin.New()
+			router.GET("/output", func(c *gin.Context) { h.sendOutput(c, "audio/mpeg", output(), tt.resumable) })
+			probe := &releaseProbe{ResponseRecorder: httptest.NewRecorder(), collected: collected}
+			router.ServeHTTP(probe, httptest.NewRequest(http.MethodGet, "/output", nil))
+
+			if probe.Code != http.StatusOK || probe.Body.Len() != 1<<17 {
+				t.Fatalf("status = %d with %d bytes, want 200 with %d", probe.Code, probe.Body.Len(), 1<<17)
+			}
+			if !probe.released {
+				t.Error("the output buffer was still referenced while the spooled file was streamed")
+			}
+		})
+	}
+}
+
+func TestVerifyMessage(t *testing.T) {
+	secret := []byte("verified without delivery")
+	stegoData := embedForTest(t, &models.StegoConfig{
+		Key:            "verify-key",
+		LSBBits:        2,
+		Marker:         stego.DefaultMarker,
+		SecretFilename: "proof.txt",
+		Metadata:       []byte(`{"owner":"us"}`),
+	}, secret)
+	fingerprint := sha256.Sum256(secret)
+
+	tests := []struct {
+		name       string
+		key        string
+		audio      []byte
+		wantStatus int
+	}{
+		{name: "right key", key: "verify-key", audio: stegoData, wantStatus: http.StatusOK},
+		{name: "wrong key", key: "other-key", audio: stegoData, wantStatus: http.StatusNotFound},
+		{name: "plain cover", key: "verify-key", audio: readCover(t), wantStatus: http.StatusNotFound},
+	}
+
+	h := newTestHandler()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := newMultipartRequest(t, "/verify", map[string]string{"key": tt.key, "lsb_bits": "2"},
+				upload{"stego_file", "stego.mp3", tt.audio})
+			resp := serve(req, h.VerifyMessage)
+
+			if resp.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", resp.Code, tt.wantStatus, resp.Body.String())
+			}
+			if bytes.Contains(resp.Body.Bytes(), secret) {
+				t.Fatalf("response carries the secret: %s", resp.Body.String())
+			}
+			var result models.VerifyResponse
+			if err := json.Unmarshal(resp.Body.Bytes(), &result); err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			if result.SecretSHA256 != fmt.Sprintf("%x", fingerprint) {
+				t.Errorf("secret_sha256 = %s, want %x", result.SecretSHA256, fingerprint)
+			}
+			if result.SecretSize != len(secret) || result.SecretFilename != "proof.txt" {
+				t.Errorf("secret = %s (%d bytes), want proof.txt (%d bytes)", result.SecretFilename, result.SecretSize, len(secret))
+			}
+			if string(result.Metadata) != `{"owner":"us"}` {
+				t.Errorf("metadata = %s", result.Metadata)
+			}
+			if want := parameterFingerprint(models.MethodAncillary, 2, result.SeedHash); result.ParameterFingerprint != want {
+				t.Errorf("parameter_fingerprint = %s, want %s", result.ParameterFingerprint, want)
+			}
+		})
+	}
+}
+
+func TestWriteExtractError(t *testing.T) {
+	tests := []struct {
+		name         string
+		err          error
+		wantStatus   int
+		wantFilename string
+	}{
+		{name: "no payload", err: stego.ErrNoPayload, wantStatus: http.StatusNotFound},
+		{name: "wrong key", err: fmt.Errorf("part a.mp3: %w", stego.ErrKeyMismatch), wantStatus: http.StatusForbidden},
+		{name: "expired", err: &stego.ExpiredPayloadError{}, wantStatus: http.StatusGone},
+		{name: "output limit", err: &stego.OutputLimitError{Declared: 10, Limit: 5}, wantStatus: http.StatusRequestEntityTooLarge},
+		{name: "layer II", err: &mp3parser.UnsupportedFormatError{Layer: mp3parser.LayerII}, wantStatus: http.StatusUnsupportedMediaType},
+		{
+			name:         "truncated",
+			err:          &stego.TruncatedPayloadError{Filename: "cut.bin", ExpectedSize: 900, Available: 12},
+			wantStatus:   http.StatusUnprocessableEntity,
+			wantFilename: "cut.bin",
+		},
+		{name: "other", err: errors.New("no safe ancillary data found"), wantStatus: http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(resp)
+			writeExtractError(c, tt.err)
+
+			if resp.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", resp.Code, tt.wantStatus, resp.Body.String())
+			}
+			if got := resp.Header().Get("X-Stego-Filename"); got != tt.wantFilename {
+				t.Errorf("X-Stego-Filename = %q, want %q", got, tt.wantFilename)
+			}
+			if tt.wantFilename != "" && resp.Header().Get("X-Stego-Expected-Size") != "900" {
+				t.Errorf("X-Stego-Expected-Size = %q, want 900", resp.Header().Get("X-Stego-Expected-Size"))
+			}
+		})
+	}
+}
+
+func TestCheckKey(t *testing.T) {
+	stegoData := embedForTest(t, &models.StegoConfig{Key: "right-key", LSBBits: 2, Marker: stego.DefaultMarker}, []byte("checked"))
+	legacyData, err := os.ReadFile("../stego/testdata/legacy_random.mp3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacyFields := map[string]string{"use_encryption": "true", "use_random_start": "true"}
+	legacyAllowed := map[string]string{"use_encryption": "true", "use_random_start": "true", "legacy": "true"}
+
+	tests := []struct {
+		name       string
+		key        string
+		audio      []byte
+		extra      map[string]string
+		wantStatus int
+		wantValid  bool
+	}{
+		{name: "right key", key: "right-key", audio: stegoData, wantStatus: http.StatusOK, wantValid: true},
+		{name: "wrong key", key: "wrong-key", audio: stegoData, wantStatus: http.StatusOK},
+		{name: "plain cover", key: "right-key", audio: readCover(t), wantStatus: http.StatusOK},
+		{name: "missing key", key: "", audio: stegoData, wantStatus: http.StatusBadRequest},
+		{name: "legacy file", key: "legacy-key", audio: legacyData, extra: legacyFields, wantStatus: http.StatusOK},
+		{name: "legacy file, legacy allowed", key: "legacy-key", audio: legacyData, extra: legacyAllowed, wantStatus: http.StatusOK, wantValid: true},
+		{name: "legacy file, legacy allowed, wrong key", key: "wrong-key", audio: legacyData, extra: legacyAllowed, wantStatus: http.StatusOK},
+	}
+
+	h := newTestHandler()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fields := map[string]string{"key": tt.key, "lsb_bits": "2"}
+			for name, value := range tt.extra {
+				fields[name] = value
+			}
+			req := newMultipartRequest(t, "/check-key", fields, upload{"stego_file", "stego.mp3", tt.audio})
+			resp := serve(req, h.CheckKey)
+
+			if resp.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", resp.Code, tt.wantStatus, resp.Body.String())
+			}
+			var result models.KeyCheckResponse
+			if err := json.Unmarshal(resp.Body.Bytes(), &result); err != nil {
+				t.Fatal(err)
+			}
+			if result.Valid != tt.wantValid {
+				t.Errorf("valid = %v, want %v: %s", result.Valid, tt.wantValid, result.Message)
+			}
+		})
+	}
+}
+
+func TestLimitConcurrency(t *testing.T) {
+	tests := []struct {
+		name       string
+		limit      int // 0 disables the limit
+		busy       int // Slots already taken by requests in flight
+		wantStatus int
+	}{
+		{name: "no limit", limit: 0, wantStatus: http.StatusOK},
+		{name: "idle", limit: 2, busy: 0, wantStatus: http.StatusOK},
+		{name: "one slot left", limit: 2, busy: 1, wantStatus: http.StatusOK},
+		{name: "saturated", limit: 2, busy: 2, wantStatus: http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandler()
+			if tt.limit > 0 {
+				h.slots = make(chan struct{}, tt.limit)
+			}
+			for i := 0; i < tt.busy; i++ {
+				h.slots <- struct{}{}
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/embed", nil)
+			resp := serve(req, h.LimitConcurrency(), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+			if resp.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", resp.Code, tt.wantStatus)
+			}
+			retryAfter := resp.Header().Get("Retry-After")
+			if tt.wantStatus == http.StatusServiceUnavailable && retryAfter != strconv.Itoa(ConcurrencyRetryAfter) {
+				t.Errorf("Retry-After = %q, want %d", retryAfter, ConcurrencyRetryAfter)
+			}
+			if len(h.slots) != tt.busy {
+				t.Errorf("%d slots taken after the request, want %d", len(h.slots), tt.busy)
+			}
+		})
+	}
+}
+
+func TestLimitConcurrencyInFlight(t *testing.T) {
+	h := newTestHandler()
+	h.slots = make(chan struct{}, 1)
+
+	entered, release := make(chan struct{}, 1), make(chan struct{})
+	router := gin.New()
+	router.POST("/embed", h.LimitConcurrency(), func(c *gin.Context) {
+		entered <- struct{}{}
+		<-release
+		c.Status(http.StatusOK)
+	})
+
+	first := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		router.ServeHTTP(first, httptest.NewRequest(http.MethodPost, "/embed", nil))
+		close(done)
+	}()
+	<-entered
+
+	second := httptest.NewRecorder()
+	router.ServeHTTP(second, httptest.NewRequest(http.MethodPost, "/embed", nil))
+	close(release)
+	<-done
+
+	if second.Code != http.StatusServiceUnavailable {
+		t.Errorf("request during the embed: status = %d, want 503", second.Code)
+	}
+	if first.Code != http.StatusOK {
+		t.Errorf("embed: status = %d, want 200", first.Code)
+	}
+
+	third := httptest.NewRecorder()
+	router.ServeHTTP(third, httptest.NewRequest(http.MethodPost, "/embed", nil))
+	if third.Code != http.StatusOK {
+		t.Errorf("request after the embed: status = %d, want 200", third.Code)
+	}
+}
+
+func TestAssembleSecret(t *testing.T) {
+	secret := []byte("one secret, three covers")
+	hash := sha256.Sum256(secret)
+	parts := make([]upload, 3)
+	for i := range parts {
+		chunk := secret[i*len(secret)/3 : (i+1)*len(secret)/3]
+		config := &models.StegoConfig{
+			Key:            "assemble",
+			LSBBits:        2,
+			Marker:         stego.DefaultMarker,
+			SecretFilename: "whole.txt",
+			Part:           &models.PartManifest{Index: i, Count: 3, SHA256: hash},
+		}
+		parts[i] = upload{"stego_files", fmt.Sprintf("part%d.mp3", i+1), embedForTest(t, config, chunk)}
+	}
+
+	tests := []struct {
+		name       string
+		files      []upload
+		wantStatus int
+	}{
+		{name: "complete", files: []upload{parts[0], parts[1], parts[2]}, wantStatus: http.StatusOK},
+		{name: "out of order", files: []upload{parts[2], parts[0], parts[1]}, wantStatus: http.StatusOK},
+		{name: "missing part", files: []upload{parts[0], parts[2]}, wantStatus: http.StatusUnprocessableEntity},
+		{name: "duplicate part", files: []upload{parts[0], parts[0], parts[1], parts[2]}, wantStatus: http.StatusUnprocessableEntity},
+		{name: "no files", files: nil, wantStatus: http.StatusBadRequest},
+	}
+
+	h := newTestHandler()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := newMultipartRequest(t, "/assemble", map[string]string{"key": "assemble", "lsb_bits": "2"}, tt.files...)
+			resp := serve(req, h.AssembleSecret)
+
+			if resp.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", resp.Code, tt.wantStatus, resp.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			if !bytes.Equal(resp.Body.Bytes(), secret) {
+				t.Errorf("body = %q, want %q", resp.Body.Bytes(), secret)
+			}
+			if parts := resp.Header().Get("X-Stego-Parts"); parts != "3" {
+				t.Errorf("X-Stego-Parts = %q, want 3", parts)
+			}
+		})
+	}
+}
+
+// spoolProbe records which files exist in a directory when the response
+// body starts, i.e. while a spooled output is being streamed
+type spoolProbe struct {
+	*httptest.ResponseRecorder
+	dir      string
+	probed   bool
+	duringIO []string
+}
+
+func (p *spoolProbe) Write(data []byte) (int, error) {
+	if !p.probed {
+		p.probed = true
+		entries, _ := os.ReadDir(p.dir)
+		for _, entry := range entries {
+			p.duringIO = append(p.duringIO, entry.Name())
+		}
+	}
+	return p.ResponseRecorder.Write(data)
+}
+
+func TestSpoolToConfiguredTmpDir(t *testing.T) {
+	output := bytes.Repeat([]byte{0xFF, 0xFB}, 4096)
+
+	tests := []struct {
+		name        string
+		threshold   int64
+		resumable   bool
+		rangeHeader string
+		missingDir  bool
+		wantStatus  int
+		wantSpooled bool
+	}{
+		{name: "below the threshold", threshold: 1 << 20, wantStatus: http.StatusOK},
+		{name: "spooled", threshold: 1024, wantStatus: http.StatusOK, wantSpooled: true},
+		{name: "spooled, resumable", threshold: 1024, resumable: true, wantStatus: http.StatusOK, wantSpooled: true},
+		{name: "range request", threshold: 1 << 20, resumable: true, rangeHeader: "bytes=100-199", wantStatus: http.StatusPartialContent, wantSpooled: true},
+		{name: "directory removed", threshold: 1024, missingDir: true, wantStatus: http.StatusOK},
+	}
+
+	inMemory := newTestHandler()
+	router := gin.New()
+	router.GET("/output", func(c *gin.Context) { inMemory.sendOutput(c, "audio/mpeg", output, true) })
+	memoryResp := httptest.NewRecorder()
+	router.ServeHTTP(memoryResp, httptest.NewRequest(http.MethodGet, "/output", nil))
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			h := newTestHandler()
+			h.spoolThreshold, h.tmpDir = tt.threshold, dir
+			if tt.missingDir {
+				h.tmpDir = filepath.Join(dir, "gone")
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/output", nil)
+			if tt.rangeHeader != "" {
+				req.Header.Set("Range", tt.rangeHeader)
+			}
+			router := gin.New()
+			router.GET("/output", func(c *gin.Context) { h.sendOutput(c, "audio/mpeg", output, tt.resumable) })
+			probe := &spoolProbe{ResponseRecorder: httptest.NewRecorder(), dir: dir}
+			router.ServeHTTP(probe, req)
+
+			if probe.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", probe.Code, tt.wantStatus)
+			}
+			want := memoryResp.Body.Bytes()
+			if tt.rangeHeader != "" {
+				want = want[100:200]
+			}
+			if !bytes.Equal(probe.Body.Bytes(), want) {
+				t.Errorf("body (%d bytes) differs from the in-memory response (%d bytes)", probe.Body.Len(), len(want))
+			}
+			if got := probe.Header().Get("Content-Type"); got != memoryResp.Header().Get("Content-Type") {
+				t.Errorf("Content-Type = %q, want %q as in memory", got, memoryResp.Header().Get("Content-Type"))
+			}
+
+			spooled := len(probe.duringIO) == 1 && strings.HasPrefix(probe.duringIO[0], "stego-output-")
+			if spooled != tt.wantSpooled {
+				t.Errorf("files in the tmpdir during the transfer: %v, want a spooled output %v", probe.duringIO, tt.wantSpooled)
+			}
+			if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+				t.Errorf("%d files left in the tmpdir", len(entries))
+			}
+		})
+	}
+}
+
+func TestTmpDirFromEnvironment(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{name: "unset", value: "", want: ""},
+		{name: "directory", value: dir, want: dir},
+		{name: "missing", value: filepath.Join(dir, "missing"), want: ""},
+		{name: "not a directory", value: file, want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("STEGO_TMPDIR", tt.value)
+			if got := NewStegoHandler().tmpDir; got != tt.want {
+				t.Errorf("tmpDir = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractMaxFilenameBytes(t *testing.T) {
+	filename := strings.Repeat("n", 300) + ".txt"
+	stegoData := embedForTest(t, &models.StegoConfig{
+		Key:              "names",
+		LSBBits:          2,
+		Marker:           stego.DefaultMarker,
+		SecretFilename:   filename,
+		MaxFilenameBytes: 400,
+	}, []byte("long name"))
+
+	tests := []struct {
+		name       string
+		limit      string
+		wantStatus int
+	}{
+		{name: "raised to fit", limit: "400", wantStatus: http.StatusOK},
+		{name: "default is too short", limit: "", wantStatus: http.StatusUnprocessableEntity},
+		{name: "zero", limit: "0", wantStatus: http.StatusBadRequest},
+		{name: "not a number", limit: "long", wantStatus: http.StatusBadRequest},
+		{name: "past the maximum", limit: strconv.Itoa(stego.MaxFilenameBytes + 1), wantStatus: http.StatusBadRequest},
+	}
+
+	h := newTestHandler()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fields := map[string]string{"key": "names", "lsb_bits": "2"}
+			if tt.limit != "" {
+				fields["max_filename_bytes"] = tt.limit
+			}
+			req := newMultipartRequest(t, "/extract", fields, upload{"stego_file", "stego.mp3", stegoData})
+			resp := serve(req, h.ExtractMessage)
+
+			if resp.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", resp.Code, tt.wantStatus, resp.Body.String())
+			}
+			if tt.wantStatus == http.StatusOK && !strings.Contains(resp.Header().Get("Content-Disposition"), filename) {
+				t.Errorf("Content-Disposition = %q, want the stored filename", resp.Header().Get("Content-Disposition"))
+			}
+		})
+	}
+}
+
+func TestInsertPSNRFloor(t *testing.T) {
+	cover := readCover(t)
+	small := []byte("barely there")
+	large := bytes.Repeat([]byte{0x5A, 0xA5, 0x3C}, 40000)
+
+	tests := []struct {
+		name         string
+		fields       map[string]string
+		secret       []byte
+		wantStatus   int
+		wantMessage  string
+		wantEstimate bool // X-Stego-Estimated-PSNR is set
+	}{
+		{
+			name:        "precheck without a floor",
+			fields:      map[string]string{"precheck": "true"},
+			secret:      small,
+			wantStatus:  http.StatusBadRequest,
+			wantMessage: "needs a min_psnr",
+		},
+		{
+			name:         "precheck aborts an aggressive frame-LSB embed",
+			fields:       map[string]string{"method": "frame_lsb", "lsb_bits": "4", "min_psnr": "60", "precheck": "true"},
+			secret:       large,
+			wantStatus:   http.StatusUnprocessableEntity,
+			wantMessage:  "nothing was embedded",
+			wantEstimate: true,
+		},
+		{
+			name:        "floor without precheck discards the result",
+			fields:      map[string]string{"method": "frame_lsb", "lsb_bits": "4", "min_psnr": "60"},
+			secret:      large,
+			wantStatus:  http.StatusUnprocessableEntity,
+			wantMessage: "the stego file was discarded",
+		},
+		{
+			name:         "ancillary passes a lower floor",
+			fields:       map[string]string{"min_psnr": "20", "precheck": "true"},
+			secret:       small,
+			wantStatus:   http.StatusOK,
+			wantEstimate: true,
+		},
+		{
+			name:        "invalid floor",
+			fields:      map[string]string{"min_psnr": "-3"},
+			secret:      small,
+			wantStatus:  http.StatusBadRequest,
+			wantMessage: "Minimum PSNR",
+		},
+	}
+
+	h := newTestHandler()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fields := map[string]string{"key": "floor", "lsb_bits": "1"}
+			for name, value := range tt.fields {
+				fields[name] = value
+			}
+			req := newMultipartRequest(t, "/insert", fields,
+				upload{"audio_file", "cover.mp3", cover},
+				upload{"secret_file", "secret.bin", tt.secret})
+			resp := serve(req, h.InsertMessage)
+
+			if resp.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", resp.Code, tt.wantStatus, resp.Body.String())
+			}
+			if tt.wantMessage != "" {
+				var result models.StegoResponse
+				if err := json.Unmarshal(resp.Body.Bytes(), &result); err != nil {
+					t.Fatal(err)
+				}
+				if !strings.Contains(result.Message, tt.wantMessage) {
+					t.Errorf("message = %q, want it to mention %q", result.Message, tt.wantMessage)
+				}
+			}
+			if estimate := resp.Header().Get("X-Stego-Estimated-PSNR"); (estimate != "") != tt.wantEstimate {
+				t.Errorf("X-Stego-Estimated-PSNR = %q, want set %v", estimate, tt.wantEstimate)
+			}
+			if tt.wantStatus == http.StatusOK && resp.Header().Get("Content-Type") != "audio/mpeg" {
+				t.Errorf("Content-Type = %q, want audio/mpeg", resp.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
+
+func TestInsertHashes(t *testing.T) {
+	cover := readCover(t)
+	req := newMultipartRequest(t, "/insert", map[string]string{"key": "hashes", "lsb_bits": "1"},
+		upload{"audio_file", "cover.mp3", cover},
+		upload{"secret_file", "secret.txt", []byte("hashed")})
+	resp := serve(req, newTestHandler().InsertMessage)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", resp.Code, resp.Body.String())
+	}
+
+	originalHash := sha256.Sum256(cover)
+	stegoHash := sha256.Sum256(resp.Body.Bytes())
+	if got, want := resp.Header().Get("X-Original-SHA256"), fmt.Sprintf("%x", originalHash); got != want {
+		t.Errorf("X-Original-SHA256 = %s, want %s", got, want)
+	}
+	if got, want := resp.Header().Get("X-Stego-SHA256"), fmt.Sprintf("%x", stegoHash); got != want {
+		t.Errorf("X-Stego-SHA256 = %s, want %s", got, want)
+	}
+}
+
+func TestImageSecretContentType(t *testing.T) {
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, image.NewGray(image.Rect(0, 0, 8, 8))); err != nil {
+		t.Fatal(err)
+	}
+	pngData := encoded.Bytes()
+
+	tests := []struct {
+		name            string
+		filename        string
+		secret          []byte
+		wantContentType string
+	}{
+		{name: "by extension", filename: "photo.png", secret: pngData, wantContentType: "image/png"},
+		{name: "by content", filename: "photo", secret: pngData, wantContentType: "image/png"},
+		{name: "unknown", filename: "blob", secret: []byte{0x00, 0x01, 0xFE, 0xFF}, wantContentType: stego.DefaultMIMEType},
+	}
+
+	h := newTestHandler()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fields := map[string]string{"key": "image", "lsb_bits": "1"}
+			req := newMultipartRequest(t, "/insert", fields,
+				upload{"audio_file", "cover.mp3", readCover(t)},
+				upload{"secret_file", tt.filename, tt.secret})
+			inserted := serve(req, h.InsertMessage)
+			if inserted.Code != http.StatusOK {
+				t.Fatalf("insert status = %d: %s", inserted.Code, inserted.Body.String())
+			}
+
+			req = newMultipartRequest(t, "/extract", fields, upload{"stego_file", "stego.mp3", inserted.Body.Bytes()})
+			resp := serve(req, h.ExtractMessage)
+			if resp.Code != http.StatusOK {
+				t.Fatalf("extract status = %d: %s", resp.Code, resp.Body.String())
+			}
+			if !bytes.Equal(resp.Body.Bytes(), tt.secret) {
+				t.Error("the extracted secret differs from the embedded one")
+			}
+			if got := resp.Header().Get("Content-Type"); got != tt.wantContentType {
+				t.Errorf("Content-Type = %q, want %q", got, tt.wantContentType)
+			}
+		})
+	}
+}
+
+func TestInsertDisposition(t *testing.T) {
+	tests := []struct {
+		name            string
+		disposition     string
+		wantStatus      int
+		wantDisposition string
+	}{
+		{name: "default", wantStatus: http.StatusOK, wantDisposition: DispositionAttachment},
+		{name: "attachment", disposition: DispositionAttachment, wantStatus: http.StatusOK, wantDisposition: DispositionAttachment},
+		{name: "inline", disposition: DispositionInline, wantStatus: http.StatusOK, wantDisposition: DispositionInline},
+		{name: "unknown", disposition: "download", wantStatus: http.StatusBadRequest},
+	}
+
+	h := newTestHandler()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fields := map[string]string{"key": "disposition", "lsb_bits": "1"}
+			if tt.disposition != "" {
+				fields["disposition"] = tt.disposition
+			}
+			req := newMultipartRequest(t, "/insert", fields,
+				upload{"audio_file", "cover.mp3", readCover(t)},
+				upload{"secret_file", "secret.txt", []byte("shown inline")})
+			resp := serve(req, h.InsertMessage)
+
+			if resp.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", resp.Code, tt.wantStatus, resp.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			disposition, params, err := mime.ParseMediaType(resp.Header().Get("Content-Disposition"))
+			if err != nil {
+				t.Fatalf("Content-Disposition %q: %v", resp.Header().Get("Content-Disposition"), err)
+			}
+			if disposition != tt.wantDisposition || params["filename"] != "cover_stego.mp3" {
+				t.Errorf("Content-Disposition = %q, want %s of cover_stego.mp3", resp.Header().Get("Content-Disposition"), tt.wantDisposition)
+			}
+		})
+	}
+}
+
+func TestExtractFallbackFilename(t *testing.T) {
+	tests := []struct {
+		name         string
+		mimeType     string
+		fallback     string
+		wantFilename string
+	}{
+		{name: "text", mimeType: "text/plain; charset=utf-8", wantFilename: "extracted.txt"},
+		{name: "binary", wantFilename: "extracted.bin"},
+		{name: "requested", fallback: "notes.md", wantFilename: "notes.md"},
+		{name: "requested path", fallback: "../notes.md", wantFilename: "extracted.bin"},
+		{name: "requested with a quote", fallback: `say "hi".txt`, wantFilename: "extracted.bin"},
+	}
+
+	h := newTestHandler()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			secret := []byte("stored without a name")
+			stegoData := embedForTest(t, &models.StegoConfig{Key: "unnamed", LSBBits: 1, Marker: stego.DefaultMarker, SecretMIMEType: tt.mimeType}, secret)
+			fields := map[string]string{"key": "unnamed", "lsb_bits": "1"}
+			if tt.fallback != "" {
+				fields["fallback_filename"] = tt.fallback
+			}
+			req := newMultipartRequest(t, "/extract", fields, upload{"stego_file", "stego.mp3", stegoData})
+			resp := serve(req, h.ExtractMessage)
+			if resp.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", resp.Code, resp.Body.String())
+			}
+
+			header := resp.Header().Get("Content-Disposition")
+			disposition, params, err := mime.ParseMediaType(header)
+			if err != nil {
+				t.Fatalf("Content-Disposition %q: %v", header, err)
+			}
+			if disposition != DispositionAttachment || params["filename"] != tt.wantFilename {
+				t.Errorf("Content-Disposition = %q, want an attachment named %s", header, tt.wantFilename)
+			}
+			if !strings.HasSuffix(header, `filename="`+tt.wantFilename+`"`) {
+				t.Errorf("Content-Disposition = %q, want the filename quoted", header)
+			}
+		})
+	}
+}
+
+func TestContentDisposition(t *testing.T) {
+	tests := []struct {
+		name        string
+		disposition string
+		filename    string
+		want        string
+	}{
+		{name: "token", disposition: DispositionAttachment, filename: "secret.txt", want: `attachment; filename="secret.txt"`},
+		{name: "inline", disposition: DispositionInline, filename: "cover_stego.mp3", want: `inline; filename="cover_stego.mp3"`},
+		{name: "spaces", disposition: DispositionAttachment, filename: "my notes.txt", want: `attachment; filename="my notes.txt"`},
+		{name: "quote and backslash", disposition: DispositionAttachment, filename: `a"b\c.txt`, want: `attachment; filename="a\"b\\c.txt"`},
+		{name: "empty", disposition: DispositionAttachment, filename: "", want: `attachment; filename=""`},
+		{name: "non-ASCII", disposition: DispositionAttachment, filename: "café.txt", want: "attachment; filename*=utf-8''caf%C3%A9.txt"},
+		{name: "control character", disposition: DispositionAttachment, filename: "a\nb", want: "attachment; filename*=utf-8''a%0Ab"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := contentDisposition(tt.disposition, tt.filename)
+			if got != tt.want {
+				t.Fatalf("contentDisposition = %q, want %q", got, tt.want)
+			}
+			disposition, params, err := mime.ParseMediaType(got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if disposition != tt.disposition || params["filename"] != tt.filename {
+				t.Errorf("parsed as %s %q, want %s %q", disposition, params["filename"], tt.disposition, tt.filename)
+			}
+		})
+	}
+}
+
+func TestInsertMultipartResponse(t *testing.T) {
+	secret := []byte("sent alongside its metadata")
+	fields := map[string]string{"key": "multipart", "lsb_bits": "1", "multipart": "true", "disposition": DispositionInline}
+	req := newMultipartRequest(t, "/insert", fields,
+		upload{"audio_file", "cover.mp3", readCover(t)},
+		upload{"secret_file", "secret.txt", secret})
+	h := newTestHandler()
+	resp := serve(req, h.InsertMessage)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", resp.Code, resp.Body.String())
+	}
+
+	mediaType, params, err := mime.ParseMediaType(resp.Header().Get("Content-Type"))
+	if err != nil || mediaType != "multipart/mixed" {
+		t.Fatalf("Content-Type = %q, want multipart/mixed", resp.Header().Get("Content-Type"))
+	}
+	reader := multipart.NewReader(resp.Body, params["boundary"])
+
+	metadataPart, err := reader.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// FormName only reads form-data parts, so the names are parsed here
+	_, metadataParams, _ := mime.ParseMediaType(metadataPart.Header.Get("Content-Disposition"))
+	if metadataParams["name"] != "metadata" || metadataPart.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("first part is %q (%s), want metadata as JSON", metadataParams["name"], metadataPart.Header.Get("Content-Type"))
+	}
+	var result models.InsertResult
+	if err := json.NewDecoder(metadataPart).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+
+	filePart, err := reader.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	disposition, fileParams, _ := mime.ParseMediaType(filePart.Header.Get("Content-Disposition"))
+	if disposition != DispositionInline || fileParams["name"] != "stego_file" || fileParams["filename"] != "cover_stego.mp3" {
+		t.Errorf("second part Content-Disposition = %q, want stego_file inline as cover_stego.mp3", filePart.Header.Get("Content-Disposition"))
+	}
+	if filePart.Header.Get("Content-Type") != "audio/mpeg" {
+		t.Errorf("second part Content-Type = %q, want audio/mpeg", filePart.Header.Get("Content-Type"))
+	}
+	var stegoData bytes.Buffer
+	if _, err := stegoData.ReadFrom(filePart); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reader.NextPart(); err == nil {
+		t.Error("expected exactly two parts")
+	}
+
+	stegoHash := sha256.Sum256(stegoData.Bytes())
+	if result.Filename != "cover_stego.mp3" || result.StegoSHA256 != fmt.Sprintf("%x", stegoHash) || result.Capacity <= 0 {
+		t.Errorf("metadata = %+v, does not describe the stego part", result)
+	}
+
+	req = newMultipartRequest(t, "/extract", map[string]string{"key": "multipart", "lsb_bits": "1"},
+		upload{"stego_file", "stego.mp3", stegoData.Bytes()})
+	if extracted := serve(req, h.ExtractMessage); !bytes.Equal(extracted.Body.Bytes(), secret) {
+		t.Errorf("extracted %q from the stego part, want %q", extracted.Body.Bytes(), secret)
+	}
+}
+
+func TestDiffAudio(t *testing.T) {
+	cover := readCover(t)
+	stegoData := embedForTest(t, &models.StegoConfig{Key: "diff", LSBBits: 2, Marker: stego.DefaultMarker}, []byte("footprint"))
+
+	tests := []struct {
+		name        string
+		stego       []byte
+		wantChanged bool
+	}{
+		{name: "stego", stego: stegoData, wantChanged: true},
+		{name: "identical", stego: cover},
+	}
+
+	h := newTestHandler()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := newMultipartRequest(t, "/audio/diff", nil,
+				upload{"original_file", "original.mp3", cover},
+				upload{"stego_file", "stego.mp3", tt.stego})
+			resp := serve(req, h.DiffAudio)
+			if resp.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", resp.Code, resp.Body.String())
+			}
+
+			applied, err := stego.ApplyDiff(cover, resp.Body.Bytes())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(applied, tt.stego) {
+				t.Error("applying the returned diff does not reproduce the stego file")
+			}
+			for _, name := range []string{"X-Diff-Runs", "X-Diff-Changed-Bytes", "X-Diff-Modified-Frames"} {
+				if value := resp.Header().Get(name); (value != "0") != tt.wantChanged {
+					t.Errorf("%s = %q, want changes %v", name, value, tt.wantChanged)
+				}
+			}
+		})
+	}
+}
+
+func TestCompareDifferentFrameCounts(t *testing.T) {
+	cover := readCover(t)
+	mp3File, err := mp3parser.ParseMP3File(cover)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mp3File.Frames = mp3File.Frames[:len(mp3File.Frames)-100]
+	shorter, err := mp3parser.WriteMP3File(mp3File)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name           string
+		original       []byte
+		stego          []byte
+		policy         string
+		wantStatus     int
+		wantDifference int
+	}{
+		{name: "same frames", original: cover, stego: cover, wantStatus: http.StatusOK},
+		{name: "stego shorter", original: cover, stego: shorter, wantStatus: http.StatusOK, wantDifference: -100},
+		{name: "original shorter", original: shorter, stego: cover, wantStatus: http.StatusOK, wantDifference: 100},
+		{name: "rejected", original: cover, stego: shorter, policy: models.FrameMismatchReject, wantStatus: http.StatusBadRequest, wantDifference: -100},
+		{name: "same frames with reject", original: cover, stego: cover, policy: models.FrameMismatchReject, wantStatus: http.StatusOK},
+	}
+
+	h := newTestHandler()
+	var fullSamples int
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fields := map[string]string{}
+			if tt.policy != "" {
+				fields["frame_mismatch"] = tt.policy
+			}
+			req := newMultipartRequest(t, "/audio/compare", fields,
+				upload{"original_file", "original.mp3", tt.original},
+				upload{"stego_file", "stego.mp3", tt.stego})
+			resp := serve(req, h.CompareAudio)
+			if resp.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", resp.Code, tt.wantStatus, resp.Body.String())
+			}
+
+			var result models.CompareResponse
+			if err := json.Unmarshal(resp.Body.Bytes(), &result); err != nil {
+				t.Fatal(err)
+			}
+			if result.FrameDifference != tt.wantDifference || result.StegoFrames-result.OriginalFrames != tt.wantDifference {
+				t.Errorf("%d original and %d stego frames, difference %d, want %d",
+					result.OriginalFrames, result.StegoFrames, result.FrameDifference, tt.wantDifference)
+			}
+			if tt.wantStatus != http.StatusOK {
+				if result.Success || !strings.Contains(result.Message, "Frame count mismatch") {
+					t.Errorf("result = %+v, want a frame count mismatch", result)
+				}
+				return
+			}
+
+			// The common region holds the same audio, however much was cut
+			if !result.Success || !result.Identical || result.QualityGrade != "A" {
+				t.Errorf("result = %+v, want identical audio", result)
+			}
+			if tt.wantDifference == 0 {
+				fullSamples = result.ComparedSamples
+			} else if result.ComparedSamples >= fullSamples || !strings.Contains(result.Message, "frame counts differ") {
+				t.Errorf("compared %d samples (%q), want fewer than the %d of the whole file", result.ComparedSamples, result.Message, fullSamples)
+			}
+		})
+	}
+}
+
+func TestFileIDRoundTrip(t *testing.T) {
+	uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	tests := []struct {
+		name       string
+		fileID     string
+		wantStatus int
+	}{
+		{name: "generated", fileID: "auto", wantStatus: http.StatusOK},
+		{name: "supplied", fileID: "copy-42", wantStatus: http.StatusOK},
+		{name: "none", wantStatus: http.StatusOK},
+		{name: "invalid", fileID: "copy 42", wantStatus: http.StatusBadRequest},
+	}
+
+	h := newTestHandler()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fields := map[string]string{"key": "tracked", "lsb_bits": "1"}
+			if tt.fileID != "" {
+				fields["file_id"] = tt.fileID
+			}
+			req := newMultipartRequest(t, "/insert", fields,
+				upload{"audio_file", "cover.mp3", readCover(t)},
+				upload{"secret_file", "secret.txt", []byte("tracked copy")})
+			inserted := serve(req, h.InsertMessage)
+			if inserted.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", inserted.Code, tt.wantStatus, inserted.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			fileID := inserted.Header().Get("X-Stego-File-ID")
+			if tt.fileID == "auto" && !uuid.MatchString(fileID) {
+				t.Errorf("X-Stego-File-ID = %q, want a version 4 UUID", fileID)
+			} else if tt.fileID != "auto" && fileID != tt.fileID {
+				t.Errorf("X-Stego-File-ID = %q, want %q", fileID, tt.fileID)
+			}
+
+			req = newMultipartRequest(t, "/extract", fields, upload{"stego_file", "stego.mp3", inserted.Body.Bytes()})
+			extracted := serve(req, h.ExtractMessage)
+			if extracted.Code != http.StatusOK {
+				t.Fatalf("extract status = %d: %s", extracted.Code, extracted.Body.String())
+			}
+			if got := extracted.Header().Get("X-Stego-File-ID"); got != fileID {
+				t.Errorf("extracted X-Stego-File-ID = %q, want %q", got, fileID)
+			}
+
+			req = newMultipartRequest(t, "/verify", fields, upload{"stego_file", "stego.mp3", inserted.Body.Bytes()})
+			verified := serve(req, h.VerifyMessage)
+			var result models.VerifyResponse
+			if err := json.Unmarshal(verified.Body.Bytes(), &result); err != nil {
+				t.Fatal(err)
+			}
+			if verified.Code != http.StatusOK || result.FileID != fileID {
+				t.Errorf("verify status %d with file_id %q, want %q", verified.Code, result.FileID, fileID)
+			}
+		})
+	}
+}
+
+// silentMP3 returns n silent MPEG-1 Layer III frames at 44.1 kHz and 32 or
+// 128 kbps. Their main data is empty, so the rest of each frame is padding.
+func silentMP3(kbps, n int) []byte {
+	bitrateIndex := map[int]uint32{32: 1, 128: 9}[kbps]
+	frame := make([]byte, 144*kbps*1000/44100)
+	binary.BigEndian.PutUint32(frame, 0xFFFB0000|bitrateIndex<<12)
+	return bytes.Repeat(frame, n)
+}
+
+func TestLowCapacityGuidance(t *testing.T) {
+	tests := []struct {
+		name        string
+		cover       []byte
+		method      string
+		wantMessage string
+		wantAdvice  string // "" when no guidance is expected
+	}{
+		{name: "32 kbps cover", cover: silentMP3(32, 20), wantMessage: "Secret data too large", wantAdvice: "only 32 kbps"},
+		{name: "128 kbps cover", cover: silentMP3(128, 20), wantMessage: "Secret data too large", wantAdvice: "Use a longer cover"},
+		{name: "frame LSB", cover: silentMP3(32, 20), method: models.MethodFrameLSB, wantMessage: "Secret data too large"},
+	}
+
+	h := newTestHandler()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fields := map[string]string{"key": "guidance", "lsb_bits": "1"}
+			if tt.method != "" {
+				fields["method"] = tt.method
+			}
+			req := newMultipartRequest(t, "/insert", fields,
+				upload{"audio_file", "cover.mp3", tt.cover},
+				upload{"secret_file", "secret.bin", bytes.Repeat([]byte{0x42}, 4096)})
+			resp := serve(req, h.InsertMessage)
+			if resp.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d: %s", resp.Code, resp.Body.String())
+			}
+
+			var result models.StegoResponse
+			if err := json.Unmarshal(resp.Body.Bytes(), &result); err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(result.Message, tt.wantMessage) {
+				t.Errorf("message = %q, want it to mention %q", result.Message, tt.wantMessage)
+			}
+			if tt.wantAdvice != "" && !strings.Contains(result.Message, tt.wantAdvice) {
+				t.Errorf("message = %q, want it to mention %q", result.Message, tt.wantAdvice)
+			}
+			if tt.wantAdvice == "" && strings.Contains(result.Message, "kbps") {
+				t.Errorf("message = %q, want no cover guidance", result.Message)
+			}
+		})
+	}
+}
+
+func TestExtractTruncatedPayload(t *testing.T) {
+	cover := silentMP3(128, 200)
+	secret := bytes.Repeat([]byte("cut short "), 400)
+	method, err := stego.NewMP3Steganography(&models.StegoConfig{Key: "cut", LSBBits: 1, Marker: stego.DefaultMarker, SecretFilename: "report.pdf"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stegoData, err := method.EmbedInMP3(cover, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The secret fills about 85 frames; keep the first 60
+	req := newMultipartRequest(t, "/extract", map[string]string{"key": "cut", "lsb_bits": "1"},
+		upload{"stego_file", "stego.mp3", stegoData[:len(stegoData)*60/200]})
+	resp := serve(req, newTestHandler().ExtractMessage)
+	if resp.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("status = %d, want %d: %s", resp.Code, http.StatusUnprocessableEntity, resp.Body.String())
+	}
+
+	var result models.ExtractResponse
+	if err := json.Unmarshal(resp.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.SecretFilename != "report.pdf" || result.ExpectedSize != len(secret) {
+		t.Errorf("response names %q of %d bytes, want report.pdf of %d", result.SecretFilename, result.ExpectedSize, len(secret))
+	}
+	if resp.Header().Get("X-Stego-Filename") != "report.pdf" || resp.Header().Get("X-Stego-Expected-Size") != strconv.Itoa(len(secret)) {
+		t.Errorf("headers name %q of %s bytes, want report.pdf of %d",
+			resp.Header().Get("X-Stego-Filename"), resp.Header().Get("X-Stego-Expected-Size"), len(secret))
+	}
+}
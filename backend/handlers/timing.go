@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// stageTimer accumulates the wall time spent in each stage of a request so it
+// can be reported back as X-Timing-<Stage> headers (in milliseconds)
+type stageTimer struct {
+	start  time.Time
+	last   time.Time
+	order  []string
+	totals map[string]time.Duration
+}
+
+func newStageTimer() *stageTimer {
+	now := time.Now()
+	return &stageTimer{
+		start:  now,
+		last:   now,
+		totals: make(map[string]time.Duration),
+	}
+}
+
+// mark attributes the time since the previous mark to the given stage
+func (t *stageTimer) mark(stage string) {
+	now := time.Now()
+	if _, ok := t.totals[stage]; !ok {
+		t.order = append(t.order, stage)
+	}
+	t.totals[stage] += now.Sub(t.last)
+	t.last = now
+}
+
+// writeHeaders sets one header per recorded stage plus the request total
+func (t *stageTimer) writeHeaders(c *gin.Context) {
+	for _, stage := range t.order {
+		c.Header(timingHeader(stage), formatMilliseconds(t.totals[stage]))
+	}
+	c.Header("X-Timing-Total", formatMilliseconds(time.Since(t.start)))
+}
+
+func timingHeader(stage string) string {
+	return "X-Timing-" + strings.ToUpper(stage[:1]) + stage[1:]
+}
+
+func formatMilliseconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", float64(d.Nanoseconds())/float64(time.Millisecond))
+}
@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"steganography-backend/models"
+	"steganography-backend/stego"
+)
+
+func TestTimingHeaders(t *testing.T) {
+	secret := []byte("timed secret")
+	stegoData := embedForTest(t, &models.StegoConfig{Key: "timing", LSBBits: 1, Marker: stego.DefaultMarker}, secret)
+
+	h := newTestHandler()
+	tests := []struct {
+		name       string
+		req        *http.Request
+		handler    gin.HandlerFunc
+		wantStages []string
+	}{
+		{
+			name: "insert",
+			req: newMultipartRequest(t, "/insert", map[string]string{"key": "timing", "lsb_bits": "1"},
+				upload{"audio_file", "cover.mp3", readCover(t)},
+				upload{"secret_file", "secret.txt", secret}),
+			handler:    h.InsertMessage,
+			wantStages: []string{"Parse", "Analyze", "Embed", "Encode", "Psnr", "Total"},
+		},
+		{
+			name: "extract",
+			req: newMultipartRequest(t, "/extract", map[string]string{"key": "timing", "lsb_bits": "1"},
+				upload{"stego_file", "stego.mp3", stegoData}),
+			handler:    h.ExtractMessage,
+			wantStages: []string{"Parse", "Extract", "Total"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := serve(tt.req, tt.handler)
+			if resp.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", resp.Code, resp.Body.String())
+			}
+
+			var stages []string
+			for name, values := range resp.Header() {
+				stage, ok := strings.CutPrefix(name, "X-Timing-")
+				if !ok {
+					continue
+				}
+				stages = append(stages, stage)
+				if ms, err := strconv.ParseFloat(values[0], 64); err != nil || ms <= 0 {
+					t.Errorf("%s = %q, want a positive number of milliseconds", name, values[0])
+				}
+			}
+			slices.Sort(stages)
+			want := slices.Sorted(slices.Values(tt.wantStages))
+			if !slices.Equal(stages, want) {
+				t.Errorf("timed stages %v, want %v", stages, want)
+			}
+		})
+	}
+}
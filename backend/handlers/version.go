@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"os/exec"
+	"runtime"
+	"sync"
+
+	"steganography-backend/models"
+	"steganography-backend/mp3parser"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Build information, overridden at build time with
+// -ldflags "-X steganography-backend/handlers.Version=... -X steganography-backend/handlers.GitCommit=..."
+var (
+	Version   = "1.0.0"
+	GitCommit = "unknown"
+)
+
+var (
+	lameOnce    sync.Once
+	lameVersion string
+)
+
+// LAMEVersion returns the first line of `lame --version`, or an empty string
+// when LAME is not available. The result is computed once per process.
+func LAMEVersion() string {
+	lameOnce.Do(func() {
+		output, err := exec.Command("lame", "--version").Output()
+		if err != nil {
+			return
+		}
+		line, _, _ := bytes.Cut(output, []byte("\n"))
+		lameVersion = string(bytes.TrimSpace(line))
+	})
+	return lameVersion
+}
+
+// BuildInfo describes this build and the features it supports
+func BuildInfo() models.VersionResponse {
+	lame := LAMEVersion()
+	return models.VersionResponse{
+		Version:    Version,
+		GitCommit:  GitCommit,
+		GoVersion:  runtime.Version(),
+		Ciphers:    []string{"vigenere"},
+		Formats:    []string{"mp3"},
+		Methods:    []string{models.MethodAncillary, models.MethodFrameLSB, models.MethodAuto},
+		SeedHashes: []string{models.SeedHashSHA256, models.SeedHashMD5},
+		MPEG: []models.MPEGSupport{{
+			Version:     mp3parser.VersionName(mp3parser.MPEGVersion1),
+			Layer:       mp3parser.LayerName(mp3parser.LayerIII),
+			Bitrates:    mp3parser.SupportedBitrates(),
+			SampleRates: mp3parser.SupportedSampleRates(),
+		}},
+		LAMEAvailable: lame != "",
+		LAMEVersion:   lame,
+	}
+}
+
+// GetVersion reports the build and capability info so clients can
+// feature-detect
+func (h *StegoHandler) GetVersion(c *gin.Context) {
+	c.JSON(http.StatusOK, BuildInfo())
+}
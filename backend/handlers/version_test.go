@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"slices"
+	"testing"
+
+	"steganography-backend/models"
+)
+
+func TestGetVersion(t *testing.T) {
+	// Stand in for the values set with -ldflags at build time
+	version, gitCommit := Version, GitCommit
+	Version, GitCommit = "2.3.4", "abc1234"
+	t.Cleanup(func() { Version, GitCommit = version, gitCommit })
+
+	resp := serve(httptest.NewRequest(http.MethodGet, "/version", nil), newTestHandler().GetVersion)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", resp.Code, resp.Body.String())
+	}
+	var info models.VersionResponse
+	if err := json.Unmarshal(resp.Body.Bytes(), &info); err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Version != "2.3.4" || info.GitCommit != "abc1234" || info.GoVersion != runtime.Version() {
+		t.Errorf("build = %s at %s with %s, want 2.3.4 at abc1234 with %s", info.Version, info.GitCommit, info.GoVersion, runtime.Version())
+	}
+	if !slices.Equal(info.Ciphers, []string{"vigenere"}) || !slices.Equal(info.Formats, []string{"mp3"}) {
+		t.Errorf("ciphers %v and formats %v, want [vigenere] and [mp3]", info.Ciphers, info.Formats)
+	}
+	for _, method := range []string{models.MethodAncillary, models.MethodFrameLSB, models.MethodAuto} {
+		if !slices.Contains(info.Methods, method) {
+			t.Errorf("methods %v do not include %s", info.Methods, method)
+		}
+	}
+	if !slices.Equal(info.SeedHashes, []string{models.SeedHashSHA256, models.SeedHashMD5}) {
+		t.Errorf("seed hashes %v, want [%s %s]", info.SeedHashes, models.SeedHashSHA256, models.SeedHashMD5)
+	}
+	if info.LAMEAvailable != (LAMEVersion() != "") || info.LAMEVersion != LAMEVersion() {
+		t.Errorf("LAME available %v at %q, want %q", info.LAMEAvailable, info.LAMEVersion, LAMEVersion())
+	}
+}
@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+)
+
+// warningList collects non-fatal caveats raised while handling a request.
+// They are reported next to a successful result, never instead of an error.
+type warningList []string
+
+// add records a warning and mirrors it to the server log
+func (w *warningList) add(format string, args ...any) {
+	warning := fmt.Sprintf(format, args...)
+	fmt.Printf("Warning: %s\n", warning)
+	*w = append(*w, warning)
+}
+
+// writeHeader sets X-Stego-Warnings to the warnings as a JSON array
+func (w warningList) writeHeader(c *gin.Context) {
+	if len(w) == 0 {
+		return
+	}
+	encoded, err := json.Marshal([]string(w))
+	if err != nil {
+		return
+	}
+	c.Header("X-Stego-Warnings", string(encoded))
+}
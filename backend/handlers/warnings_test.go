@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"steganography-backend/models"
+	"steganography-backend/stego"
+)
+
+func TestWarningsHeader(t *testing.T) {
+	secret := []byte("still delivered")
+	cover := silentMP3(128, 200)
+	junk := append(append(bytes.Clone(cover[:len(cover)/2]), "resync"...), cover[len(cover)/2:]...)
+
+	method, err := stego.NewMP3Steganography(&models.StegoConfig{Key: "warned", LSBBits: 1, Marker: stego.DefaultMarker})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stegoData, err := method.EmbedInMP3(cover, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Extra frames change the carrier size recorded at embedding
+	modified := append(bytes.Clone(stegoData), silentMP3(128, 20)...)
+
+	tests := []struct {
+		name         string
+		cover        []byte // inserted into when set
+		stego        []byte // extracted from otherwise
+		wantWarnings []string
+	}{
+		{name: "insert without an Info frame", cover: cover, wantWarnings: []string{"No Xing/Info frame"}},
+		{name: "insert with junk between frames", cover: junk, wantWarnings: []string{"6 bytes in 1 regions were skipped", "No Xing/Info frame"}},
+		{name: "extract from a modified file", stego: modified, wantWarnings: []string{"modified after embedding"}},
+		{name: "extract from an unmodified file", stego: stegoData},
+	}
+
+	h := newTestHandler()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fields := map[string]string{"key": "warned", "lsb_bits": "1"}
+			handler := h.ExtractMessage
+			req := newMultipartRequest(t, "/extract", fields, upload{"stego_file", "stego.mp3", tt.stego})
+			if tt.cover != nil {
+				handler = h.InsertMessage
+				req = newMultipartRequest(t, "/insert", fields,
+					upload{"audio_file", "cover.mp3", tt.cover},
+					upload{"secret_file", "secret.txt", secret})
+			}
+			resp := serve(req, handler)
+
+			// Warnings never turn a result into an error
+			if resp.Code != http.StatusOK || resp.Body.Len() == 0 {
+				t.Fatalf("status = %d: %s", resp.Code, resp.Body.String())
+			}
+			header := resp.Header().Get("X-Stego-Warnings")
+			if tt.wantWarnings == nil {
+				if header != "" {
+					t.Errorf("X-Stego-Warnings = %s, want none", header)
+				}
+				return
+			}
+			var warnings []string
+			if err := json.Unmarshal([]byte(header), &warnings); err != nil {
+				t.Fatalf("X-Stego-Warnings = %q: %v", header, err)
+			}
+			if len(warnings) != len(tt.wantWarnings) {
+				t.Fatalf("warnings = %q, want %d", warnings, len(tt.wantWarnings))
+			}
+			for i, want := range tt.wantWarnings {
+				if !strings.Contains(warnings[i], want) {
+					t.Errorf("warning %d = %q, want it to mention %q", i, warnings[i], want)
+				}
+			}
+		})
+	}
+}
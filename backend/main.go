@@ -5,6 +5,7 @@ import (
 	"os"
 	"os/exec"
 	"steganography-backend/handlers"
+	"strings"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -17,27 +18,50 @@ func main() {
 	}
 	log.Printf("✓ LAME encoder found and ready for MP3 encoding")
 
+	buildInfo := handlers.BuildInfo()
+	log.Printf("Version %s (commit %s, %s, %s)", buildInfo.Version, buildInfo.GitCommit, buildInfo.GoVersion, buildInfo.LAMEVersion)
+	log.Printf("Methods: %s; ciphers: %s; formats: %s",
+		strings.Join(buildInfo.Methods, ", "), strings.Join(buildInfo.Ciphers, ", "), strings.Join(buildInfo.Formats, ", "))
+
 	router := gin.Default()
 
 	config := cors.DefaultConfig()
 	config.AllowOrigins = []string{"http://localhost:3000"}
 	config.AllowMethods = []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}
-	config.AllowHeaders = []string{"Origin", "Content-Type", "Accept", "Authorization", "X-Requested-With"}
-	config.ExposeHeaders = []string{"X-Stego-PSNR", "X-Stego-Message", "Content-Disposition"}
+	config.AllowHeaders = []string{"Origin", "Content-Type", "Accept", "Authorization", "X-Requested-With", "Range"}
+	config.ExposeHeaders = []string{
+		"X-Stego-PSNR", "X-Stego-Estimated-PSNR", "X-Stego-Quality-Grade", "X-Stego-Method", "X-Stego-Placement", "X-Stego-Message", "X-Stego-Metadata", "X-Stego-Filename", "X-Stego-Expected-Size", "X-Original-SHA256", "X-Stego-SHA256", "X-Stego-Warnings", "X-Stego-File-ID", "X-Stego-Expires-At", "X-Stego-Part", "X-Stego-Unanalyzable-Frames", "X-Stego-Parts", "X-Diff-Runs", "X-Diff-Changed-Bytes", "X-Diff-Modified-Frames", "Content-Disposition", "Retry-After", "Content-Range", "Accept-Ranges",
+		"X-Timing-Parse", "X-Timing-Analyze", "X-Timing-Embed", "X-Timing-Encode", "X-Timing-Extract", "X-Timing-Psnr", "X-Timing-Precheck", "X-Timing-Total",
+	}
 	config.AllowCredentials = true
 	router.Use(cors.New(config))
 
 	stegoHandler := handlers.NewStegoHandler()
+	router.Use(stegoHandler.Compress())
 
 	// API Routes
 	api := router.Group("/api/v1")
 	{
 		api.GET("/health", stegoHandler.HealthCheck)
+		api.GET("/version", stegoHandler.GetVersion)
 
-		stego := api.Group("/stego")
+		stego := api.Group("/stego", stegoHandler.LimitConcurrency())
 		{
 			stego.POST("/insert", stegoHandler.InsertMessage)
 			stego.POST("/extract", stegoHandler.ExtractMessage)
+			stego.POST("/verify", stegoHandler.VerifyMessage)
+			stego.POST("/check-key", stegoHandler.CheckKey)
+			stego.POST("/update-header", stegoHandler.UpdateHeader)
+			stego.POST("/assemble", stegoHandler.AssembleSecret)
+			stego.POST("/analyze", stegoHandler.AnalyzeCapacity)
+		}
+
+		// The audio tools decode whole files, so they share the same slots
+		audio := api.Group("/audio", stegoHandler.LimitConcurrency())
+		{
+			audio.POST("/waveform", stegoHandler.GenerateWaveform)
+			audio.POST("/compare", stegoHandler.CompareAudio)
+			audio.POST("/diff", stegoHandler.DiffAudio)
 		}
 	}
 
@@ -52,14 +76,23 @@ func main() {
 	log.Printf("API endpoints:")
 	log.Printf("  POST /api/v1/stego/insert  - Insert secret message into MP3 (returns stego MP3)")
 	log.Printf("  POST /api/v1/stego/extract - Extract secret message from MP3 (returns secret file)")
+	log.Printf("  POST /api/v1/stego/verify  - Report the embedded metadata and secret fingerprint without the secret")
+	log.Printf("  POST /api/v1/stego/check-key - Check a key against a stego MP3 without extracting")
+	log.Printf("  POST /api/v1/stego/update-header - Change the stored filename/metadata without re-embedding")
+	log.Printf("  POST /api/v1/stego/assemble - Reassemble a secret split across several stego MP3s")
+	log.Printf("  POST /api/v1/stego/analyze - Compare safe and raw embedding capacity of an MP3")
+	log.Printf("  POST /api/v1/audio/waveform - Render a PNG waveform thumbnail of an MP3")
+	log.Printf("  POST /api/v1/audio/compare - Compare an original and a stego MP3 (PSNR over the common region)")
+	log.Printf("  POST /api/v1/audio/diff    - Binary diff of the bytes changed between an original and a stego MP3")
 	log.Printf("  GET  /api/v1/health        - Health check")
+	log.Printf("  GET  /api/v1/version       - Build and capability info")
 	log.Printf("")
 	log.Printf("Features:")
 	log.Printf("  • MP3 input/output with metadata preservation")
 	log.Printf("  • LSB steganography on PCM samples")
 	log.Printf("  • Vigenère cipher encryption")
 	log.Printf("  • PSNR quality assessment (returned in X-Stego-PSNR header)")
-	log.Printf("  • Direct streaming (no disk storage)")
+	log.Printf("  • Streamed responses; large outputs are spooled to a temp file (STEGO_TMPDIR)")
 	log.Printf("")
 	log.Printf("Requirements: LAME encoder must be installed for MP3 encoding")
 
@@ -1,6 +1,13 @@
 // Package models contain needed models
 package models
 
+import (
+	"encoding/json"
+	"time"
+
+	"steganography-backend/mp3parser"
+)
+
 // StegoRequest represents the request for inserting a secret message
 type StegoRequest struct {
 	Key            string `json:"key" binding:"required"`
@@ -8,6 +15,7 @@ type StegoRequest struct {
 	UseRandomStart bool   `json:"use_random_start"`
 	LSBBits        int    `json:"lsb_bits" binding:"required,min=1,max=4"`
 	SecretFilename string `json:"secret_filename"`
+	Salt           string `json:"salt"`
 }
 
 // StegoResponse represents the response after insertion
@@ -18,6 +26,24 @@ type StegoResponse struct {
 	StegoFileURL string  `json:"stego_file_url,omitempty"`
 }
 
+// InsertResult describes a completed insert. It is returned as the
+// "metadata" part of a multipart insert response.
+type InsertResult struct {
+	Filename       string   `json:"filename"`
+	Method         string   `json:"method"`
+	Message        string   `json:"message"`
+	FileID         string   `json:"file_id,omitempty"`
+	Capacity       int      `json:"capacity"`
+	Frames         int      `json:"frames"`
+	Unanalyzable   int      `json:"unanalyzable_frames,omitempty"`
+	PSNR           *float64 `json:"psnr,omitempty"`            // nil when unavailable or infinite
+	AudioIdentical bool     `json:"audio_identical,omitempty"` // Decoded audio is unchanged, PSNR is infinite
+	QualityGrade   string   `json:"quality_grade,omitempty"`   // A-F grade derived from the PSNR
+	OriginalSHA256 string   `json:"original_sha256"`
+	StegoSHA256    string   `json:"stego_sha256"`
+	Warnings       []string `json:"warnings,omitempty"` // Non-fatal caveats, e.g. PSNR unavailable
+}
+
 // ExtractRequest represents the request for extracting a secret message
 type ExtractRequest struct {
 	Key            string `json:"key" binding:"required"`
@@ -32,6 +58,164 @@ type ExtractResponse struct {
 	Message        string `json:"message"`
 	SecretFileURL  string `json:"secret_file_url,omitempty"`
 	SecretFilename string `json:"secret_filename,omitempty"`
+	ExpectedSize   int    `json:"expected_size,omitempty"` // Secret size from the header when the data is truncated
+}
+
+// KeyCheckResponse reports whether a key matches the data embedded in a file
+type KeyCheckResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+	Valid   bool   `json:"valid"`
+}
+
+// VerifyResponse describes an embedded payload without delivering the secret
+type VerifyResponse struct {
+	Success        bool            `json:"success"`
+	Message        string          `json:"message"`
+	SecretFilename string          `json:"secret_filename,omitempty"`
+	SecretSize     int             `json:"secret_size,omitempty"`
+	SecretMIMEType string          `json:"secret_mime_type,omitempty"`
+	SecretSHA256   string          `json:"secret_sha256,omitempty"` // Fingerprint of the secret for comparison
+	Method         string          `json:"method,omitempty"`        // Detected method when extracting with method=auto
+	LSBBits        int             `json:"lsb_bits,omitempty"`
+	Placement      string          `json:"placement,omitempty"` // Placement found by method=auto
+	SeedHash       string          `json:"seed_hash,omitempty"`
+	Salt           string          `json:"salt,omitempty"`
+	FileID         string          `json:"file_id,omitempty"`
+	ExpiresAt      string          `json:"expires_at,omitempty"` // RFC 3339
+	Retagged       bool            `json:"retagged,omitempty"`   // ID3v2 tag changed since embedding
+	Metadata       json.RawMessage `json:"metadata,omitempty"`
+	Warnings       []string        `json:"warnings,omitempty"`
+
+	// ParameterFingerprint is the hex SHA-256 of the method, LSB depth and
+	// seed hash the payload was embedded with
+	ParameterFingerprint string `json:"parameter_fingerprint,omitempty"`
+}
+
+// Frame count mismatch policies for the compare endpoint
+const (
+	FrameMismatchAlign  = "align"  // Compare the common region of both files (default)
+	FrameMismatchReject = "reject" // Refuse to compare files with different frame counts
+)
+
+// CompareResponse represents the result of comparing an original and a stego MP3
+type CompareResponse struct {
+	Success         bool    `json:"success"`
+	Message         string  `json:"message"`
+	PSNR            float64 `json:"psnr,omitempty"`
+	Identical       bool    `json:"identical,omitempty"` // Decoded audio is bit-identical, PSNR is infinite
+	QualityGrade    string  `json:"quality_grade,omitempty"`
+	OriginalFrames  int     `json:"original_frames"`
+	StegoFrames     int     `json:"stego_frames"`
+	FrameDifference int     `json:"frame_difference"` // StegoFrames - OriginalFrames
+	ComparedSamples int     `json:"compared_samples,omitempty"`
+}
+
+// CapacityDiagnostics compares the safe and the raw capacity of an MP3. Bits
+// and bytes are totals before the preamble and payload header are subtracted.
+type CapacityDiagnostics struct {
+	LSBBits        int     `json:"lsb_bits"`
+	Frames         int     `json:"frames"`
+	SkippedBytes   int     `json:"skipped_bytes"`      // Bytes skipped while resyncing to frame headers
+	SkippedRegions int     `json:"skipped_regions"`    // Contiguous regions of skipped bytes
+	SafeCarriers   int     `json:"safe_carrier_bytes"` // Ancillary/padding bytes used by the safe method
+	RawCarriers    int     `json:"raw_carrier_bytes"`  // All audio frame data bytes
+	SafeBits       int     `json:"safe_bits"`
+	RawBits        int     `json:"raw_bits"`
+	SafeBytes      int     `json:"safe_bytes"`
+	RawBytes       int     `json:"raw_bytes"`
+	SafeRatio      float64 `json:"safe_ratio"` // SafeBits / RawBits
+
+	Capacity     int               `json:"capacity"`            // Secret bytes that fit in the safe carriers after the overhead
+	Unanalyzable int               `json:"unanalyzable_frames"` // Frames that failed analysis and add nothing to the capacity
+	Overhead     *CapacityOverhead `json:"overhead,omitempty"`
+}
+
+// CapacityOverhead itemizes the bytes embedded around the secret. The
+// preamble (marker through salt) is written to the first carriers; the
+// payload header (filename length through data length) precedes the secret.
+type CapacityOverhead struct {
+	Marker           int `json:"marker"`
+	SeedHash         int `json:"seed_hash"`
+	Domain           int `json:"domain"`
+	KeyCheck         int `json:"key_check"`
+	SaltLength       int `json:"salt_length"`
+	Salt             int `json:"salt"`
+	FilenameLength   int `json:"filename_length"`
+	Filename         int `json:"filename"`
+	ExtensionsLength int `json:"extensions_length"`
+	Extensions       int `json:"extensions"` // Metadata, MIME type, file ID, expiry, part and ID3 checksum
+	DataLength       int `json:"data_length"`
+
+	PreambleBytes    int `json:"preamble_bytes"`
+	PreambleCarriers int `json:"preamble_carriers"` // Carrier bytes taken by the preamble at this LSB depth
+	HeaderBytes      int `json:"header_bytes"`
+	HeaderCarriers   int `json:"header_carriers"` // Carrier bytes spanned by the payload header at this LSB depth and density
+	Total            int `json:"total"`           // PreambleBytes + HeaderBytes
+}
+
+// FrameAnomaly is a header field that changed from one audio frame to the next
+type FrameAnomaly struct {
+	Frame int    `json:"frame"` // Index of the frame where the value changed
+	Field string `json:"field"` // sample_rate or channels
+	From  int    `json:"from"`
+	To    int    `json:"to"`
+}
+
+// StreamConsistency reports whether all audio frames share compatible headers
+type StreamConsistency struct {
+	Consistent   bool           `json:"consistent"`
+	VBR          bool           `json:"vbr"` // Bitrate varies between frames, which is legitimate
+	AnomalyCount int            `json:"anomaly_count"`
+	Anomalies    []FrameAnomaly `json:"anomalies,omitempty"` // The first anomalies, in stream order
+}
+
+// MethodCapacity is the secret size one embedding method can hold in a file
+type MethodCapacity struct {
+	Method   string `json:"method"`
+	Capacity int    `json:"capacity"`
+	Error    string `json:"error,omitempty"` // Why the method cannot embed into this file
+}
+
+// AnalyzeResponse represents the response of the diagnostics endpoint
+type AnalyzeResponse struct {
+	Success     bool                 `json:"success"`
+	Message     string               `json:"message"`
+	Capacity    *CapacityDiagnostics `json:"capacity,omitempty"`
+	Methods     []MethodCapacity     `json:"methods,omitempty"`
+	Consistency *StreamConsistency   `json:"consistency,omitempty"`
+
+	Duration       float64 `json:"duration_seconds,omitempty"`
+	DurationSource string  `json:"duration_source,omitempty"` // DurationEstimated or DurationDecoded
+}
+
+// How the analyze duration was obtained
+const (
+	DurationEstimated = "estimated" // Frame count times samples per frame, no decoding
+	DurationDecoded   = "decoded"   // Length of the decoded PCM
+)
+
+// VersionResponse describes the server build and the features it supports
+type VersionResponse struct {
+	Version       string        `json:"version"`
+	GitCommit     string        `json:"git_commit"`
+	GoVersion     string        `json:"go_version"`
+	Ciphers       []string      `json:"ciphers"`
+	Formats       []string      `json:"formats"`
+	Methods       []string      `json:"methods"`
+	SeedHashes    []string      `json:"seed_hashes"`
+	MPEG          []MPEGSupport `json:"mpeg"` // Frame formats the parser understands
+	LAMEAvailable bool          `json:"lame_available"`
+	LAMEVersion   string        `json:"lame_version,omitempty"`
+}
+
+// MPEGSupport lists the bitrates and sample rates supported for one MPEG
+// version and layer; every combination of the two parses
+type MPEGSupport struct {
+	Version     string `json:"version"`
+	Layer       string `json:"layer"`
+	Bitrates    []int  `json:"bitrates"`     // bps
+	SampleRates []int  `json:"sample_rates"` // Hz
 }
 
 // AudioMetadata represents metadata about an audio file
@@ -43,11 +227,84 @@ type AudioMetadata struct {
 	TotalBytes int
 }
 
+// Embedding methods
+const (
+	MethodAncillary = "ancillary" // LSBs of frame ancillary/padding bytes (default)
+	MethodFrameLSB  = "frame_lsb" // LSBs of the frame payload bytes
+	MethodAuto      = "auto"      // Extraction only: try every method in turn
+)
+
+// Payload placements reported by automatic detection
+const (
+	PlacementSequential = "sequential" // Consecutive carriers from the start
+	PlacementStrided    = "strided"    // Every Nth carrier from the start (density > 1)
+	PlacementRandom     = "random"     // Key-derived random positions (use_random_start)
+)
+
+// Frame reservation strategies for the frame-LSB method
+const (
+	ReserveLast     = "last"      // Leave the last N bytes of every frame untouched
+	ReserveFirst    = "first"     // Leave the first N bytes of every frame untouched
+	ReserveSideInfo = "side_info" // Leave only the CRC and side information untouched (default)
+)
+
+// Orders in which data bits fill the LSB mask of a carrier byte
+const (
+	BitOrderLowToHigh = "low_to_high" // First bit in the lowest bit (default)
+	BitOrderHighToLow = "high_to_low" // First bit in the highest bit of the mask
+)
+
+// Seed hash algorithms used to derive the position permutation
+const (
+	SeedHashSHA256 = "sha256" // Default
+	SeedHashMD5    = "md5"    // Legacy
+)
+
+// PartManifest places one chunk of a secret split across several stego
+// files. Every part carries it so the set can be checked and reassembled.
+type PartManifest struct {
+	Index  int      // 0-based position of this chunk in the secret
+	Count  int      // Number of parts in the set
+	SHA256 [32]byte // Hash of the whole secret, shared by every part of the set
+}
+
 // StegoConfig represents configuration for steganography operations
 type StegoConfig struct {
 	Key            string
 	UseEncryption  bool
 	UseRandomStart bool
 	LSBBits        int
+	BitOrder       string // Bit packing order within the LSB mask, defaults to BitOrderLowToHigh
 	SecretFilename string
+	SecretMIMEType string          // Optional MIME type stored with the secret
+	Salt           string          // Optional per-file salt mixed into the position seed
+	SeedHash       string          // Seed hash algorithm, defaults to SeedHashSHA256
+	Marker         string          // 4-byte marker opening the preamble, defaults to the standard marker
+	Positions      []int           // Explicit payload positions overriding the generated ones (debug only)
+	Metadata       json.RawMessage // Optional JSON object stored alongside the secret
+	FileID         string          // Optional tracking ID identifying this copy
+	Density        int             // Embed in every Density-th safe byte only (keyed phase), 0 or 1 uses all
+	Expires        time.Time       // Extraction refuses the payload after this time, zero never expires
+	Part           *PartManifest   // Set when the secret is one chunk of a secret split across files
+	ID3Checksum    bool            // Store a checksum of the cover's ID3v2 tag so re-tagging is reported
+	IgnoreExpiry   bool            // Extract expired payloads anyway
+	CheckLegacy    bool            // CheckKeyInMP3 also accepts the original layout, at the cost of a full extraction
+
+	Method             string // Embedding method, defaults to MethodAncillary
+	FrameReservation   string // Frame-LSB reservation strategy, defaults to ReserveSideInfo
+	FrameReservedBytes int    // Bytes reserved per frame for ReserveFirst/ReserveLast
+
+	// FrameFilter excludes frames from embedding when it returns false. The
+	// same filter must be used for extraction; nil accepts every frame. The
+	// Xing/Info frame is always excluded.
+	FrameFilter func(index int, frame *mp3parser.MP3Frame) bool
+
+	MaxOutputBytes int // Extraction rejects declared secrets larger than this, 0 keeps the default cap
+
+	MaxFilenameBytes int // Longest secret filename embedded or accepted on extraction, 0 keeps the default
+
+	// StageHook is called with "embed" once the payload is written into the
+	// carrier bytes, before the MP3 is serialized again, so callers can time
+	// the two separately. nil disables it.
+	StageHook func(stage string)
 }
@@ -2,6 +2,7 @@
 package mp3parser
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"math"
@@ -99,6 +100,14 @@ func ParseSideInfo(frameHeader *MP3FrameHeader, sideInfo []byte) ([][]GranuleCha
 	return result, nil
 }
 
+// ErrImplausibleSideInfo is returned by AnalyzeFrameData when the side info
+// size implied by the header leaves no room for main data in the frame
+var ErrImplausibleSideInfo = errors.New("side info size is implausible for the frame length")
+
+// AnalyzeFrameData splits the frame data into side info, main data,
+// ancillary data and padding. Frames too short to hold the side info implied
+// by their header are rejected with ErrImplausibleSideInfo rather than
+// guessed at, so callers can skip them explicitly.
 func AnalyzeFrameData(frameHeader *MP3FrameHeader, frameData []byte) (*MP3FrameRegions, error) {
 	if len(frameData) < 4 {
 		return nil, fmt.Errorf("frame data too short")
@@ -106,25 +115,10 @@ func AnalyzeFrameData(frameHeader *MP3FrameHeader, frameData []byte) (*MP3FrameR
 
 	regions := &MP3FrameRegions{}
 
-	// Calculate side info size
-	var sideInfoSize int
-	if frameHeader.VersionID == 3 { // MPEG-1
-		if frameHeader.ChannelMode == 3 {
-			sideInfoSize = 17
-		} else {
-			sideInfoSize = 32
-		}
-	} else { // MPEG-2/2.5
-		if frameHeader.ChannelMode == 3 {
-			sideInfoSize = 9
-		} else {
-			sideInfoSize = 17
-		}
-	}
+	sideInfoSize := SideInfoSize(frameHeader)
 
 	if sideInfoSize >= len(frameData) {
-		regions.SideInfo = frameData
-		return regions, nil
+		return nil, fmt.Errorf("%w: %d bytes of side info in %d bytes of frame data", ErrImplausibleSideInfo, sideInfoSize, len(frameData))
 	}
 
 	// Split side info
@@ -175,6 +169,68 @@ func AnalyzeFrameData(frameHeader *MP3FrameHeader, frameData []byte) (*MP3FrameR
 	return regions, nil
 }
 
+// CountUnanalyzableFrames returns how many audio frames, the Xing/Info frame
+// excluded, fail AnalyzeFrameData. Their regions are unknown, so they are left
+// untouched and add nothing to the ancillary capacity.
+func CountUnanalyzableFrames(mp3File *MP3File) int {
+	failed := 0
+	for _, frame := range mp3File.Frames {
+		if frame.IsInfo {
+			continue
+		}
+		if _, err := AnalyzeFrameData(frame.Header, frame.Data); err != nil {
+			failed++
+		}
+	}
+	return failed
+}
+
+// SamplesPerFrame returns how many samples per channel a Layer III frame
+// decodes to: two granules of 576 for MPEG-1, one for MPEG-2/2.5
+func SamplesPerFrame(frameHeader *MP3FrameHeader) int {
+	if frameHeader.VersionID == 3 { // MPEG-1
+		return 1152
+	}
+	return 576
+}
+
+// EstimateDuration returns the playing time in seconds from the frame headers
+// alone, without decoding. The Xing/Info frame is skipped since it carries no
+// audio, and gapless delay and padding are not subtracted, so the result
+// tracks a plain decode of the frames to within one frame.
+func EstimateDuration(mp3File *MP3File) float64 {
+	// Count whole samples per sample rate so long files don't accumulate rounding
+	samples := make(map[int]int)
+	for _, frame := range mp3File.Frames {
+		if frame.IsInfo || frame.Header.SampleRate == 0 {
+			continue
+		}
+		samples[frame.Header.SampleRate] += SamplesPerFrame(frame.Header)
+	}
+
+	duration := 0.0
+	for sampleRate, count := range samples {
+		duration += float64(count) / float64(sampleRate)
+	}
+	return duration
+}
+
+// SideInfoSize returns the Layer III side information size in bytes
+func SideInfoSize(frameHeader *MP3FrameHeader) int {
+	if frameHeader.VersionID == 3 { // MPEG-1
+		if frameHeader.ChannelMode == 3 {
+			return 17
+		}
+		return 32
+	}
+
+	// MPEG-2/2.5
+	if frameHeader.ChannelMode == 3 {
+		return 9
+	}
+	return 17
+}
+
 func (regions *MP3FrameRegions) GetSafeModificationBytes() []byte {
 	safe := make([]byte, 0)
 	safe = append(safe, regions.AncillaryData...)
@@ -0,0 +1,74 @@
+package mp3parser
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestAnalyzeFrameDataRejectsShortFrames(t *testing.T) {
+	// MPEG-1 side info takes 32 bytes in stereo and 17 in mono
+	stereo := &MP3FrameHeader{VersionID: 3, ChannelMode: ChannelModeStereo}
+	mono := &MP3FrameHeader{VersionID: 3, ChannelMode: ChannelModeMono}
+
+	tests := []struct {
+		name       string
+		header     *MP3FrameHeader
+		length     int
+		wantErr    bool
+		wantShort  bool // ErrImplausibleSideInfo
+		wantRemain int  // Bytes after the side info
+	}{
+		{name: "too short to analyze", header: stereo, length: 3, wantErr: true},
+		{name: "stereo, shorter than the side info", header: stereo, length: 20, wantErr: true, wantShort: true},
+		{name: "stereo, exactly the side info", header: stereo, length: 32, wantErr: true, wantShort: true},
+		{name: "stereo, one byte past the side info", header: stereo, length: 33, wantRemain: 1},
+		{name: "mono, exactly the side info", header: mono, length: 17, wantErr: true, wantShort: true},
+		{name: "mono, room for the side info", header: mono, length: 32, wantRemain: 15},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			regions, err := AnalyzeFrameData(tt.header, make([]byte, tt.length))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want an error %v", err, tt.wantErr)
+			}
+			if errors.Is(err, ErrImplausibleSideInfo) != tt.wantShort {
+				t.Errorf("err = %v, want ErrImplausibleSideInfo %v", err, tt.wantShort)
+			}
+			if err != nil {
+				return
+			}
+			remain := len(regions.MainData) + len(regions.AncillaryData) + len(regions.Padding)
+			if len(regions.SideInfo) != SideInfoSize(tt.header) || remain != tt.wantRemain {
+				t.Errorf("side info %d bytes, %d after it, want %d and %d", len(regions.SideInfo), remain, SideInfoSize(tt.header), tt.wantRemain)
+			}
+		})
+	}
+}
+
+func TestCountUnanalyzableFrames(t *testing.T) {
+	header := &MP3FrameHeader{VersionID: 3, ChannelMode: ChannelModeStereo}
+	good := &MP3Frame{Header: header, Data: make([]byte, 413)}
+	short := &MP3Frame{Header: header, Data: make([]byte, 20)}
+	shortInfo := &MP3Frame{Header: header, Data: make([]byte, 20), IsInfo: true}
+
+	tests := []struct {
+		name   string
+		frames []*MP3Frame
+		want   int
+	}{
+		{name: "none", frames: []*MP3Frame{good, good}},
+		{name: "some", frames: []*MP3Frame{good, short, good, short, short}, want: 3},
+		{name: "all", frames: []*MP3Frame{short, short}, want: 2},
+		// The Info frame carries no audio and is never counted
+		{name: "short Info frame", frames: []*MP3Frame{shortInfo, good, short}, want: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CountUnanalyzableFrames(&MP3File{Frames: tt.frames}); got != tt.want {
+				t.Errorf("%d unanalyzable frames, want %d", got, tt.want)
+			}
+		})
+	}
+}
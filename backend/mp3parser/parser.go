@@ -16,16 +16,24 @@ func syncSafeToInt(b []byte) int {
 		int(b[3]&0x7F)
 }
 
+// ReadID3v2 reads the ID3v2 tag at the start of r. When there is none (the
+// file starts directly with an MPEG frame, or is shorter than a tag header)
+// it returns a nil header and rewinds r, which must then be an io.Seeker, to
+// where it started.
 func ReadID3v2(r io.Reader) (*ID3v2Header, []byte, error) {
 	buf := make([]byte, 10)
-	_, err := io.ReadFull(r, buf)
-	if err != nil {
+	n, err := io.ReadFull(r, buf)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
 		return nil, nil, err
 	}
-	if string(buf[:3]) != "ID3" {
+	if n < len(buf) || string(buf[:3]) != "ID3" {
 		// no ID3v2, seek back
-		if seeker, ok := r.(io.Seeker); ok {
-			seeker.Seek(-10, io.SeekCurrent)
+		seeker, ok := r.(io.Seeker)
+		if !ok {
+			return nil, nil, fmt.Errorf("no ID3v2 tag and the reader cannot seek back")
+		}
+		if _, err := seeker.Seek(int64(-n), io.SeekCurrent); err != nil {
+			return nil, nil, err
 		}
 		return nil, nil, nil
 	}
@@ -42,9 +50,71 @@ func ReadID3v2(r io.Reader) (*ID3v2Header, []byte, error) {
 		return nil, nil, err
 	}
 
+	// ID3v2.4 may append a footer that is not counted in the tag size;
+	// consume it so frame parsing starts right after the tag
+	if h.Version[0] == 4 && h.Flags&id3v2FooterFlag != 0 {
+		footer := make([]byte, id3v2FooterSize)
+		if _, err := io.ReadFull(r, footer); err != nil {
+			return nil, nil, err
+		}
+		if string(footer[:3]) != "3DI" {
+			return nil, nil, fmt.Errorf("ID3v2 footer flag set but no footer found")
+		}
+		h.HasFooter = true
+	}
+
 	return h, id3Data, nil
 }
 
+// encodeID3v2Header encodes the ID3v2 header, or the footer when id is "3DI",
+// with the size taken from the tag data
+func encodeID3v2Header(id string, header *ID3v2Header, size int) []byte {
+	encoded := make([]byte, 0, 10)
+	encoded = append(encoded, id...)
+	encoded = append(encoded, header.Version[0], header.Version[1], header.Flags)
+	encoded = append(encoded,
+		byte((size>>21)&0x7F),
+		byte((size>>14)&0x7F),
+		byte((size>>7)&0x7F),
+		byte(size&0x7F),
+	)
+	return encoded
+}
+
+// Lookup tables (MPEG1 Layer III only for now). Zero entries are free format
+// or reserved and are rejected.
+var (
+	bitrateTable = [16]int{
+		0, 32, 40, 48, 56, 64, 80, 96,
+		112, 128, 160, 192, 224, 256, 320, 0,
+	} // kbps
+	sampleRateTable = [4]int{44100, 48000, 32000, 0}
+)
+
+// SupportedBitrates returns the bitrates (bps) the frame parser accepts,
+// read from its lookup table
+func SupportedBitrates() []int {
+	bitrates := make([]int, 0, len(bitrateTable))
+	for _, kbps := range bitrateTable {
+		if kbps != 0 {
+			bitrates = append(bitrates, kbps*1000)
+		}
+	}
+	return bitrates
+}
+
+// SupportedSampleRates returns the sample rates (Hz) the frame parser
+// accepts, read from its lookup table
+func SupportedSampleRates() []int {
+	sampleRates := make([]int, 0, len(sampleRateTable))
+	for _, rate := range sampleRateTable {
+		if rate != 0 {
+			sampleRates = append(sampleRates, rate)
+		}
+	}
+	return sampleRates
+}
+
 func ReadFrameHeader(r io.Reader) (*MP3FrameHeader, []byte, []byte, error) {
 	headerBytes := make([]byte, 4)
 	_, err := io.ReadFull(r, headerBytes)
@@ -66,13 +136,6 @@ func ReadFrameHeader(r io.Reader) (*MP3FrameHeader, []byte, []byte, error) {
 	padding := ((header >> 9) & 0x1) == 1
 	channelMode := int((header >> 6) & 0x3)
 
-	// lookup tables (MPEG1 Layer III only for now)
-	bitrateTable := [16]int{
-		0, 32, 40, 48, 56, 64, 80, 96,
-		112, 128, 160, 192, 224, 256, 320, 0,
-	}
-	sampleRateTable := [4]int{44100, 48000, 32000, 0}
-
 	bitrate := bitrateTable[bitrateIdx] * 1000
 	sampleRate := sampleRateTable[sampleRateIdx]
 
@@ -107,20 +170,25 @@ func ReadID3v1(f *os.File) (*ID3v1Tag, error) {
 	if err != nil {
 		return nil, err
 	}
-	if stat.Size() < 128 {
+	if stat.Size() < id3v1Size {
 		return nil, nil
 	}
 	currentPos, _ := f.Seek(0, io.SeekCurrent)
-	f.Seek(-128, io.SeekEnd)
-	buf := make([]byte, 128)
+	f.Seek(-id3v1Size, io.SeekEnd)
+	buf := make([]byte, id3v1Size)
 	_, err = io.ReadFull(f, buf)
 	if err != nil {
 		return nil, err
 	}
 	f.Seek(currentPos, io.SeekStart) // restore position
 
-	if string(buf[:3]) != "TAG" {
-		return nil, nil
+	return parseID3v1(buf), nil
+}
+
+// parseID3v1 decodes a 128-byte ID3v1 tag, nil when buf does not start with "TAG"
+func parseID3v1(buf []byte) *ID3v1Tag {
+	if len(buf) != id3v1Size || string(buf[:3]) != "TAG" {
+		return nil
 	}
 	return &ID3v1Tag{
 		Title:   string(buf[3:33]),
@@ -129,15 +197,25 @@ func ReadID3v1(f *os.File) (*ID3v1Tag, error) {
 		Year:    string(buf[93:97]),
 		Comment: string(buf[97:127]),
 		Genre:   buf[127],
-	}, nil
+	}
 }
 
 // ParseMP3File parses an entire MP3 file
 func ParseMP3File(data []byte) (*MP3File, error) {
-	reader := bytes.NewReader(data)
-
 	mp3File := &MP3File{}
 
+	// A trailing ID3v1 tag is kept aside rather than skipped as junk after
+	// the last frame, so it is neither reported nor lost on rewrite
+	if len(data) >= id3v1Size {
+		if tag := parseID3v1(data[len(data)-id3v1Size:]); tag != nil {
+			mp3File.ID3v1 = tag
+			mp3File.ID3v1Data = data[len(data)-id3v1Size:]
+			data = data[:len(data)-id3v1Size]
+		}
+	}
+
+	reader := bytes.NewReader(data)
+
 	// Read ID3v2 if present
 	id3v2, id3v2Data, err := ReadID3v2(reader)
 	if err != nil {
@@ -147,15 +225,53 @@ func ParseMP3File(data []byte) (*MP3File, error) {
 	mp3File.ID3v2Data = id3v2Data
 
 	// Read MP3 frames
+	skipping := false
+	var other UnsupportedFormatError
+	otherFrames := 0
 	for {
+		frameStart, _ := reader.Seek(0, io.SeekCurrent)
 		frameHeader, headerBytes, frameData, err := ReadFrameHeader(reader)
 		if err != nil {
 			if err == io.EOF {
 				break
 			}
-			// Try to skip invalid data and find next frame
+			// Resync one byte past the failed header so frames are found
+			// regardless of how many bytes precede them (e.g. a re-tagged
+			// ID3v2 whose size is not a multiple of 4)
+			mp3File.SkippedBytes++
+			if !skipping {
+				mp3File.SkippedRegions++
+				skipping = true
+			}
+			reader.Seek(frameStart+1, io.SeekStart)
+			continue
+		}
+
+		// Only MPEG-1 Layer III frame lengths and regions are understood;
+		// the lookup tables above are MPEG-1 only. Another version or layer
+		// is usually a false sync in junk and is resynced past like one, but
+		// a file whose leading candidates keep agreeing on another format is
+		// rejected up front instead of misread frame by frame
+		if frameHeader.VersionID != MPEGVersion1 || frameHeader.Layer != LayerIII {
+			if len(mp3File.Frames) == 0 {
+				format := UnsupportedFormatError{Version: frameHeader.VersionID, Layer: frameHeader.Layer}
+				if format != other {
+					other, otherFrames = format, 0
+				}
+				otherFrames++
+				if otherFrames >= formatDecisionFrames {
+					return nil, &other
+				}
+			}
+			mp3File.SkippedBytes++
+			if !skipping {
+				mp3File.SkippedRegions++
+				skipping = true
+			}
+			reader.Seek(frameStart+1, io.SeekStart)
 			continue
 		}
+		skipping = false
 
 		frame := &MP3Frame{
 			Header:      frameHeader,
@@ -165,6 +281,19 @@ func ParseMP3File(data []byte) (*MP3File, error) {
 		mp3File.Frames = append(mp3File.Frames, frame)
 	}
 
+	// A short file of another format may end before the decision is made
+	if len(mp3File.Frames) == 0 && otherFrames > 0 {
+		return nil, &other
+	}
+
+	// The Xing/Info tag (and its LAME gapless info) can only live in the first frame
+	if len(mp3File.Frames) > 0 {
+		if xing := ParseXingFrame(mp3File.Frames[0]); xing != nil {
+			mp3File.Frames[0].IsInfo = true
+			mp3File.Xing = xing
+		}
+	}
+
 	return mp3File, nil
 }
 
@@ -173,23 +302,18 @@ func WriteMP3File(mp3File *MP3File) ([]byte, error) {
 
 	// Write ID3v2 if present
 	if mp3File.ID3v2 != nil {
-		// Write ID3v2 header
-		buf.WriteString("ID3")
-		buf.WriteByte(mp3File.ID3v2.Version[0])
-		buf.WriteByte(mp3File.ID3v2.Version[1])
-		buf.WriteByte(mp3File.ID3v2.Flags)
-
-		// Write syncsafe size
-		size := mp3File.ID3v2.Size
-		sizeBuf := make([]byte, 4)
-		sizeBuf[0] = byte((size >> 21) & 0x7F)
-		sizeBuf[1] = byte((size >> 14) & 0x7F)
-		sizeBuf[2] = byte((size >> 7) & 0x7F)
-		sizeBuf[3] = byte(size & 0x7F)
-		buf.Write(sizeBuf)
+		// Write ID3v2 header with a syncsafe size taken from the tag data so
+		// an edited tag stays consistent with its header
+		size := len(mp3File.ID3v2Data)
+		buf.Write(encodeID3v2Header("ID3", mp3File.ID3v2, size))
 
 		// Write ID3v2 data
 		buf.Write(mp3File.ID3v2Data)
+
+		// The footer mirrors the header
+		if mp3File.ID3v2.HasFooter {
+			buf.Write(encodeID3v2Header("3DI", mp3File.ID3v2, size))
+		}
 	}
 
 	for _, frame := range mp3File.Frames {
@@ -197,6 +321,9 @@ func WriteMP3File(mp3File *MP3File) ([]byte, error) {
 		buf.Write(frame.Data)
 	}
 
+	// Bytes skipped while parsing are not written back, but the ID3v1 tag is
+	buf.Write(mp3File.ID3v1Data)
+
 	return buf.Bytes(), nil
 }
 
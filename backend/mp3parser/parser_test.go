@@ -0,0 +1,301 @@
+package mp3parser
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"slices"
+	"testing"
+)
+
+const testCoverPath = "../../test_cases/file_example_MP3_700KB.mp3"
+
+// An MPEG-1 Layer III frame header without CRC at 128 kbps and 44.1 kHz,
+// stereo, so every frame is 144 * 128000 / 44100 = 417 bytes long
+const (
+	mpeg1LayerIII = 0xFFFB9000
+	frameLength   = 417
+)
+
+// frameBytes builds a frame with the given header and zeroed data
+func frameBytes(header uint32) []byte {
+	frame := make([]byte, frameLength)
+	binary.BigEndian.PutUint32(frame, header)
+	return frame
+}
+
+// stream concatenates frames and junk
+func stream(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+func TestParseCountsSkippedGarbage(t *testing.T) {
+	frame := frameBytes(mpeg1LayerIII)
+	junk := func(n int) []byte { return bytes.Repeat([]byte{'J'}, n) }
+
+	tests := []struct {
+		name        string
+		data        []byte
+		wantSkipped int
+		wantRegions int
+	}{
+		{name: "clean", data: stream(frame, frame, frame)},
+		{name: "one byte", data: stream(frame, junk(1), frame, frame), wantSkipped: 1, wantRegions: 1},
+		{name: "not a multiple of 4", data: stream(frame, junk(7), frame, frame), wantSkipped: 7, wantRegions: 1},
+		{name: "two regions", data: stream(frame, junk(3), frame, junk(5), frame), wantSkipped: 8, wantRegions: 2},
+		{name: "leading", data: stream(junk(5), frame, frame, frame), wantSkipped: 5, wantRegions: 1},
+		{name: "trailing", data: stream(frame, frame, frame, junk(6)), wantSkipped: 6, wantRegions: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mp3File, err := ParseMP3File(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(mp3File.Frames) != 3 {
+				t.Fatalf("%d frames, want 3", len(mp3File.Frames))
+			}
+			if mp3File.SkippedBytes != tt.wantSkipped || mp3File.SkippedRegions != tt.wantRegions {
+				t.Errorf("skipped %d bytes in %d regions, want %d in %d", mp3File.SkippedBytes, mp3File.SkippedRegions, tt.wantSkipped, tt.wantRegions)
+			}
+
+			// Skipped bytes are dropped on rewrite
+			written, err := WriteMP3File(mp3File)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(written, stream(frame, frame, frame)) {
+				t.Errorf("rewrite is %d bytes, want the %d bytes of the frames", len(written), 3*len(frame))
+			}
+		})
+	}
+}
+
+// id3v2Tag builds an ID3v2 tag of the given major version and flags around
+// data, with the footer appended when footer is set
+func id3v2Tag(major, flags byte, data []byte, footer bool) []byte {
+	header := &ID3v2Header{Version: [2]byte{major, 0}, Flags: flags}
+	tag := stream(encodeID3v2Header("ID3", header, len(data)), data)
+	if footer {
+		tag = append(tag, encodeID3v2Header("3DI", header, len(data))...)
+	}
+	return tag
+}
+
+func TestID3v2FooterRoundTrip(t *testing.T) {
+	tagData := stream([]byte("TIT2"), []byte{0, 0, 0, 6, 0, 0, 3}, []byte("Title"))
+	frame := frameBytes(mpeg1LayerIII)
+
+	tests := []struct {
+		name       string
+		tag        []byte
+		wantFooter bool
+		wantErr    bool
+	}{
+		{name: "v2.4 with footer", tag: id3v2Tag(4, id3v2FooterFlag, tagData, true), wantFooter: true},
+		{name: "v2.4 without footer", tag: id3v2Tag(4, 0, tagData, false)},
+		// The footer flag only exists in v2.4
+		{name: "v2.3 with the flag bit set", tag: id3v2Tag(3, id3v2FooterFlag, tagData, false)},
+		{name: "flag without footer", tag: id3v2Tag(4, id3v2FooterFlag, tagData, false), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := stream(tt.tag, frame, frame, frame)
+			mp3File, err := ParseMP3File(data)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("parsed a tag whose footer is missing")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if mp3File.ID3v2 == nil || mp3File.ID3v2.HasFooter != tt.wantFooter {
+				t.Fatalf("ID3v2 = %+v, want a tag with footer %v", mp3File.ID3v2, tt.wantFooter)
+			}
+			if !bytes.Equal(mp3File.ID3v2Data, tagData) {
+				t.Errorf("tag data = %q, want %q", mp3File.ID3v2Data, tagData)
+			}
+			// Frames start right after the footer
+			if len(mp3File.Frames) != 3 || mp3File.SkippedBytes != 0 {
+				t.Errorf("%d frames and %d skipped bytes, want 3 and 0", len(mp3File.Frames), mp3File.SkippedBytes)
+			}
+
+			written, err := WriteMP3File(mp3File)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(written, data) {
+				t.Errorf("rewrite differs from the input: %d bytes, want %d", len(written), len(data))
+			}
+		})
+	}
+}
+
+func TestParseTaglessAndShortInput(t *testing.T) {
+	cover, err := os.ReadFile(testCoverPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tagged, err := ParseMP3File(cover)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tagged.ID3v2 == nil {
+		t.Fatal("test cover has no ID3v2 tag")
+	}
+	tagged.ID3v2, tagged.ID3v2Data = nil, nil
+	tagless, err := WriteMP3File(tagged)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	frame := frameBytes(mpeg1LayerIII)
+	tests := []struct {
+		name       string
+		data       []byte
+		wantFrames int
+	}{
+		{name: "tagless cover", data: tagless, wantFrames: len(tagged.Frames)},
+		{name: "tagless frames", data: stream(frame, frame), wantFrames: 2},
+		{name: "empty", data: nil},
+		{name: "shorter than a tag header", data: []byte("ID3\x04\x00")},
+		{name: "one byte short of a tag header", data: []byte("ID3\x04\x00\x00\x00\x00\x00")},
+		{name: "a lone frame header", data: frame[:4]},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mp3File, err := ParseMP3File(tt.data)
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+			if mp3File.ID3v2 != nil {
+				t.Errorf("found an ID3v2 tag in untagged input")
+			}
+			if len(mp3File.Frames) != tt.wantFrames {
+				t.Fatalf("%d frames, want %d", len(mp3File.Frames), tt.wantFrames)
+			}
+			if tt.wantFrames == 0 {
+				return
+			}
+
+			written, err := WriteMP3File(mp3File)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(written, tt.data) {
+				t.Errorf("rewrite differs from the input: %d bytes, want %d", len(written), len(tt.data))
+			}
+		})
+	}
+}
+
+func TestReadID3v2NeedsSeekerWithoutTag(t *testing.T) {
+	// bytes.Buffer cannot seek back over the peeked bytes
+	if _, _, err := ReadID3v2(bytes.NewBuffer(frameBytes(mpeg1LayerIII))); err == nil {
+		t.Error("no error for a tagless reader that cannot seek back")
+	}
+}
+
+func TestReadFrameHeaderTables(t *testing.T) {
+	for bitrateIndex, kbps := range bitrateTable {
+		for sampleRateIndex, sampleRate := range sampleRateTable {
+			header := uint32(0xFFFB0000) | uint32(bitrateIndex)<<12 | uint32(sampleRateIndex)<<10
+			data := make([]byte, 4, 2000)
+			binary.BigEndian.PutUint32(data, header)
+			data = data[:cap(data)]
+
+			frameHeader, _, _, err := ReadFrameHeader(bytes.NewReader(data))
+			supported := slices.Contains(SupportedBitrates(), kbps*1000) && slices.Contains(SupportedSampleRates(), sampleRate)
+			if supported != (err == nil) {
+				t.Errorf("bitrate index %d, sample rate index %d: err = %v, advertised %v", bitrateIndex, sampleRateIndex, err, supported)
+				continue
+			}
+			if err == nil && (frameHeader.Bitrate != kbps*1000 || frameHeader.SampleRate != sampleRate) {
+				t.Errorf("parsed %d bps at %d Hz, want %d bps at %d Hz", frameHeader.Bitrate, frameHeader.SampleRate, kbps*1000, sampleRate)
+			}
+		}
+	}
+}
+
+// Headers of the other layers at the same bitrate and sample rate
+const (
+	mpeg1LayerII   = 0xFFFD9000
+	mpeg1LayerI    = 0xFFFF9000
+	mpeg2LayerIII  = 0xFFF39000
+	mpeg25LayerIII = 0xFFE39000
+)
+
+func TestParseRejectsUnsupportedFormats(t *testing.T) {
+	frames := func(header uint32, n int) []byte {
+		return bytes.Repeat(frameBytes(header), n)
+	}
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr string
+	}{
+		{name: "Layer II", data: frames(mpeg1LayerII, 10), wantErr: "Layer II files are not supported yet"},
+		{name: "Layer I", data: frames(mpeg1LayerI, 10), wantErr: "Layer I files are not supported yet"},
+		// Ends before the decision count is reached, without a Layer III frame
+		{name: "short Layer II", data: frames(mpeg1LayerII, 2), wantErr: "Layer II files are not supported yet"},
+		{name: "MPEG-2", data: frames(mpeg2LayerIII, 10), wantErr: "MPEG-2 files are not supported yet"},
+		{name: "MPEG-2.5", data: frames(mpeg25LayerIII, 10), wantErr: "MPEG-2.5 files are not supported yet"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseMP3File(tt.data)
+			var format *UnsupportedFormatError
+			if !errors.As(err, &format) || err.Error() != tt.wantErr {
+				t.Errorf("err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseSkipsStrayHeaders(t *testing.T) {
+	frame := frameBytes(mpeg1LayerIII)
+	stray := frameBytes(mpeg1LayerII)[:4]
+	strayMPEG2 := frameBytes(mpeg2LayerIII)[:4]
+
+	tests := []struct {
+		name        string
+		data        []byte
+		wantSkipped int
+	}{
+		{name: "between frames", data: stream(frame, stray, frame, stray, frame), wantSkipped: 8},
+		{name: "many after the first frame", data: stream(frame, stray, stray, stray, stray, stray, frame, frame), wantSkipped: 20},
+		// Fewer than the decision count before any Layer III frame
+		{name: "leading", data: stream(stray, stray, stray, frame, frame, frame), wantSkipped: 12},
+		{name: "MPEG-2 between frames", data: stream(frame, strayMPEG2, frame, strayMPEG2, frame), wantSkipped: 8},
+		{name: "MPEG-2 leading", data: stream(strayMPEG2, strayMPEG2, frame, frame, frame), wantSkipped: 8},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mp3File, err := ParseMP3File(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(mp3File.Frames) != 3 {
+				t.Errorf("%d frames, want 3", len(mp3File.Frames))
+			}
+			if mp3File.SkippedBytes != tt.wantSkipped {
+				t.Errorf("skipped %d bytes, want %d", mp3File.SkippedBytes, tt.wantSkipped)
+			}
+			for i, parsed := range mp3File.Frames {
+				if parsed.Header.VersionID != MPEGVersion1 || parsed.Header.Layer != LayerIII {
+					t.Errorf("frame %d is version %d layer %d", i, parsed.Header.VersionID, parsed.Header.Layer)
+				}
+			}
+		})
+	}
+}
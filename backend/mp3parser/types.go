@@ -1,10 +1,92 @@
 package mp3parser
 
+import "fmt"
+
 // ID3v2Header represents ID3v2 tag header
 type ID3v2Header struct {
-	Version [2]byte
-	Flags   byte
-	Size    int
+	Version   [2]byte
+	Flags     byte
+	Size      int
+	HasFooter bool // ID3v2.4 tag followed by a 10-byte "3DI" footer
+}
+
+// id3v2FooterFlag marks an ID3v2.4 tag that ends with a footer
+const id3v2FooterFlag = 0x10
+
+// id3v2FooterSize is the size of the ID3v2.4 footer, a copy of the header
+// starting with "3DI"
+const id3v2FooterSize = 10
+
+// Channel modes stored in the frame header
+const (
+	ChannelModeStereo      = 0
+	ChannelModeJointStereo = 1
+	ChannelModeDualChannel = 2 // Two independent mono programs
+	ChannelModeMono        = 3
+)
+
+// MPEG versions as stored in the frame header; only MPEG-1 is supported
+const (
+	MPEGVersion25 = 0
+	MPEGVersion2  = 2
+	MPEGVersion1  = 3
+)
+
+// Layers as stored in the frame header; only Layer III is supported
+const (
+	LayerIII = 1
+	LayerII  = 2
+	LayerI   = 3
+)
+
+// VersionName returns the name of an MPEG version ID, e.g. "MPEG-1"
+func VersionName(versionID int) string {
+	switch versionID {
+	case MPEGVersion1:
+		return "MPEG-1"
+	case MPEGVersion2:
+		return "MPEG-2"
+	case MPEGVersion25:
+		return "MPEG-2.5"
+	}
+	return "reserved"
+}
+
+// LayerName returns the name of a layer, e.g. "III"
+func LayerName(layer int) string {
+	switch layer {
+	case LayerIII:
+		return "III"
+	case LayerII:
+		return "II"
+	case LayerI:
+		return "I"
+	}
+	return "reserved"
+}
+
+// formatDecisionFrames is how many frame headers of one other version or
+// layer must be found before the first MPEG-1 Layer III frame to reject a
+// file as that format
+const formatDecisionFrames = 4
+
+// UnsupportedFormatError is returned by ParseMP3File when a file holds
+// frames of another MPEG version or layer and no MPEG-1 Layer III frames
+type UnsupportedFormatError struct {
+	Version int
+	Layer   int
+}
+
+func (e *UnsupportedFormatError) Error() string {
+	switch {
+	case e.Layer == LayerII || e.Layer == LayerI:
+		return fmt.Sprintf("Layer %s files are not supported yet", LayerName(e.Layer))
+	case e.Layer != LayerIII:
+		return "files with a reserved MPEG layer are not supported"
+	case e.Version == MPEGVersion2 || e.Version == MPEGVersion25:
+		return fmt.Sprintf("%s files are not supported yet", VersionName(e.Version))
+	}
+	return "files with a reserved MPEG version are not supported"
 }
 
 // MP3FrameHeader represents an MP3 frame header
@@ -19,6 +101,9 @@ type MP3FrameHeader struct {
 	FrameLength   int
 }
 
+// id3v1Size is the size of an ID3v1 tag, which starts with "TAG"
+const id3v1Size = 128
+
 // ID3v1Tag represents ID3v1 tag (128 bytes at end of file)
 type ID3v1Tag struct {
 	Title   string
@@ -34,6 +119,7 @@ type MP3Frame struct {
 	Header      *MP3FrameHeader
 	HeaderBytes []byte // Original 4-byte header - NEVER MODIFY
 	Data        []byte // Frame payload data - steganography goes here
+	IsInfo      bool   // Xing/Info tag frame - carries no audio, NEVER MODIFY
 }
 
 // MP3File represents the structure of an MP3 file
@@ -42,4 +128,13 @@ type MP3File struct {
 	ID3v2Data []byte
 	Frames    []*MP3Frame
 	ID3v1     *ID3v1Tag
+	ID3v1Data []byte    // Raw ID3v1 tag, written back after the frames
+	Xing      *XingInfo // Xing/Info tag from the first frame, nil when absent
+
+	// Bytes between frames that did not parse as a frame header, and the
+	// number of contiguous regions they form. A trailing ID3v1 tag is
+	// recognised and not counted; other trailing data (e.g. APE tags) is.
+	// Skipped bytes are not written back by WriteMP3File.
+	SkippedBytes   int
+	SkippedRegions int
 }
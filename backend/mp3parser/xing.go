@@ -0,0 +1,108 @@
+package mp3parser
+
+import (
+	"encoding/binary"
+	"strings"
+)
+
+const (
+	xingFlagFrames  = 0x1
+	xingFlagBytes   = 0x2
+	xingFlagTOC     = 0x4
+	xingFlagQuality = 0x8
+
+	xingTOCSize = 100
+
+	// Offset of the 3-byte encoder delay/padding field inside the LAME tag
+	lameDelayPaddingOffset = 21
+	lameTagMinSize         = lameDelayPaddingOffset + 3
+)
+
+// XingInfo describes the Xing ("VBR") or Info ("CBR") tag that encoders place
+// in the first frame of a stream. The frame carrying it holds no audio and its
+// LAME extension stores the encoder delay/padding used for gapless playback,
+// so it must be passed through untouched.
+type XingInfo struct {
+	Tag            string // "Xing" or "Info"
+	Frames         uint32 // Total frames, 0 when not present
+	Bytes          uint32 // Total stream bytes, 0 when not present
+	HasLAMETag     bool
+	Encoder        string // e.g. "LAME3.100", empty without a LAME tag
+	EncoderDelay   int    // Samples added at the start by the encoder
+	EncoderPadding int    // Samples added at the end by the encoder
+}
+
+// ParseXingFrame returns the Xing/Info tag carried by the frame, or nil when
+// the frame is a regular audio frame.
+func ParseXingFrame(frame *MP3Frame) *XingInfo {
+	offset := SideInfoSize(frame.Header)
+	if frame.Header.ProtectionBit {
+		offset += 2 // CRC-16 precedes the side info
+	}
+
+	data := frame.Data
+	if len(data) < offset+8 {
+		return nil
+	}
+
+	tag := string(data[offset : offset+4])
+	if tag != "Xing" && tag != "Info" {
+		return nil
+	}
+
+	info := &XingInfo{Tag: tag}
+	flags := binary.BigEndian.Uint32(data[offset+4 : offset+8])
+	pos := offset + 8
+
+	if flags&xingFlagFrames != 0 {
+		if len(data) < pos+4 {
+			return info
+		}
+		info.Frames = binary.BigEndian.Uint32(data[pos : pos+4])
+		pos += 4
+	}
+	if flags&xingFlagBytes != 0 {
+		if len(data) < pos+4 {
+			return info
+		}
+		info.Bytes = binary.BigEndian.Uint32(data[pos : pos+4])
+		pos += 4
+	}
+	if flags&xingFlagTOC != 0 {
+		pos += xingTOCSize
+	}
+	if flags&xingFlagQuality != 0 {
+		pos += 4
+	}
+
+	// The LAME extension (also written by FFmpeg as "Lavc"/"Lavf") follows
+	if len(data) < pos+lameTagMinSize {
+		return info
+	}
+
+	lame := data[pos : pos+lameTagMinSize]
+	encoder := strings.TrimRight(string(lame[:9]), "\x00 ")
+	if !isPrintableASCII(encoder) {
+		return info
+	}
+
+	info.HasLAMETag = true
+	info.Encoder = encoder
+	delayPadding := lame[lameDelayPaddingOffset : lameDelayPaddingOffset+3]
+	info.EncoderDelay = int(delayPadding[0])<<4 | int(delayPadding[1])>>4
+	info.EncoderPadding = int(delayPadding[1]&0x0F)<<8 | int(delayPadding[2])
+
+	return info
+}
+
+func isPrintableASCII(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < 0x20 || s[i] > 0x7E {
+			return false
+		}
+	}
+	return true
+}
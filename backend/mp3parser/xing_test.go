@@ -0,0 +1,62 @@
+package mp3parser
+
+import (
+	"os"
+	"testing"
+)
+
+func TestParseXingFrameLAMETag(t *testing.T) {
+	tests := []struct {
+		name        string
+		path        string
+		wantTag     string
+		wantEncoder string
+		wantDelay   int
+		wantPadding int
+	}{
+		{
+			name:        "LAME VBR",
+			path:        "../../test_cases/file_example_MP3_700KB.mp3",
+			wantTag:     "Xing",
+			wantEncoder: "LAME3.100",
+			wantDelay:   576,
+			wantPadding: 1576,
+		},
+		{
+			name:        "FFmpeg CBR",
+			path:        "../../test_cases/Billie Eilish - WILDFLOWER (Official Lyric Video).mp3",
+			wantTag:     "Info",
+			wantEncoder: "Lavc60.31",
+			wantDelay:   576,
+			wantPadding: 832,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := os.ReadFile(tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			mp3File, err := ParseMP3File(data)
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+
+			xing := mp3File.Xing
+			if xing == nil || !mp3File.Frames[0].IsInfo {
+				t.Fatal("Xing/Info frame not detected")
+			}
+			if !xing.HasLAMETag || xing.Tag != tt.wantTag || xing.Encoder != tt.wantEncoder {
+				t.Errorf("tag = %q, encoder = %q, LAME = %v", xing.Tag, xing.Encoder, xing.HasLAMETag)
+			}
+			if xing.EncoderDelay != tt.wantDelay || xing.EncoderPadding != tt.wantPadding {
+				t.Errorf("delay/padding = %d/%d, want %d/%d", xing.EncoderDelay, xing.EncoderPadding, tt.wantDelay, tt.wantPadding)
+			}
+			// Only the first frame can carry the tag
+			if ParseXingFrame(mp3File.Frames[1]) != nil || mp3File.Frames[1].IsInfo {
+				t.Error("audio frame detected as an Info frame")
+			}
+		})
+	}
+}
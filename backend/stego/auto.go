@@ -0,0 +1,154 @@
+package stego
+
+import (
+	"errors"
+	"fmt"
+
+	"steganography-backend/models"
+)
+
+// autoMethods lists the MP3 methods tried by automatic detection, in order
+var autoMethods = []string{models.MethodAncillary, models.MethodFrameLSB}
+
+// autoSteganography extracts with whichever MP3 method the file was embedded
+// with. Each method is tried in turn and the first whose preamble carries the
+// marker wins, so a key mismatch or a damaged payload under that method is
+// reported as is rather than masked by the remaining methods.
+//
+// The preamble does not record whether the payload starts at random
+// positions, so a payload that fails to parse under the requested placement
+// is retried with the other one before the failure is reported.
+type autoSteganography struct {
+	methods    []MP3Steganography
+	alternates []MP3Steganography // Same methods with the other placement, nil entries when fixed
+	names      []string
+	config     *models.StegoConfig
+}
+
+func newAutoSteganography(config *models.StegoConfig) (*autoSteganography, error) {
+	auto := &autoSteganography{config: config}
+	for _, name := range autoMethods {
+		methodConfig := *config
+		methodConfig.Method = name
+
+		method, err := NewMP3Steganography(&methodConfig)
+		if err != nil {
+			return nil, err
+		}
+
+		// Explicit positions replace the placement, so there is nothing to retry
+		var alternate MP3Steganography
+		if config.Positions == nil {
+			alternateConfig := methodConfig
+			alternateConfig.UseRandomStart = !config.UseRandomStart
+			if alternate, err = NewMP3Steganography(&alternateConfig); err != nil {
+				return nil, err
+			}
+		}
+
+		auto.methods = append(auto.methods, method)
+		auto.alternates = append(auto.alternates, alternate)
+		auto.names = append(auto.names, name)
+	}
+	return auto, nil
+}
+
+// placement names the placement used with the given settings
+func placement(useRandomStart bool, density int) string {
+	switch {
+	case useRandomStart:
+		return models.PlacementRandom
+	case density > 1:
+		return models.PlacementStrided
+	default:
+		return models.PlacementSequential
+	}
+}
+
+// detect runs attempt with each method until one finds the marker and
+// returns the name of that method
+func (auto *autoSteganography) detect(attempt func(method MP3Steganography) error) (string, error) {
+	for i, method := range auto.methods {
+		err := attempt(method)
+		if errors.Is(err, ErrNoPayload) {
+			continue
+		}
+		return auto.names[i], err
+	}
+	return "", ErrNoPayload
+}
+
+func (auto *autoSteganography) CalculateCapacity(mp3Data []byte) (int, error) {
+	return 0, fmt.Errorf("automatic method detection is only available for extraction")
+}
+
+func (auto *autoSteganography) EmbedInMP3(mp3Data []byte, secretData []byte) ([]byte, error) {
+	return nil, fmt.Errorf("automatic method detection is only available for extraction")
+}
+
+func (auto *autoSteganography) ExtractFromMP3(mp3Data []byte) ([]byte, string, error) {
+	payload, err := auto.ExtractPayloadFromMP3(mp3Data)
+	if err != nil {
+		return nil, "", err
+	}
+	return payload.Data, payload.Filename, nil
+}
+
+// ExtractPayloadFromMP3 extracts the secret and records the detected method
+// and placement
+func (auto *autoSteganography) ExtractPayloadFromMP3(mp3Data []byte) (*Payload, error) {
+	var payload *Payload
+	var alternate bool
+	name, err := auto.detect(func(method MP3Steganography) error {
+		var err error
+		payload, err = method.ExtractPayloadFromMP3(mp3Data)
+		return err
+	})
+	if err != nil && !errors.Is(err, ErrNoPayload) && !errors.Is(err, ErrKeyMismatch) {
+		// The marker and key matched, so only the payload failed to parse;
+		// the preamble is placement-independent, try the other placement
+		if method := auto.alternates[auto.index(name)]; method != nil {
+			if retried, retryErr := method.ExtractPayloadFromMP3(mp3Data); retryErr == nil {
+				payload, err, alternate = retried, nil, true
+			}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	useRandomStart := auto.config.UseRandomStart
+	if alternate {
+		useRandomStart = !useRandomStart
+	}
+	payload.Method = name
+	payload.Placement = placement(useRandomStart, auto.config.Density)
+	return payload, nil
+}
+
+// index returns the position of a method name in autoMethods
+func (auto *autoSteganography) index(name string) int {
+	for i, candidate := range auto.names {
+		if candidate == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (auto *autoSteganography) CheckKeyInMP3(mp3Data []byte) error {
+	_, err := auto.detect(func(method MP3Steganography) error {
+		return method.CheckKeyInMP3(mp3Data)
+	})
+	return err
+}
+
+func (auto *autoSteganography) UpdateHeaderInMP3(mp3Data []byte, update HeaderUpdate) ([]byte, error) {
+	var updated []byte
+	_, err := auto.detect(func(method MP3Steganography) error {
+		var err error
+		updated, err = method.UpdateHeaderInMP3(mp3Data, update)
+		return err
+	})
+	return updated, err
+}
@@ -0,0 +1,117 @@
+package stego
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"steganography-backend/models"
+)
+
+func TestAutoDetection(t *testing.T) {
+	cover := loadCover(t, 200)
+	secret := []byte("which method was it?")
+
+	tests := []struct {
+		name          string
+		embed         models.StegoConfig
+		extractRandom bool // use_random_start sent with the auto extraction
+		wantMethod    string
+		wantPlacement string
+	}{
+		{
+			name:          "ancillary sequential",
+			embed:         models.StegoConfig{Method: models.MethodAncillary},
+			wantMethod:    models.MethodAncillary,
+			wantPlacement: models.PlacementSequential,
+		},
+		{
+			name:          "ancillary random",
+			embed:         models.StegoConfig{Method: models.MethodAncillary, UseRandomStart: true},
+			extractRandom: true,
+			wantMethod:    models.MethodAncillary,
+			wantPlacement: models.PlacementRandom,
+		},
+		{
+			name:          "frame LSB sequential",
+			embed:         models.StegoConfig{Method: models.MethodFrameLSB},
+			wantMethod:    models.MethodFrameLSB,
+			wantPlacement: models.PlacementSequential,
+		},
+		{
+			name:          "frame LSB random, placement not given",
+			embed:         models.StegoConfig{Method: models.MethodFrameLSB, UseRandomStart: true},
+			wantMethod:    models.MethodFrameLSB,
+			wantPlacement: models.PlacementRandom,
+		},
+		{
+			name:          "ancillary sequential, random requested",
+			embed:         models.StegoConfig{Method: models.MethodAncillary},
+			extractRandom: true,
+			wantMethod:    models.MethodAncillary,
+			wantPlacement: models.PlacementSequential,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := tt.embed
+			config.Key, config.LSBBits = "auto", 2
+			method, err := NewMP3Steganography(&config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			stegoData, err := method.EmbedInMP3(cover, secret)
+			if err != nil {
+				t.Fatalf("embed: %v", err)
+			}
+
+			auto, err := NewMP3Steganography(&models.StegoConfig{Key: "auto", LSBBits: 2, Method: models.MethodAuto, UseRandomStart: tt.extractRandom})
+			if err != nil {
+				t.Fatal(err)
+			}
+			payload, err := auto.ExtractPayloadFromMP3(stegoData)
+			if err != nil {
+				t.Fatalf("extract: %v", err)
+			}
+			if !bytes.Equal(payload.Data, secret) {
+				t.Errorf("extracted %q, want %q", payload.Data, secret)
+			}
+			if payload.Method != tt.wantMethod || payload.Placement != tt.wantPlacement {
+				t.Errorf("detected %s/%s, want %s/%s", payload.Method, payload.Placement, tt.wantMethod, tt.wantPlacement)
+			}
+		})
+	}
+}
+
+func TestAutoDetectionWithoutPayload(t *testing.T) {
+	cover := loadCover(t, 200)
+	stegoData, err := NewMP3LSBSteganography(&models.StegoConfig{Key: "auto", LSBBits: 2}).EmbedInMP3(cover, []byte("x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		audio []byte
+		key   string
+	}{
+		{name: "plain cover", audio: cover, key: "auto"},
+		{name: "wrong key", audio: stegoData, key: "other"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auto, err := NewMP3Steganography(&models.StegoConfig{Key: tt.key, LSBBits: 2, Method: models.MethodAuto})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := auto.ExtractPayloadFromMP3(tt.audio); !errors.Is(err, ErrNoPayload) {
+				t.Errorf("extract: err = %v, want ErrNoPayload", err)
+			}
+			if err := auto.CheckKeyInMP3(tt.audio); !errors.Is(err, ErrNoPayload) {
+				t.Errorf("check key: err = %v, want ErrNoPayload", err)
+			}
+		})
+	}
+}
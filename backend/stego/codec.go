@@ -0,0 +1,510 @@
+package stego
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"steganography-backend/models"
+)
+
+// lsbCodec holds the LSB machinery shared by the MP3 methods: every method
+// collects a flat sequence of modifiable bytes from the frames and hands it to
+// the codec, which lays out the masked preamble followed by the payload.
+// The codec holds no mutable state, so one instance may embed and extract
+// from several goroutines at once.
+type lsbCodec struct {
+	config *models.StegoConfig
+
+	// legacyPermutation keeps the rejection-sampled random-start permutation
+	// of the original ancillary layout; other methods shuffle with Fisher-Yates
+	legacyPermutation bool
+}
+
+func newLSBCodec(config *models.StegoConfig) *lsbCodec {
+	return &lsbCodec{
+		config: config,
+	}
+}
+
+// generateSeed derives the position permutation seed from the key, mixed with
+// the optional per-file salt so the same key yields different permutations
+// across files. An empty salt reproduces the unsalted seed. SHA-256 is the
+// default; MD5 is kept for files embedded with the legacy seed.
+func generateSeed(key, salt, seedHash string) int64 {
+	if seedHash == models.SeedHashMD5 {
+		hash := md5.Sum([]byte(key + salt))
+		return int64(binary.BigEndian.Uint64(hash[:8]))
+	}
+
+	hash := sha256.Sum256([]byte(key + salt))
+	return int64(binary.BigEndian.Uint64(hash[:8]))
+}
+
+// maxDomainGrowth bounds how much larger than the safe bytes found at
+// extraction the stored permutation domain may be, so a corrupt preamble cannot
+// trigger a huge permutation
+const maxDomainGrowth = 2
+
+// MaxDensity bounds the embedding density (use every Nth safe byte)
+const MaxDensity = 64
+
+// payloadCapacity returns how many payload bytes fit into totalSafeBytes once
+// the preamble and the metadata are accounted for
+func (lsb *lsbCodec) payloadCapacity(totalSafeBytes int) (int, error) {
+	// The preamble occupies the first safe bytes
+	preambleSafeBytes := lsb.safeBytesNeeded(len(lsb.configPreamble().encode()))
+	if totalSafeBytes <= preambleSafeBytes {
+		return 0, fmt.Errorf("insufficient safe bytes for preamble")
+	}
+
+	// Only every Density-th safe byte after the preamble carries payload bits
+	bitsPerByte := lsb.config.LSBBits
+	totalBits := lsb.strideDomain(totalSafeBytes-preambleSafeBytes) * bitsPerByte
+	capacity := totalBits / 8
+
+	// Reserve space for the fixed header (filename, extensions and data lengths)
+	if capacity < payloadFixedHeaderBytes {
+		return 0, fmt.Errorf("insufficient safe bytes for metadata")
+	}
+
+	return capacity - payloadFixedHeaderBytes, nil
+}
+
+// embedPayload writes the preamble and the framed secret into safeBytes.
+// id3Checksum is stored with the secret when not nil.
+func (lsb *lsbCodec) embedPayload(safeBytes []byte, secretData []byte, id3Checksum []byte) error {
+	if err := ValidateSalt(lsb.config.Salt); err != nil {
+		return err
+	}
+	if err := ValidateSeedHash(lsb.config.SeedHash); err != nil {
+		return err
+	}
+	if err := ValidateMarker(lsb.marker()); err != nil {
+		return err
+	}
+	if err := ValidateMaxFilenameBytes(lsb.config.MaxFilenameBytes); err != nil {
+		return err
+	}
+	if len(lsb.config.SecretFilename) > lsb.filenameLimit() {
+		return fmt.Errorf("secret filename is %d bytes, the limit is %d", len(lsb.config.SecretFilename), lsb.filenameLimit())
+	}
+	if len(lsb.config.Metadata) > 0 {
+		if err := ValidateMetadata(lsb.config.Metadata); err != nil {
+			return err
+		}
+	}
+	if lsb.config.FileID != "" {
+		if err := ValidateFileID(lsb.config.FileID); err != nil {
+			return err
+		}
+	}
+	if lsb.config.Part != nil {
+		if err := ValidatePart(lsb.config.Part); err != nil {
+			return err
+		}
+	}
+	if err := ValidateDensity(lsb.config.Density); err != nil {
+		return err
+	}
+	if err := ValidateBitOrder(lsb.config.BitOrder); err != nil {
+		return err
+	}
+
+	payload := lsb.buildPayload(secretData, id3Checksum)
+
+	capacity, err := lsb.payloadCapacity(len(safeBytes))
+	if err != nil {
+		return err
+	}
+	// The capacity already accounts for the fixed header fields
+	if len(payload)-payloadFixedHeaderBytes > capacity {
+		return fmt.Errorf("secret data too large: %d bytes, capacity: %d bytes", len(payload)-payloadFixedHeaderBytes, capacity)
+	}
+
+	// Calculate how many bytes we need based on LSB bits per byte
+	header := lsb.configPreamble()
+	preambleSafeBytes := lsb.safeBytesNeeded(len(header.encode()))
+	bytesNeeded := lsb.safeBytesNeeded(len(payload))
+
+	if preambleSafeBytes+bytesNeeded > len(safeBytes) {
+		return fmt.Errorf("insufficient safe bytes: need %d, have %d", preambleSafeBytes+bytesNeeded, len(safeBytes))
+	}
+
+	keys, err := lsb.deriveKeys()
+	if err != nil {
+		return err
+	}
+	header.KeyCheck = keys.keyCheck(header.Salt)
+
+	// Pin the permutation domain in the preamble so extraction does not
+	// depend on recounting the safe bytes
+	header.Domain = len(safeBytes) - preambleSafeBytes
+	preamble := keys.whiten(header.encode())
+
+	// The preamble is written sequentially so extraction can read the salt and
+	// seed hash before knowing the permutation; the payload follows in the
+	// remaining safe bytes
+	lsb.embedBits(safeBytes, sequentialPositions(0, preambleSafeBytes), preamble)
+
+	seed := generateSeed(lsb.config.Key, header.Salt, header.SeedHash)
+	positions, err := lsb.payloadPositions(seed, lsb.strideDomain(header.Domain), bytesNeeded)
+	if err != nil {
+		return err
+	}
+	positions = lsb.stridePositions(positions, seed)
+	lsb.embedBits(safeBytes, offsetPositions(positions, preambleSafeBytes), payload)
+
+	return nil
+}
+
+// extractPayload reads the preamble and the framed secret back from safeBytes
+func (lsb *lsbCodec) extractPayload(safeBytes []byte) (*Payload, error) {
+	header, positions, available, err := lsb.storedPositions(safeBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	// Extract all available bits using the complete position sequence
+	extractedBytes := lsb.extractBits(safeBytes, positions)
+
+	payload, err := lsb.parsePayload(extractedBytes)
+	if err != nil {
+		return nil, err
+	}
+	// A soft control: the data is still in the file for anyone ignoring the expiry
+	if !payload.Expires.IsZero() && !lsb.config.IgnoreExpiry && time.Now().After(payload.Expires) {
+		return nil, &ExpiredPayloadError{Expires: payload.Expires}
+	}
+	payload.SeedHash, payload.Salt = header.SeedHash, header.Salt
+	if available != header.Domain {
+		payload.Warnings = append(payload.Warnings, fmt.Sprintf(
+			"the file has %d carrier bytes but had %d when embedded; it was modified after embedding", available, header.Domain))
+	}
+
+	return payload, nil
+}
+
+// rewritePayloadHeader replaces the stored filename and metadata of an
+// embedded payload, keeping the secret data, and writes the reframed payload
+// back to the positions it was read from. The preamble is left untouched.
+func (lsb *lsbCodec) rewritePayloadHeader(safeBytes []byte, update HeaderUpdate) error {
+	if update.Filename != nil && len(*update.Filename) > lsb.filenameLimit() {
+		return fmt.Errorf("filename cannot exceed %d bytes", lsb.filenameLimit())
+	}
+	if update.Metadata != nil {
+		if err := ValidateMetadata(update.Metadata); err != nil {
+			return err
+		}
+	}
+
+	_, positions, _, err := lsb.storedPositions(safeBytes)
+	if err != nil {
+		return err
+	}
+
+	payload, err := lsb.parsePayload(lsb.extractBits(safeBytes, positions))
+	if err != nil {
+		return err
+	}
+	if update.Filename != nil {
+		payload.Filename = *update.Filename
+	}
+	if update.Metadata != nil {
+		payload.Metadata = update.Metadata
+	}
+
+	framed := lsb.encodePayload(payload)
+	bytesNeeded := lsb.safeBytesNeeded(len(framed))
+	if bytesNeeded > len(positions) {
+		return fmt.Errorf("updated payload too large: need %d safe bytes, have %d", bytesNeeded, len(positions))
+	}
+	lsb.embedBits(safeBytes, positions[:bytesNeeded], framed)
+
+	return nil
+}
+
+// storedPositions reads the preamble and returns it with the payload
+// positions in safeBytes, already offset past the preamble, and the number of
+// safe bytes available for the payload now
+func (lsb *lsbCodec) storedPositions(safeBytes []byte) (preamble, []int, int, error) {
+	header, preambleSafeBytes, err := lsb.readPreamble(safeBytes)
+	if err != nil {
+		return preamble{}, nil, 0, err
+	}
+
+	// Generate positions for the whole stored domain to get the complete
+	// permutation, then stop at the first position past the safe bytes found
+	// now; the payload is intact as long as it was written before that point
+	if err := ValidateDensity(lsb.config.Density); err != nil {
+		return preamble{}, nil, 0, err
+	}
+
+	seed := generateSeed(lsb.config.Key, header.Salt, header.SeedHash)
+	domain := lsb.strideDomain(header.Domain)
+	available := len(safeBytes) - preambleSafeBytes
+	positionsNeeded := domain
+	switch {
+	case lsb.config.Positions != nil:
+		positionsNeeded = len(lsb.config.Positions)
+	case !lsb.config.UseRandomStart:
+		// Sequential positions do not depend on the domain
+		positionsNeeded = min(domain, lsb.strideDomain(available)+1)
+	case header.Domain <= 0 || header.Domain > available*maxDomainGrowth:
+		return preamble{}, nil, 0, fmt.Errorf("invalid permutation domain %d for %d safe bytes", header.Domain, available)
+	}
+	positions, err := lsb.payloadPositions(seed, domain, positionsNeeded)
+	if err != nil {
+		return preamble{}, nil, 0, err
+	}
+	positions = positionsWithin(lsb.stridePositions(positions, seed), available)
+	if len(positions) == 0 {
+		return preamble{}, nil, 0, fmt.Errorf("no positions generated for extraction")
+	}
+
+	return header, offsetPositions(positions, preambleSafeBytes), available, nil
+}
+
+// payloadPositions returns the payload positions within the domain, using the
+// explicit positions from the config when present instead of generating them
+func (lsb *lsbCodec) payloadPositions(seed int64, domain, bytesNeeded int) ([]int, error) {
+	if lsb.config.Positions == nil {
+		return lsb.generatePositions(seed, domain, bytesNeeded), nil
+	}
+
+	if err := ValidatePositions(lsb.config.Positions, domain); err != nil {
+		return nil, err
+	}
+	if len(lsb.config.Positions) < bytesNeeded {
+		return nil, fmt.Errorf("not enough explicit positions: need %d, have %d", bytesNeeded, len(lsb.config.Positions))
+	}
+
+	return lsb.config.Positions[:bytesNeeded], nil
+}
+
+// density returns the configured embedding density, 1 meaning every safe byte
+func (lsb *lsbCodec) density() int {
+	if lsb.config.Density < 1 {
+		return 1
+	}
+	return lsb.config.Density
+}
+
+// strideDomain returns how many payload positions a domain of safe bytes
+// offers at the configured density
+func (lsb *lsbCodec) strideDomain(domain int) int {
+	return domain / lsb.density()
+}
+
+// stridePositions maps positions in the strided domain onto safe bytes: with
+// density N, position p lands on safe byte p*N + phase, where the phase in
+// [0, N) is derived from the seed so the used bytes depend on the key
+func (lsb *lsbCodec) stridePositions(positions []int, seed int64) []int {
+	density := lsb.density()
+	if density == 1 {
+		return positions
+	}
+
+	phase := int(uint64(seed) % uint64(density))
+	strided := make([]int, len(positions))
+	for i, pos := range positions {
+		strided[i] = pos*density + phase
+	}
+	return strided
+}
+
+// ValidateDensity checks the embedding density, 0 meaning the default of 1
+func ValidateDensity(density int) error {
+	if density < 0 || density > MaxDensity {
+		return fmt.Errorf("density must be between 1 and %d", MaxDensity)
+	}
+	return nil
+}
+
+// ValidatePositions checks that explicit positions are unique and within [0, domain)
+func ValidatePositions(positions []int, domain int) error {
+	seen := make(map[int]bool, len(positions))
+	for _, pos := range positions {
+		if pos < 0 || pos >= domain {
+			return fmt.Errorf("position %d out of range [0, %d)", pos, domain)
+		}
+		if seen[pos] {
+			return fmt.Errorf("duplicate position %d", pos)
+		}
+		seen[pos] = true
+	}
+	return nil
+}
+
+func (lsb *lsbCodec) generatePositions(seed int64, dataLen, bytesNeeded int) []int {
+	positions := make([]int, 0)
+
+	if lsb.config.UseRandomStart {
+		// A generator per call keeps concurrent callers from sharing state
+		rng := rand.New(rand.NewSource(seed))
+		if !lsb.legacyPermutation {
+			return shuffledPositions(rng, dataLen, bytesNeeded)
+		}
+
+		// Generate a FIXED permutation of all available positions
+		used := make(map[int]bool)
+		allPositions := make([]int, 0, dataLen)
+
+		// Generate the complete random permutation of all available positions
+		for len(allPositions) < dataLen {
+			pos := rng.Intn(dataLen)
+			if !used[pos] {
+				allPositions = append(allPositions, pos)
+				used[pos] = true
+			}
+		}
+
+		positions = allPositions[:bytesNeeded]
+	} else {
+		for i := 0; i < bytesNeeded && i < dataLen; i++ {
+			positions = append(positions, i)
+		}
+	}
+
+	return positions
+}
+
+// shuffledPositions returns the first count positions of a Fisher-Yates
+// shuffle of [0, domain). The shuffle runs front to back and stops after
+// count swaps, so the prefix does not depend on count and costs O(count)
+// random draws however large the domain is.
+func shuffledPositions(rng *rand.Rand, domain, count int) []int {
+	count = min(count, domain)
+	positions := sequentialPositions(0, domain)
+	for i := 0; i < count; i++ {
+		j := i + rng.Intn(domain-i)
+		positions[i], positions[j] = positions[j], positions[i]
+	}
+	return positions[:count]
+}
+
+// safeBytesNeeded returns how many safe bytes hold n payload bytes at the configured LSB depth
+func (lsb *lsbCodec) safeBytesNeeded(n int) int {
+	totalBits := n * 8
+	bytesNeeded := totalBits / lsb.config.LSBBits
+	if totalBits%lsb.config.LSBBits != 0 {
+		bytesNeeded++
+	}
+	return bytesNeeded
+}
+
+// embedBits writes data into the LSBs of safeBytes, LSBBits per position.
+// When len(data)*8 is not a multiple of LSBBits the final group is only
+// partially filled; its unused bits are written as zeros, so the result does
+// not depend on the cover and extractBits drops them as an incomplete byte.
+func (lsb *lsbCodec) embedBits(safeBytes []byte, positions []int, data []byte) {
+	dataBits := bytesToBits(data)
+	mask := byte((1 << lsb.config.LSBBits) - 1)
+	bitIndex := 0
+
+	for _, pos := range positions {
+		if bitIndex >= len(dataBits) {
+			break
+		}
+
+		// Pack multiple bits into LSB positions; bits past the end of the
+		// data stay zero and pad the final group
+		var bitsToEmbed byte = 0
+		for j := 0; j < lsb.config.LSBBits && bitIndex < len(dataBits); j++ {
+			bitsToEmbed |= (dataBits[bitIndex] << lsb.bitShift(j))
+			bitIndex++
+		}
+
+		safeBytes[pos] = (safeBytes[pos] & ^mask) | (bitsToEmbed & mask)
+	}
+}
+
+// extractBits reads the LSBs of safeBytes at positions back into whole bytes.
+// Trailing bits that do not complete a byte are padding and are discarded.
+func (lsb *lsbCodec) extractBits(safeBytes []byte, positions []int) []byte {
+	extractedBits := make([]byte, 0, len(positions)*lsb.config.LSBBits)
+	mask := byte((1 << lsb.config.LSBBits) - 1)
+
+	for _, pos := range positions {
+		lsbValue := safeBytes[pos] & mask
+		// Unpack bits from this LSB value
+		for j := 0; j < lsb.config.LSBBits; j++ {
+			extractedBits = append(extractedBits, (lsbValue>>lsb.bitShift(j))&1)
+		}
+	}
+
+	return bitsToBytes(extractedBits)
+}
+
+// bitShift returns the bit within the LSB mask that holds the j-th data bit
+// of a group: low-to-high by default, high-to-low when configured
+func (lsb *lsbCodec) bitShift(j int) int {
+	if lsb.config.BitOrder == models.BitOrderHighToLow {
+		return lsb.config.LSBBits - 1 - j
+	}
+	return j
+}
+
+// ValidateBitOrder validates the bit packing order
+func ValidateBitOrder(bitOrder string) error {
+	switch bitOrder {
+	case "", models.BitOrderLowToHigh, models.BitOrderHighToLow:
+		return nil
+	default:
+		return fmt.Errorf("unknown bit order %q, expected %q or %q", bitOrder, models.BitOrderLowToHigh, models.BitOrderHighToLow)
+	}
+}
+
+func sequentialPositions(start, count int) []int {
+	positions := make([]int, count)
+	for i := range positions {
+		positions[i] = start + i
+	}
+	return positions
+}
+
+// positionsWithin returns the leading positions that are below limit
+func positionsWithin(positions []int, limit int) []int {
+	for i, pos := range positions {
+		if pos >= limit {
+			return positions[:i]
+		}
+	}
+	return positions
+}
+
+func offsetPositions(positions []int, offset int) []int {
+	shifted := make([]int, len(positions))
+	for i, pos := range positions {
+		shifted[i] = pos + offset
+	}
+	return shifted
+}
+
+func bytesToBits(data []byte) []byte {
+	bits := make([]byte, 0)
+	for _, b := range data {
+		for i := 7; i >= 0; i-- {
+			bits = append(bits, (b>>i)&1)
+		}
+	}
+	return bits
+}
+
+func bitsToBytes(bits []byte) []byte {
+	bytes := make([]byte, 0)
+	for i := 0; i < len(bits); i += 8 {
+		if i+8 > len(bits) {
+			break
+		}
+		var b byte
+		for j := range 8 {
+			b = (b << 1) | (bits[i+j] & 1)
+		}
+		bytes = append(bytes, b)
+	}
+	return bytes
+}
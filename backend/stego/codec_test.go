@@ -0,0 +1,338 @@
+package stego
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"testing"
+
+	"steganography-backend/models"
+)
+
+func TestSaltedPositions(t *testing.T) {
+	cover := loadCover(t, 200)
+	secret := []byte("same key, different salt")
+
+	tests := []struct {
+		name         string
+		saltA, saltB string
+		wantSame     bool
+	}{
+		{name: "different salts", saltA: "file-a", saltB: "file-b", wantSame: false},
+		{name: "salt against none", saltA: "file-a", saltB: "", wantSame: false},
+		{name: "same salt", saltA: "file-a", saltB: "file-a", wantSame: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			positions := make([][]int, 0, 2)
+			for _, salt := range []string{tt.saltA, tt.saltB} {
+				config := models.StegoConfig{Key: "shared", LSBBits: 2, UseRandomStart: true, Salt: salt}
+				codec := newLSBCodec(&config)
+				positions = append(positions, codec.generatePositions(generateSeed(config.Key, salt, ""), 5000, 200))
+
+				stegoData, err := NewMP3AncillaryLSBSteganography(&config).EmbedInMP3(cover, secret)
+				if err != nil {
+					t.Fatalf("embed with salt %q: %v", salt, err)
+				}
+				// Extraction reads the salt from the file, the request does not repeat it
+				extract := models.StegoConfig{Key: "shared", LSBBits: 2, UseRandomStart: true}
+				payload, err := NewMP3AncillaryLSBSteganography(&extract).ExtractPayloadFromMP3(stegoData)
+				if err != nil {
+					t.Fatalf("extract with salt %q: %v", salt, err)
+				}
+				if !bytes.Equal(payload.Data, secret) || payload.Salt != salt {
+					t.Errorf("extracted %q with salt %q", payload.Data, payload.Salt)
+				}
+			}
+
+			same := slices.Equal(positions[0], positions[1])
+			if same != tt.wantSame {
+				t.Errorf("positions equal = %v, want %v", same, tt.wantSame)
+			}
+		})
+	}
+}
+
+// stepPositions returns count positions from start, step apart
+func stepPositions(start, step, count int) []int {
+	positions := make([]int, count)
+	for i := range positions {
+		positions[i] = start + i*step
+	}
+	return positions
+}
+
+func TestExplicitPositions(t *testing.T) {
+	cover := loadCover(t, 200)
+	secret := []byte("placed by hand")
+
+	tests := []struct {
+		name       string
+		embed      []int
+		extract    []int
+		wantEmbed  bool
+		wantSecret bool
+	}{
+		{name: "descending", embed: stepPositions(3000, -7, 200), extract: stepPositions(3000, -7, 200), wantEmbed: true, wantSecret: true},
+		{name: "scattered", embed: stepPositions(5, 13, 200), extract: stepPositions(5, 13, 200), wantEmbed: true, wantSecret: true},
+		{name: "different list on extraction", embed: stepPositions(5, 13, 200), extract: stepPositions(6, 13, 200), wantEmbed: true},
+		{name: "duplicate", embed: append(stepPositions(0, 1, 199), 0)},
+		{name: "out of range", embed: append(stepPositions(0, 1, 199), 1<<20)},
+		{name: "negative", embed: append(stepPositions(0, 1, 199), -1)},
+		{name: "too few", embed: stepPositions(0, 1, 10)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := models.StegoConfig{Key: "positions", LSBBits: 2, Positions: tt.embed}
+			stegoData, err := NewMP3AncillaryLSBSteganography(&config).EmbedInMP3(cover, secret)
+			if (err == nil) != tt.wantEmbed {
+				t.Fatalf("embed error = %v, want success %v", err, tt.wantEmbed)
+			}
+			if err != nil {
+				return
+			}
+
+			extract := models.StegoConfig{Key: "positions", LSBBits: 2, Positions: tt.extract}
+			payload, err := NewMP3AncillaryLSBSteganography(&extract).ExtractPayloadFromMP3(stegoData)
+			got := err == nil && bytes.Equal(payload.Data, secret)
+			if got != tt.wantSecret {
+				t.Errorf("secret recovered = %v (err %v), want %v", got, err, tt.wantSecret)
+			}
+		})
+	}
+}
+
+func TestSeedHashAlgorithms(t *testing.T) {
+	cover := loadCover(t, 200)
+	secret := []byte("hashed seed")
+
+	tests := []struct {
+		name     string
+		seedHash string
+		want     string
+		wantErr  bool
+	}{
+		{name: "default", seedHash: "", want: models.SeedHashSHA256},
+		{name: "sha256", seedHash: models.SeedHashSHA256, want: models.SeedHashSHA256},
+		{name: "md5", seedHash: models.SeedHashMD5, want: models.SeedHashMD5},
+		{name: "unknown", seedHash: "sha1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := models.StegoConfig{Key: "seed", LSBBits: 1, UseRandomStart: true, Salt: "s", SeedHash: tt.seedHash}
+			stegoData, err := NewMP3AncillaryLSBSteganography(&config).EmbedInMP3(cover, secret)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("embed: %v", err)
+			}
+
+			// The algorithm is read from the preamble, not the request
+			extract := models.StegoConfig{Key: "seed", LSBBits: 1, UseRandomStart: true}
+			payload, err := NewMP3AncillaryLSBSteganography(&extract).ExtractPayloadFromMP3(stegoData)
+			if err != nil {
+				t.Fatalf("extract: %v", err)
+			}
+			if !bytes.Equal(payload.Data, secret) || payload.SeedHash != tt.want {
+				t.Errorf("extracted %q with seed hash %q, want %q", payload.Data, payload.SeedHash, tt.want)
+			}
+		})
+	}
+
+	if generateSeed("seed", "s", models.SeedHashMD5) == generateSeed("seed", "s", models.SeedHashSHA256) {
+		t.Error("md5 and sha256 derive the same seed")
+	}
+}
+
+func TestDensity(t *testing.T) {
+	secret := bytes.Repeat([]byte("sparse "), 20)
+
+	tests := []struct {
+		name           string
+		density        int
+		useRandomStart bool
+		wantErr        bool
+	}{
+		{name: "default", density: 0},
+		{name: "every byte", density: 1},
+		{name: "every third byte", density: 3},
+		{name: "every third byte, random start", density: 3, useRandomStart: true},
+		{name: "maximum", density: MaxDensity},
+		{name: "too sparse", density: MaxDensity + 1, wantErr: true},
+		{name: "negative", density: -1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := models.StegoConfig{Key: "density", LSBBits: 2, Density: tt.density, UseRandomStart: tt.useRandomStart}
+			codec := newLSBCodec(&config)
+			cover := randomCarriers(40000)
+			carriers := bytes.Clone(cover)
+			err := codec.embedPayload(carriers, secret, nil)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("embed: %v", err)
+			}
+
+			// Only one carrier in every density after the preamble may change
+			preambleSafeBytes := codec.safeBytesNeeded(len(codec.configPreamble().encode()))
+			density := codec.density()
+			used := map[int]bool{}
+			untouched, payloadCarriers := 0, len(cover)-preambleSafeBytes
+			for i := preambleSafeBytes; i < len(cover); i++ {
+				if carriers[i] == cover[i] {
+					untouched++
+					continue
+				}
+				used[(i-preambleSafeBytes)%density] = true
+			}
+			if len(used) > 1 {
+				t.Errorf("modified carriers fall in %d residues modulo %d, want 1", len(used), density)
+			}
+			if wantUntouched := payloadCarriers - payloadCarriers/density; untouched < wantUntouched {
+				t.Errorf("%d of %d carriers untouched, want at least %d", untouched, payloadCarriers, wantUntouched)
+			}
+
+			payload, err := codec.extractPayload(carriers)
+			if err != nil {
+				t.Fatalf("extract: %v", err)
+			}
+			if !bytes.Equal(payload.Data, secret) {
+				t.Error("extracted data differs from the secret")
+			}
+		})
+	}
+}
+
+func TestBitOrder(t *testing.T) {
+	tests := []struct {
+		name     string
+		lsbBits  int
+		bitOrder string
+		data     []byte
+		want     []byte // Carrier LSBs after embedding into zeroed carriers
+	}{
+		{name: "1 bit", lsbBits: 1, bitOrder: models.BitOrderHighToLow, data: []byte{0xB0}, want: []byte{1, 0, 1, 1, 0, 0, 0, 0}},
+		{name: "2 bits low to high", lsbBits: 2, bitOrder: models.BitOrderLowToHigh, data: []byte{0xB0}, want: []byte{0b01, 0b11, 0b00, 0b00}},
+		{name: "2 bits high to low", lsbBits: 2, bitOrder: models.BitOrderHighToLow, data: []byte{0xB0}, want: []byte{0b10, 0b11, 0b00, 0b00}},
+		{name: "2 bits default", lsbBits: 2, bitOrder: "", data: []byte{0xB0}, want: []byte{0b01, 0b11, 0b00, 0b00}},
+		{name: "4 bits high to low", lsbBits: 4, bitOrder: models.BitOrderHighToLow, data: []byte{0xB4}, want: []byte{0xB, 0x4}},
+		{name: "4 bits low to high", lsbBits: 4, bitOrder: models.BitOrderLowToHigh, data: []byte{0xB4}, want: []byte{0xD, 0x2}},
+		{name: "3 bits high to low, padded group", lsbBits: 3, bitOrder: models.BitOrderHighToLow, data: []byte{0xFF}, want: []byte{0b111, 0b111, 0b110}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := models.StegoConfig{LSBBits: tt.lsbBits, BitOrder: tt.bitOrder}
+			codec := newLSBCodec(&config)
+			carriers := make([]byte, len(tt.want))
+			positions := sequentialPositions(0, len(carriers))
+
+			codec.embedBits(carriers, positions, tt.data)
+			if !bytes.Equal(carriers, tt.want) {
+				t.Errorf("carriers = %03b, want %03b", carriers, tt.want)
+			}
+			if got := codec.extractBits(carriers, positions); !bytes.Equal(got, tt.data) {
+				t.Errorf("extracted %x, want %x", got, tt.data)
+			}
+		})
+	}
+}
+
+func TestBitOrderRoundTrip(t *testing.T) {
+	cover := loadCover(t, 200)
+	secret := []byte("packed either way")
+
+	tests := []struct {
+		name           string
+		embedOrder     string
+		extractOrder   string
+		wantSecret     bool
+		wantEmbedError bool
+	}{
+		{name: "low to high", embedOrder: models.BitOrderLowToHigh, extractOrder: models.BitOrderLowToHigh, wantSecret: true},
+		{name: "high to low", embedOrder: models.BitOrderHighToLow, extractOrder: models.BitOrderHighToLow, wantSecret: true},
+		{name: "default reads low to high", embedOrder: "", extractOrder: models.BitOrderLowToHigh, wantSecret: true},
+		{name: "mismatched order", embedOrder: models.BitOrderHighToLow, extractOrder: models.BitOrderLowToHigh},
+		{name: "unknown order", embedOrder: "middle_out", wantEmbedError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := models.StegoConfig{Key: "order", LSBBits: 3, BitOrder: tt.embedOrder}
+			stegoData, err := NewMP3AncillaryLSBSteganography(&config).EmbedInMP3(cover, secret)
+			if (err != nil) != tt.wantEmbedError {
+				t.Fatalf("embed: err = %v, want error %v", err, tt.wantEmbedError)
+			}
+			if err != nil {
+				return
+			}
+
+			extract := models.StegoConfig{Key: "order", LSBBits: 3, BitOrder: tt.extractOrder}
+			payload, err := NewMP3AncillaryLSBSteganography(&extract).ExtractPayloadFromMP3(stegoData)
+			got := err == nil && bytes.Equal(payload.Data, secret)
+			if got != tt.wantSecret {
+				t.Errorf("secret recovered = %v (err %v), want %v", got, err, tt.wantSecret)
+			}
+		})
+	}
+}
+
+func TestPartialFinalGroup(t *testing.T) {
+	tests := []struct {
+		lsbBits     int
+		dataLen     int
+		wantPartial bool // 8*dataLen is not a multiple of lsbBits
+	}{
+		{lsbBits: 1, dataLen: 5},
+		{lsbBits: 2, dataLen: 5},
+		{lsbBits: 4, dataLen: 5},
+		{lsbBits: 3, dataLen: 3},
+		{lsbBits: 3, dataLen: 1, wantPartial: true},
+		{lsbBits: 3, dataLen: 5, wantPartial: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d bits, %d bytes", tt.lsbBits, tt.dataLen), func(t *testing.T) {
+			config := models.StegoConfig{LSBBits: tt.lsbBits}
+			codec := newLSBCodec(&config)
+			data := bytes.Repeat([]byte{0xFF}, tt.dataLen)
+			needed := codec.safeBytesNeeded(tt.dataLen)
+			if partial := needed*tt.lsbBits != tt.dataLen*8; partial != tt.wantPartial {
+				t.Fatalf("%d carriers for %d bits, partial group %v, want %v", needed, tt.dataLen*8, partial, tt.wantPartial)
+			}
+
+			// Every carrier bit starts set, so zeros can only be padding
+			carriers := bytes.Repeat([]byte{0xFF}, needed+2)
+			positions := sequentialPositions(0, len(carriers))
+			codec.embedBits(carriers, positions, data)
+
+			mask := byte(1<<tt.lsbBits - 1)
+			unused := needed*tt.lsbBits - tt.dataLen*8
+			wantLast := mask >> unused
+			if last := carriers[needed-1] & mask; last != wantLast {
+				t.Errorf("final group = %04b, want %04b with %d zero padding bits", last, wantLast, unused)
+			}
+			if !bytes.Equal(carriers[needed:], []byte{0xFF, 0xFF}) {
+				t.Error("carriers past the data were modified")
+			}
+
+			// The padding bits do not complete a byte and are dropped
+			if got := codec.extractBits(carriers, positions[:needed]); !bytes.Equal(got, data) {
+				t.Errorf("extracted %x, want %x", got, data)
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package stego
+
+import (
+	"crypto/sha256"
+	"fmt"
+
+	"steganography-backend/models"
+	"steganography-backend/mp3parser"
+)
+
+// AnalyzeCapacity compares the safe capacity of the ancillary method with the
+// raw capacity of the stream. The raw figure treats every byte of every audio
+// frame (side info and main data included) as a carrier and only skips the
+// Xing/Info frame, so it is an upper bound that no safe method reaches. The
+// config supplies the LSB depth and the header fields (salt, filename, ...)
+// that determine the overhead.
+func AnalyzeCapacity(mp3Data []byte, config *models.StegoConfig) (*models.CapacityDiagnostics, error) {
+	lsbBits := config.LSBBits
+	if lsbBits < 1 || lsbBits > 4 {
+		return nil, fmt.Errorf("LSB bits must be between 1 and 4")
+	}
+
+	mp3File, err := mp3parser.ParseMP3File(mp3Data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse MP3: %w", err)
+	}
+
+	safeBytes, _ := collectSafeBytes(mp3File, nil)
+
+	rawBytes := 0
+	for _, frame := range mp3File.Frames {
+		if !frame.IsInfo {
+			rawBytes += len(frame.Data)
+		}
+	}
+
+	diagnostics := &models.CapacityDiagnostics{
+		LSBBits:        lsbBits,
+		Frames:         len(mp3File.Frames),
+		Unanalyzable:   mp3parser.CountUnanalyzableFrames(mp3File),
+		SkippedBytes:   mp3File.SkippedBytes,
+		SkippedRegions: mp3File.SkippedRegions,
+		SafeCarriers:   len(safeBytes),
+		RawCarriers:    rawBytes,
+		SafeBits:       len(safeBytes) * lsbBits,
+		RawBits:        rawBytes * lsbBits,
+		SafeBytes:      len(safeBytes) * lsbBits / 8,
+		RawBytes:       rawBytes * lsbBits / 8,
+	}
+	if diagnostics.RawBits > 0 {
+		diagnostics.SafeRatio = float64(diagnostics.SafeBits) / float64(diagnostics.RawBits)
+	}
+
+	diagnostics.Overhead = PayloadOverhead(config)
+	if capacity, err := newLSBCodec(config).payloadCapacity(len(safeBytes)); err == nil {
+		// payloadCapacity already excludes the fixed header fields
+		diagnostics.Capacity = max(capacity-diagnostics.Overhead.Filename-diagnostics.Overhead.Extensions, 0)
+	}
+
+	return diagnostics, nil
+}
+
+// MethodCapacities reports, for every MP3 method, how large a secret fits
+// with the given config, using each method's own CalculateCapacity. Methods
+// that cannot embed into the file report the reason instead.
+func MethodCapacities(mp3Data []byte, config *models.StegoConfig) []models.MethodCapacity {
+	overhead := PayloadOverhead(config)
+	capacities := make([]models.MethodCapacity, 0, len(autoMethods))
+	for _, name := range autoMethods {
+		methodConfig := *config
+		methodConfig.Method = name
+
+		entry := models.MethodCapacity{Method: name}
+		method, err := NewMP3Steganography(&methodConfig)
+		if err == nil {
+			var capacity int
+			if capacity, err = method.CalculateCapacity(mp3Data); err == nil {
+				entry.Capacity = max(capacity-overhead.Filename-overhead.Extensions, 0)
+			}
+		}
+		if err != nil {
+			entry.Error = err.Error()
+		}
+		capacities = append(capacities, entry)
+	}
+	return capacities
+}
+
+// PayloadOverhead itemizes the bytes framed around the secret for config.
+// The preamble and payload header totals are measured by encoding them, so
+// they follow the actual layout, including every extension the config
+// enables. The ID3 checksum is sized without a cover, as it always has the
+// same length.
+func PayloadOverhead(config *models.StegoConfig) *models.CapacityOverhead {
+	codec := newLSBCodec(config)
+	header := codec.configPreamble()
+	preamble := header.encode()
+	secret := &Payload{
+		Filename: config.SecretFilename,
+		Metadata: config.Metadata,
+		MIMEType: config.SecretMIMEType,
+		FileID:   config.FileID,
+		Expires:  config.Expires,
+		Part:     config.Part,
+	}
+	if config.ID3Checksum {
+		secret.ID3Checksum = make([]byte, sha256.Size)
+	}
+	framed := codec.encodePayload(secret)
+
+	overhead := &models.CapacityOverhead{
+		Marker:           len(header.Marker),
+		SeedHash:         1,
+		Domain:           4,
+		KeyCheck:         keyCheckLength,
+		SaltLength:       1,
+		Salt:             len(header.Salt),
+		FilenameLength:   4,
+		Filename:         len(secret.Filename),
+		ExtensionsLength: 4,
+		Extensions:       len(buildExtensions(secret)),
+		DataLength:       4,
+		PreambleBytes:    len(preamble),
+		PreambleCarriers: codec.safeBytesNeeded(len(preamble)),
+		HeaderBytes:      len(framed),
+		HeaderCarriers:   codec.safeBytesNeeded(len(framed)) * codec.density(),
+	}
+	overhead.Total = overhead.PreambleBytes + overhead.HeaderBytes
+
+	return overhead
+}
+
+// maxReportedAnomalies bounds the anomalies listed by CheckStreamConsistency;
+// the total is still counted
+const maxReportedAnomalies = 100
+
+// CheckStreamConsistency checks that every audio frame shares the sample rate
+// and channel count of the first one. Changes usually mean corruption or files
+// concatenated together, which also explains odd capacity or extraction
+// results. Bitrate changes are legitimate and only mark the stream as VBR.
+// Frames of another MPEG version or layer never get here: the parser skips
+// them like junk and counts them in SkippedBytes.
+func CheckStreamConsistency(mp3Data []byte) (*models.StreamConsistency, error) {
+	mp3File, err := mp3parser.ParseMP3File(mp3Data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse MP3: %w", err)
+	}
+
+	consistency := &models.StreamConsistency{Consistent: true}
+	var previous *mp3parser.MP3FrameHeader
+	for i, frame := range mp3File.Frames {
+		if frame.IsInfo {
+			continue
+		}
+
+		header := frame.Header
+		if previous == nil {
+			previous = header
+			continue
+		}
+
+		if header.Bitrate != previous.Bitrate {
+			consistency.VBR = true
+		}
+		addAnomaly(consistency, i, "sample_rate", previous.SampleRate, header.SampleRate)
+		addAnomaly(consistency, i, "channels", channelCount(previous), channelCount(header))
+		previous = header
+	}
+
+	return consistency, nil
+}
+
+// addAnomaly records a change of field between the previous frame and frame
+func addAnomaly(consistency *models.StreamConsistency, frame int, field string, from, to int) {
+	if from == to {
+		return
+	}
+
+	consistency.Consistent = false
+	consistency.AnomalyCount++
+	if len(consistency.Anomalies) < maxReportedAnomalies {
+		consistency.Anomalies = append(consistency.Anomalies, models.FrameAnomaly{
+			Frame: frame,
+			Field: field,
+			From:  from,
+			To:    to,
+		})
+	}
+}
+
+func channelCount(header *mp3parser.MP3FrameHeader) int {
+	if header.ChannelMode == mp3parser.ChannelModeMono {
+		return 1
+	}
+	return 2
+}
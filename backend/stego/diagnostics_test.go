@@ -0,0 +1,278 @@
+package stego
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"steganography-backend/models"
+	"steganography-backend/mp3parser"
+)
+
+func TestPayloadOverheadSums(t *testing.T) {
+	tests := []struct {
+		name   string
+		config models.StegoConfig
+	}{
+		{name: "bare", config: models.StegoConfig{LSBBits: 1}},
+		{name: "salt and filename", config: models.StegoConfig{LSBBits: 3, Salt: "per-file", SecretFilename: "report.pdf"}},
+		{
+			name: "every extension",
+			config: models.StegoConfig{
+				LSBBits:        2,
+				UseEncryption:  true,
+				SecretFilename: "notes.txt",
+				SecretMIMEType: "text/plain",
+				Metadata:       json.RawMessage(`{"to":"bob"}`),
+				FileID:         "copy-7",
+				Expires:        time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC),
+				Part:           &models.PartManifest{Index: 1, Count: 3},
+				ID3Checksum:    true,
+			},
+		},
+		{name: "density 4", config: models.StegoConfig{LSBBits: 4, Density: 4, SecretFilename: "a"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := tt.config
+			config.Key = "overhead"
+			overhead := PayloadOverhead(&config)
+
+			preamble := overhead.Marker + overhead.SeedHash + overhead.Domain + overhead.KeyCheck + overhead.SaltLength + overhead.Salt
+			if preamble != overhead.PreambleBytes {
+				t.Errorf("preamble items sum to %d, preamble_bytes = %d", preamble, overhead.PreambleBytes)
+			}
+			header := overhead.FilenameLength + overhead.Filename + overhead.ExtensionsLength + overhead.Extensions + overhead.DataLength
+			if header != overhead.HeaderBytes {
+				t.Errorf("header items sum to %d, header_bytes = %d", header, overhead.HeaderBytes)
+			}
+			if overhead.Total != overhead.PreambleBytes+overhead.HeaderBytes {
+				t.Errorf("total = %d, want %d", overhead.Total, overhead.PreambleBytes+overhead.HeaderBytes)
+			}
+
+			// The itemized sizes match what embedding actually writes
+			codec := newLSBCodec(&config)
+			if got := len(codec.configPreamble().encode()); got != overhead.PreambleBytes {
+				t.Errorf("encoded preamble is %d bytes, reported %d", got, overhead.PreambleBytes)
+			}
+			var id3Checksum []byte
+			if config.ID3Checksum {
+				id3Checksum = make([]byte, sha256.Size)
+			}
+			if got := len(codec.buildPayload(nil, id3Checksum)); got != overhead.HeaderBytes {
+				t.Errorf("framed empty secret is %d bytes, reported %d", got, overhead.HeaderBytes)
+			}
+			if want := codec.safeBytesNeeded(overhead.HeaderBytes) * codec.density(); overhead.HeaderCarriers != want {
+				t.Errorf("header carriers = %d, want %d", overhead.HeaderCarriers, want)
+			}
+		})
+	}
+}
+
+func TestAnalyzeCapacityRawAndSafe(t *testing.T) {
+	cover := loadCover(t, 200)
+	mp3File, err := mp3parser.ParseMP3File(cover)
+	if err != nil {
+		t.Fatal(err)
+	}
+	safeBytes, _ := collectSafeBytes(mp3File, nil)
+	rawBytes := 0
+	for _, frame := range mp3File.Frames[1:] { // The Info frame carries nothing
+		rawBytes += len(frame.Data)
+	}
+
+	for lsbBits := 1; lsbBits <= 4; lsbBits++ {
+		t.Run(fmt.Sprintf("%d bits", lsbBits), func(t *testing.T) {
+			diagnostics, err := AnalyzeCapacity(cover, &models.StegoConfig{LSBBits: lsbBits})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diagnostics.SafeCarriers != len(safeBytes) || diagnostics.RawCarriers != rawBytes {
+				t.Errorf("carriers safe %d, raw %d, want %d and %d", diagnostics.SafeCarriers, diagnostics.RawCarriers, len(safeBytes), rawBytes)
+			}
+			if diagnostics.SafeBits != len(safeBytes)*lsbBits || diagnostics.RawBits != rawBytes*lsbBits {
+				t.Errorf("bits safe %d, raw %d", diagnostics.SafeBits, diagnostics.RawBits)
+			}
+			if diagnostics.SafeBytes != diagnostics.SafeBits/8 || diagnostics.RawBytes != diagnostics.RawBits/8 {
+				t.Errorf("bytes safe %d, raw %d", diagnostics.SafeBytes, diagnostics.RawBytes)
+			}
+			// The raw figure is an upper bound no safe method reaches
+			if diagnostics.SafeRatio <= 0 || diagnostics.SafeRatio >= 1 {
+				t.Errorf("safe ratio = %v, want between 0 and 1", diagnostics.SafeRatio)
+			}
+		})
+	}
+
+	if _, err := AnalyzeCapacity(cover, &models.StegoConfig{LSBBits: 5}); err == nil {
+		t.Error("expected 5 LSB bits to be rejected")
+	}
+}
+
+func TestAnalyzeCapacitySkippedBytes(t *testing.T) {
+	cover := loadCover(t, 20)
+	mp3File, err := mp3parser.ParseMP3File(cover)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clean, err := AnalyzeCapacity(cover, &models.StegoConfig{LSBBits: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clean.SkippedBytes != 0 || clean.SkippedRegions != 0 {
+		t.Fatalf("clean cover skipped %d bytes in %d regions", clean.SkippedBytes, clean.SkippedRegions)
+	}
+
+	// Insert garbage after the frames at the given indexes
+	tests := []struct {
+		name        string
+		garbage     map[int]int // Frame index to garbage length
+		wantSkipped int
+		wantRegions int
+	}{
+		{name: "one region", garbage: map[int]int{5: 12}, wantSkipped: 12, wantRegions: 1},
+		{name: "two regions", garbage: map[int]int{3: 8, 10: 20}, wantSkipped: 28, wantRegions: 2},
+		{name: "after the last frame", garbage: map[int]int{19: 16}, wantSkipped: 16, wantRegions: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var corrupted []byte
+			corrupted = append(corrupted, encodeID3v2(mp3File)...)
+			for i, frame := range mp3File.Frames {
+				corrupted = append(corrupted, frame.HeaderBytes...)
+				corrupted = append(corrupted, frame.Data...)
+				corrupted = append(corrupted, bytes.Repeat([]byte("J"), tt.garbage[i])...)
+			}
+
+			diagnostics, err := AnalyzeCapacity(corrupted, &models.StegoConfig{LSBBits: 1})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diagnostics.SkippedBytes != tt.wantSkipped || diagnostics.SkippedRegions != tt.wantRegions {
+				t.Errorf("skipped %d bytes in %d regions, want %d in %d",
+					diagnostics.SkippedBytes, diagnostics.SkippedRegions, tt.wantSkipped, tt.wantRegions)
+			}
+			// Every frame is still found
+			if diagnostics.Frames != clean.Frames || diagnostics.SafeCarriers != clean.SafeCarriers {
+				t.Errorf("%d frames and %d safe carriers, want %d and %d",
+					diagnostics.Frames, diagnostics.SafeCarriers, clean.Frames, clean.SafeCarriers)
+			}
+		})
+	}
+}
+
+// encodeID3v2 returns the ID3v2 tag of mp3File as written to a file
+func encodeID3v2(mp3File *mp3parser.MP3File) []byte {
+	tagOnly := *mp3File
+	tagOnly.Frames, tagOnly.ID3v1Data = nil, nil
+	encoded, _ := mp3parser.WriteMP3File(&tagOnly)
+	return encoded
+}
+
+func TestMethodCapacities(t *testing.T) {
+	cover := loadCover(t, 200)
+	config := models.StegoConfig{Key: "methods", LSBBits: 2, SecretFilename: "secret.txt"}
+	overhead := PayloadOverhead(&config)
+
+	capacities := MethodCapacities(cover, &config)
+	if len(capacities) != 2 || capacities[0].Method != models.MethodAncillary || capacities[1].Method != models.MethodFrameLSB {
+		t.Fatalf("methods = %+v, want ancillary and frame_lsb", capacities)
+	}
+	for _, capacity := range capacities {
+		methodConfig := config
+		methodConfig.Method = capacity.Method
+		method, err := NewMP3Steganography(&methodConfig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := method.CalculateCapacity(cover)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want -= overhead.Filename + overhead.Extensions
+		if capacity.Error != "" || capacity.Capacity != want {
+			t.Errorf("%s: capacity %d (error %q), want %d", capacity.Method, capacity.Capacity, capacity.Error, want)
+		}
+	}
+	// Frame LSB trades audio safety for far more room
+	if capacities[1].Capacity <= capacities[0].Capacity {
+		t.Errorf("frame_lsb capacity %d is not above ancillary %d", capacities[1].Capacity, capacities[0].Capacity)
+	}
+
+	// A file without frames cannot hold anything with either method
+	for _, capacity := range MethodCapacities([]byte("not an mp3"), &config) {
+		if capacity.Error == "" || capacity.Capacity != 0 {
+			t.Errorf("%s: capacity %d (error %q), want an error", capacity.Method, capacity.Capacity, capacity.Error)
+		}
+	}
+}
+
+func TestCheckStreamConsistency(t *testing.T) {
+	const (
+		stereo128 = 0xFFFB9000 // 128 kbps, 44.1 kHz, stereo
+		stereo160 = 0xFFFBA000 // 160 kbps, 44.1 kHz, stereo
+		stereo48k = 0xFFFB9400 // 128 kbps, 48 kHz, stereo
+		mono128   = 0xFFFB90C0 // 128 kbps, 44.1 kHz, mono
+	)
+
+	tests := []struct {
+		name          string
+		headers       []uint32
+		wantVBR       bool
+		wantAnomalies []models.FrameAnomaly
+	}{
+		{name: "constant", headers: []uint32{stereo128, stereo128, stereo128}},
+		{name: "bitrate changes", headers: []uint32{stereo128, stereo160, stereo128}, wantVBR: true},
+		{
+			name:    "sample rate changes",
+			headers: []uint32{stereo128, stereo128, stereo48k, stereo48k},
+			wantAnomalies: []models.FrameAnomaly{
+				{Frame: 2, Field: "sample_rate", From: 44100, To: 48000},
+			},
+		},
+		{
+			name:    "channels change and back",
+			headers: []uint32{stereo128, mono128, stereo128},
+			wantAnomalies: []models.FrameAnomaly{
+				{Frame: 1, Field: "channels", From: 2, To: 1},
+				{Frame: 2, Field: "channels", From: 1, To: 2},
+			},
+		},
+		{
+			name:    "both",
+			headers: []uint32{mono128, stereo48k},
+			wantAnomalies: []models.FrameAnomaly{
+				{Frame: 1, Field: "sample_rate", From: 44100, To: 48000},
+				{Frame: 1, Field: "channels", From: 1, To: 2},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mp3Data []byte
+			for _, header := range tt.headers {
+				mp3Data = append(mp3Data, rawFrame(t, header)...)
+			}
+
+			consistency, err := CheckStreamConsistency(mp3Data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if consistency.VBR != tt.wantVBR {
+				t.Errorf("vbr = %v, want %v", consistency.VBR, tt.wantVBR)
+			}
+			if consistency.Consistent != (len(tt.wantAnomalies) == 0) || consistency.AnomalyCount != len(tt.wantAnomalies) {
+				t.Errorf("consistent = %v with %d anomalies, want %d", consistency.Consistent, consistency.AnomalyCount, len(tt.wantAnomalies))
+			}
+			if !slices.Equal(consistency.Anomalies, tt.wantAnomalies) {
+				t.Errorf("anomalies = %+v, want %+v", consistency.Anomalies, tt.wantAnomalies)
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package stego
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"steganography-backend/mp3parser"
+)
+
+// diffMagic opens every diff produced by DiffFiles
+const diffMagic = "SDIF"
+
+// maxDiffRun is the longest run of changed bytes stored in one record
+const maxDiffRun = 0xFFFF
+
+// FileDiff is a compact record of the bytes that differ between an original
+// and a stego file. It is encoded as
+//
+//	"SDIF" | stego length (4 bytes) | run count (4 bytes) | runs
+//
+// where each run is offset (4 bytes) | length (2 bytes) | new bytes. Bytes
+// past the end of the original are always stored, so applying the diff to the
+// original reproduces the stego file exactly.
+type FileDiff struct {
+	StegoLength  int
+	Runs         int
+	ChangedBytes int
+	encoded      []byte
+}
+
+// Bytes returns the binary encoding of the diff
+func (d *FileDiff) Bytes() []byte {
+	return d.encoded
+}
+
+// DiffFiles records every byte of stego that differs from original
+func DiffFiles(original, stego []byte) (*FileDiff, error) {
+	if uint64(len(stego)) > 0xFFFFFFFF {
+		return nil, fmt.Errorf("file too large to diff")
+	}
+
+	runs := make([]byte, 0)
+	diff := &FileDiff{StegoLength: len(stego)}
+
+	for i := 0; i < len(stego); {
+		if i < len(original) && original[i] == stego[i] {
+			i++
+			continue
+		}
+
+		start := i
+		for i < len(stego) && i-start < maxDiffRun && (i >= len(original) || original[i] != stego[i]) {
+			i++
+		}
+
+		runs = binary.BigEndian.AppendUint32(runs, uint32(start))
+		runs = binary.BigEndian.AppendUint16(runs, uint16(i-start))
+		runs = append(runs, stego[start:i]...)
+		diff.Runs++
+		diff.ChangedBytes += i - start
+	}
+
+	encoded := make([]byte, 0, 12+len(runs))
+	encoded = append(encoded, diffMagic...)
+	encoded = binary.BigEndian.AppendUint32(encoded, uint32(len(stego)))
+	encoded = binary.BigEndian.AppendUint32(encoded, uint32(diff.Runs))
+	diff.encoded = append(encoded, runs...)
+
+	return diff, nil
+}
+
+// ApplyDiff reproduces the stego file from the original and an encoded diff
+func ApplyDiff(original, diff []byte) ([]byte, error) {
+	if len(diff) < 12 || !bytes.Equal(diff[:4], []byte(diffMagic)) {
+		return nil, fmt.Errorf("not a stego diff")
+	}
+
+	stegoLength := int(binary.BigEndian.Uint32(diff[4:8]))
+	runCount := int(binary.BigEndian.Uint32(diff[8:12]))
+
+	result := make([]byte, stegoLength)
+	copy(result, original)
+
+	pos := 12
+	for i := 0; i < runCount; i++ {
+		if len(diff) < pos+6 {
+			return nil, fmt.Errorf("truncated diff run %d", i)
+		}
+		offset := int(binary.BigEndian.Uint32(diff[pos : pos+4]))
+		length := int(binary.BigEndian.Uint16(diff[pos+4 : pos+6]))
+		pos += 6
+
+		if len(diff) < pos+length || offset+length > stegoLength {
+			return nil, fmt.Errorf("invalid diff run %d", i)
+		}
+		copy(result[offset:offset+length], diff[pos:pos+length])
+		pos += length
+	}
+
+	return result, nil
+}
+
+// CountModifiedFrames compares two MP3s frame by frame and returns how many
+// frames differ. Frames present in only one file count as modified.
+func CountModifiedFrames(original, stego []byte) (int, error) {
+	originalFile, err := mp3parser.ParseMP3File(original)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse original MP3: %v", err)
+	}
+	stegoFile, err := mp3parser.ParseMP3File(stego)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse stego MP3: %v", err)
+	}
+
+	common := min(len(originalFile.Frames), len(stegoFile.Frames))
+	modified := max(len(originalFile.Frames), len(stegoFile.Frames)) - common
+	for i := 0; i < common; i++ {
+		originalFrame, stegoFrame := originalFile.Frames[i], stegoFile.Frames[i]
+		if !bytes.Equal(originalFrame.HeaderBytes, stegoFrame.HeaderBytes) || !bytes.Equal(originalFrame.Data, stegoFrame.Data) {
+			modified++
+		}
+	}
+
+	return modified, nil
+}
+
+// DiffRun is a run of consecutive bytes that differ between two files
+type DiffRun struct {
+	Offset int
+	Length int
+}
+
+// RoundTripReport lists the bytes that change when a file is parsed and
+// rewritten without embedding anything
+type RoundTripReport struct {
+	InputLength  int
+	OutputLength int
+	Runs         []DiffRun // Bytes present in only one of the two files are included
+}
+
+// Identical reports whether the rewritten file matches the input byte for byte
+func (r *RoundTripReport) Identical() bool {
+	return len(r.Runs) == 0 && r.InputLength == r.OutputLength
+}
+
+// RoundTrip parses mp3Data and writes it back unchanged, the same way every
+// embedding method reconstructs its output. Any differing bytes are silent
+// corruption introduced by the parser or writer rather than by embedding.
+func RoundTrip(mp3Data []byte) (*RoundTripReport, error) {
+	mp3File, err := mp3parser.ParseMP3File(mp3Data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse MP3: %w", err)
+	}
+	output, err := mp3parser.WriteMP3File(mp3File)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write MP3: %v", err)
+	}
+
+	report := &RoundTripReport{InputLength: len(mp3Data), OutputLength: len(output)}
+	length := max(len(mp3Data), len(output))
+	for i := 0; i < length; {
+		if i < len(mp3Data) && i < len(output) && mp3Data[i] == output[i] {
+			i++
+			continue
+		}
+
+		start := i
+		for i < length && (i >= len(mp3Data) || i >= len(output) || mp3Data[i] != output[i]) {
+			i++
+		}
+		report.Runs = append(report.Runs, DiffRun{Offset: start, Length: i - start})
+	}
+
+	return report, nil
+}
@@ -0,0 +1,113 @@
+package stego
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"steganography-backend/models"
+)
+
+func TestDiffReproducesStego(t *testing.T) {
+	cover := loadCover(t, 200)
+	method, err := NewMP3Steganography(&models.StegoConfig{Key: "diff", LSBBits: 2, UseRandomStart: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stegoData, err := method.EmbedInMP3(cover, bytes.Repeat([]byte("patched "), 50))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	longRun := bytes.Clone(cover)
+	for i := 1000; i < 1000+maxDiffRun+10; i++ {
+		longRun[i] ^= 0xFF
+	}
+
+	tests := []struct {
+		name     string
+		original []byte
+		stego    []byte
+		wantRuns int // -1 skips the check
+	}{
+		{name: "embedded", original: cover, stego: stegoData, wantRuns: -1},
+		{name: "identical", original: cover, stego: cover, wantRuns: 0},
+		{name: "run longer than a record", original: cover, stego: longRun, wantRuns: 2},
+		{name: "stego longer", original: cover[:len(cover)-100], stego: cover, wantRuns: 1},
+		{name: "stego shorter", original: cover, stego: cover[:len(cover)-100], wantRuns: 0},
+		{name: "empty original", original: nil, stego: cover[:500], wantRuns: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diff, err := DiffFiles(tt.original, tt.stego)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantRuns >= 0 && diff.Runs != tt.wantRuns {
+				t.Errorf("%d runs, want %d", diff.Runs, tt.wantRuns)
+			}
+			if diff.StegoLength != len(tt.stego) {
+				t.Errorf("stego length = %d, want %d", diff.StegoLength, len(tt.stego))
+			}
+
+			applied, err := ApplyDiff(tt.original, diff.Bytes())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(applied, tt.stego) {
+				t.Error("applying the diff does not reproduce the stego file")
+			}
+		})
+	}
+
+	t.Run("corrupt diff", func(t *testing.T) {
+		diff, err := DiffFiles(cover, stegoData)
+		if err != nil {
+			t.Fatal(err)
+		}
+		encoded := diff.Bytes()
+		if _, err := ApplyDiff(cover, encoded[:len(encoded)-1]); err == nil {
+			t.Error("expected a truncated diff to be rejected")
+		}
+		if _, err := ApplyDiff(cover, append([]byte("XXXX"), encoded[4:]...)); err == nil {
+			t.Error("expected a diff without the magic to be rejected")
+		}
+	})
+}
+
+func TestRoundTripUnchanged(t *testing.T) {
+	tests := []string{
+		"../../test_cases/file_example_MP3_700KB.mp3",
+		"../../test_cases/Billie Eilish - WILDFLOWER (Official Lyric Video).mp3",
+	}
+
+	for _, path := range tests {
+		t.Run(path, func(t *testing.T) {
+			mp3Data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			report, err := RoundTrip(mp3Data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !report.Identical() {
+				t.Errorf("%d bytes in, %d out, differing runs %+v", report.InputLength, report.OutputLength, report.Runs)
+			}
+		})
+	}
+
+	t.Run("skipped junk is reported", func(t *testing.T) {
+		cover := loadCover(t, 20)
+		junk := append(bytes.Clone(cover), "trailing junk"...)
+		report, err := RoundTrip(junk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []DiffRun{{Offset: len(cover), Length: len("trailing junk")}}
+		if report.Identical() || len(report.Runs) != 1 || report.Runs[0] != want[0] {
+			t.Errorf("runs = %+v, want %+v", report.Runs, want)
+		}
+	})
+}
@@ -0,0 +1,201 @@
+package stego
+
+import (
+	"fmt"
+
+	"steganography-backend/models"
+	"steganography-backend/mp3parser"
+)
+
+const (
+	DefaultFrameReservedBytes = 10
+	MaxFrameReservedBytes     = 1024
+)
+
+// MP3LSBSteganography hides data in the LSBs of the frame payload bytes. It
+// offers far more capacity than the ancillary method but modifies coded audio,
+// so part of every frame is kept untouched according to the reservation
+// strategy. Capacity, embedding and extraction all go through acceptsFrame and
+// usableRange, so they always agree on which bytes are used.
+//
+// The default, ReserveSideInfo, protects the CRC and side information, so
+// every frame still decodes and only the main data (the coded audio itself)
+// picks up LSB noise. ReserveFirst and ReserveLast protect a fixed byte count
+// instead: unless the first N bytes cover the side info, its LSBs are flipped
+// and the decoder misreads the frame, trading audio integrity for capacity.
+type MP3LSBSteganography struct {
+	*lsbCodec
+	reservation   string
+	reservedBytes int
+}
+
+func NewMP3LSBSteganography(config *models.StegoConfig) *MP3LSBSteganography {
+	reservation := config.FrameReservation
+	reservedBytes := config.FrameReservedBytes
+	if reservation == "" {
+		reservation = models.ReserveSideInfo
+	}
+
+	return &MP3LSBSteganography{
+		lsbCodec:      newLSBCodec(config),
+		reservation:   reservation,
+		reservedBytes: reservedBytes,
+	}
+}
+
+// ValidateFrameReservation validates a frame reservation strategy and size
+func ValidateFrameReservation(reservation string, reservedBytes int) error {
+	switch reservation {
+	case "", models.ReserveLast, models.ReserveFirst, models.ReserveSideInfo:
+	default:
+		return fmt.Errorf("unknown frame reservation strategy: %q", reservation)
+	}
+
+	if reservedBytes < 0 || reservedBytes > MaxFrameReservedBytes {
+		return fmt.Errorf("reserved bytes must be between 0 and %d", MaxFrameReservedBytes)
+	}
+
+	return nil
+}
+
+// usableRange returns the [start, end) range of the frame data left
+// modifiable by the reservation strategy
+func (lsb *MP3LSBSteganography) usableRange(frame *mp3parser.MP3Frame) (int, int) {
+	start, end := 0, len(frame.Data)
+
+	switch lsb.reservation {
+	case models.ReserveFirst:
+		start = lsb.reservedBytes
+	case models.ReserveLast:
+		end -= lsb.reservedBytes
+	default:
+		start = mp3parser.SideInfoSize(frame.Header)
+		if frame.Header.ProtectionBit {
+			start += 2 // CRC-16 precedes the side info
+		}
+	}
+
+	if start > end {
+		return 0, 0
+	}
+	return start, end
+}
+
+// collectUsableBytes copies the usable bytes of every audio frame into one slice
+func (lsb *MP3LSBSteganography) collectUsableBytes(mp3File *mp3parser.MP3File) []byte {
+	usable := make([]byte, 0)
+	for i, frame := range mp3File.Frames {
+		if !acceptsFrame(lsb.config.FrameFilter, i, frame) {
+			continue // Never embed into the Xing/Info frame or filtered frames
+		}
+
+		start, end := lsb.usableRange(frame)
+		usable = append(usable, frame.Data[start:end]...)
+	}
+	return usable
+}
+
+func (lsb *MP3LSBSteganography) CalculateCapacity(mp3Data []byte) (int, error) {
+	mp3File, err := mp3parser.ParseMP3File(mp3Data)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse MP3: %w", err)
+	}
+
+	totalUsableBytes := len(lsb.collectUsableBytes(mp3File))
+	if totalUsableBytes == 0 {
+		return 0, fmt.Errorf("no usable frame data found in MP3 frames")
+	}
+
+	return lsb.payloadCapacity(totalUsableBytes)
+}
+
+func (lsb *MP3LSBSteganography) EmbedInMP3(mp3Data []byte, secretData []byte) ([]byte, error) {
+	mp3File, err := mp3parser.ParseMP3File(mp3Data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse MP3: %w", err)
+	}
+
+	usable := lsb.collectUsableBytes(mp3File)
+	if len(usable) == 0 {
+		return nil, fmt.Errorf("no usable frame data available for embedding")
+	}
+
+	if err := lsb.embedPayload(usable, secretData, lsb.coverID3Checksum(mp3File)); err != nil {
+		return nil, err
+	}
+	if lsb.config.StageHook != nil {
+		lsb.config.StageHook("embed")
+	}
+
+	return lsb.writeUsableBytes(mp3File, usable)
+}
+
+// writeUsableBytes puts modified bytes back into the same ranges they were
+// taken from and serializes the MP3
+func (lsb *MP3LSBSteganography) writeUsableBytes(mp3File *mp3parser.MP3File, usable []byte) ([]byte, error) {
+	usableIndex := 0
+	for i, frame := range mp3File.Frames {
+		if !acceptsFrame(lsb.config.FrameFilter, i, frame) {
+			continue
+		}
+
+		start, end := lsb.usableRange(frame)
+		usableIndex += copy(frame.Data[start:end], usable[usableIndex:])
+	}
+
+	return mp3parser.WriteMP3File(mp3File)
+}
+
+func (lsb *MP3LSBSteganography) ExtractFromMP3(mp3Data []byte) ([]byte, string, error) {
+	payload, err := lsb.ExtractPayloadFromMP3(mp3Data)
+	if err != nil {
+		return nil, "", err
+	}
+	return payload.Data, payload.Filename, nil
+}
+
+// ExtractPayloadFromMP3 extracts the secret along with its header metadata
+func (lsb *MP3LSBSteganography) ExtractPayloadFromMP3(mp3Data []byte) (*Payload, error) {
+	mp3File, err := mp3parser.ParseMP3File(mp3Data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse MP3: %w", err)
+	}
+
+	usable := lsb.collectUsableBytes(mp3File)
+	if len(usable) == 0 {
+		return nil, fmt.Errorf("no usable frame data found")
+	}
+
+	payload, err := lsb.extractPayload(usable)
+	if err != nil {
+		return nil, err
+	}
+	checkID3(payload, mp3File)
+	return payload, nil
+}
+
+// CheckKeyInMP3 verifies the key against the preamble without extracting the payload
+func (lsb *MP3LSBSteganography) CheckKeyInMP3(mp3Data []byte) error {
+	mp3File, err := mp3parser.ParseMP3File(mp3Data)
+	if err != nil {
+		return fmt.Errorf("failed to parse MP3: %w", err)
+	}
+
+	return lsb.checkKey(lsb.collectUsableBytes(mp3File))
+}
+
+// UpdateHeaderInMP3 rewrites the stored filename and metadata of the embedded
+// payload in place, without re-embedding the secret
+func (lsb *MP3LSBSteganography) UpdateHeaderInMP3(mp3Data []byte, update HeaderUpdate) ([]byte, error) {
+	mp3File, err := mp3parser.ParseMP3File(mp3Data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse MP3: %w", err)
+	}
+
+	usable := lsb.collectUsableBytes(mp3File)
+	if err := lsb.rewritePayloadHeader(usable, update); err != nil {
+		return nil, err
+	}
+
+	return lsb.writeUsableBytes(mp3File, usable)
+}
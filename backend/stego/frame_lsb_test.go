@@ -0,0 +1,197 @@
+package stego
+
+import (
+	"bytes"
+	"testing"
+
+	"steganography-backend/audio"
+	"steganography-backend/models"
+	"steganography-backend/mp3parser"
+)
+
+// decodedPSNR decodes both MP3s and returns the PSNR of the stego audio. It
+// fails when the decodes differ too much in length to be compared.
+func decodedPSNR(t *testing.T, originalMP3, stegoMP3 []byte) (float64, error) {
+	t.Helper()
+	decoder := audio.NewAudioDecoder()
+	originalPCM, originalMeta, err := decoder.DecodeMP3ToPCM(originalMP3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stegoPCM, stegoMeta, err := decoder.DecodeMP3ToPCM(stegoMP3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	originalPCM, stegoPCM = audio.AlignPCMBytes(originalPCM, stegoPCM, audio.OddBytesTruncate)
+	original, embedded, channels, err := audio.AlignPCMSamples(
+		audio.BytesToFloat64(originalPCM), originalMeta.Channels,
+		audio.BytesToFloat64(stegoPCM), stegoMeta.Channels, 0)
+	if err != nil {
+		return 0, err
+	}
+	return audio.CalculatePSNRForChannelMode(original, embedded, channels, 0), nil
+}
+
+func TestFrameReservationStrategies(t *testing.T) {
+	cover := loadCover(t, 200)
+	secret := bytes.Repeat([]byte("frame reservation "), 100)
+
+	tests := []struct {
+		name          string
+		reservation   string
+		reservedBytes int
+		// The side info is left intact, so every frame still decodes
+		keepsSideInfo bool
+		wantErr       bool
+	}{
+		{name: "side info (default)", reservation: "", keepsSideInfo: true},
+		{name: "side info", reservation: models.ReserveSideInfo, keepsSideInfo: true},
+		{name: "first 40", reservation: models.ReserveFirst, reservedBytes: 40, keepsSideInfo: true},
+		{name: "last 10", reservation: models.ReserveLast, reservedBytes: 10},
+		{name: "first 0", reservation: models.ReserveFirst},
+		{name: "unknown", reservation: "middle", wantErr: true},
+		{name: "too many bytes", reservation: models.ReserveLast, reservedBytes: MaxFrameReservedBytes + 1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := models.StegoConfig{
+				Key:                "reservation",
+				LSBBits:            1,
+				UseRandomStart:     true,
+				Method:             models.MethodFrameLSB,
+				FrameReservation:   tt.reservation,
+				FrameReservedBytes: tt.reservedBytes,
+			}
+			method, err := NewMP3Steganography(&config)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			capacity, err := method.CalculateCapacity(cover)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if capacity < len(secret) {
+				t.Fatalf("capacity %d is below the secret size %d", capacity, len(secret))
+			}
+
+			stegoData, err := method.EmbedInMP3(cover, secret)
+			if err != nil {
+				t.Fatalf("embed: %v", err)
+			}
+			payload, err := method.ExtractPayloadFromMP3(stegoData)
+			if err != nil {
+				t.Fatalf("extract: %v", err)
+			}
+			if !bytes.Equal(payload.Data, secret) {
+				t.Error("extracted data differs from the secret")
+			}
+
+			// Main data LSBs only add noise; flipping side info LSBs makes
+			// the decoder misread frames, so the audio no longer lines up
+			psnr, err := decodedPSNR(t, cover, stegoData)
+			switch {
+			case tt.keepsSideInfo && (err != nil || psnr <= 0):
+				t.Errorf("PSNR = %.2f dB (err %v), want a measurable PSNR", psnr, err)
+			case !tt.keepsSideInfo && err == nil:
+				t.Errorf("PSNR = %.2f dB with the side info modified, want misaligned audio", psnr)
+			}
+			t.Logf("capacity %d bytes, PSNR %.2f dB (err %v)", capacity, psnr, err)
+		})
+	}
+}
+
+func TestReservedBytesUntouched(t *testing.T) {
+	cover := loadCover(t, 200)
+	original, err := mp3parser.ParseMP3File(cover)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := bytes.Repeat([]byte("reserved "), 400)
+
+	tests := []struct {
+		name          string
+		reservation   string
+		reservedBytes int
+		reserved      func(frame *mp3parser.MP3Frame) (int, int) // [start, end) that must not change
+	}{
+		{
+			name:        "side info",
+			reservation: models.ReserveSideInfo,
+			reserved: func(frame *mp3parser.MP3Frame) (int, int) {
+				return 0, mp3parser.SideInfoSize(frame.Header)
+			},
+		},
+		{
+			name:          "last 10",
+			reservation:   models.ReserveLast,
+			reservedBytes: DefaultFrameReservedBytes,
+			reserved: func(frame *mp3parser.MP3Frame) (int, int) {
+				return len(frame.Data) - DefaultFrameReservedBytes, len(frame.Data)
+			},
+		},
+		{
+			name:          "first 40",
+			reservation:   models.ReserveFirst,
+			reservedBytes: 40,
+			reserved: func(frame *mp3parser.MP3Frame) (int, int) {
+				return 0, 40
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := models.StegoConfig{
+				Key:                "reserved",
+				LSBBits:            4,
+				Method:             models.MethodFrameLSB,
+				FrameReservation:   tt.reservation,
+				FrameReservedBytes: tt.reservedBytes,
+			}
+			method, err := NewMP3Steganography(&config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			stegoData, err := method.EmbedInMP3(cover, secret)
+			if err != nil {
+				t.Fatalf("embed: %v", err)
+			}
+			embedded, err := mp3parser.ParseMP3File(stegoData)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			modified := 0
+			for i, frame := range embedded.Frames {
+				if !bytes.Equal(frame.Data, original.Frames[i].Data) {
+					modified++
+				}
+				start, end := tt.reserved(frame)
+				if !bytes.Equal(frame.Data[start:end], original.Frames[i].Data[start:end]) {
+					t.Fatalf("frame %d: reserved bytes [%d, %d) were modified", i, start, end)
+				}
+			}
+			if modified == 0 {
+				t.Fatal("nothing was embedded")
+			}
+
+			// Extraction skips the same bytes, so the secret comes back whole
+			payload, err := method.ExtractPayloadFromMP3(stegoData)
+			if err != nil {
+				t.Fatalf("extract: %v", err)
+			}
+			if !bytes.Equal(payload.Data, secret) {
+				t.Error("extracted data differs from the secret")
+			}
+		})
+	}
+}
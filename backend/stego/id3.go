@@ -0,0 +1,39 @@
+package stego
+
+import (
+	"bytes"
+	"crypto/sha256"
+
+	"steganography-backend/mp3parser"
+)
+
+// ID3Checksum hashes the ID3v2 tag of an MP3: its version, flags and data.
+// A file without a tag hashes as an empty tag, so adding one is noticed too.
+func ID3Checksum(mp3File *mp3parser.MP3File) []byte {
+	hash := sha256.New()
+	if mp3File.ID3v2 != nil {
+		hash.Write([]byte{mp3File.ID3v2.Version[0], mp3File.ID3v2.Version[1], mp3File.ID3v2.Flags})
+		hash.Write(mp3File.ID3v2Data)
+	}
+	return hash.Sum(nil)
+}
+
+// coverID3Checksum returns the checksum of the cover's tag to store with the
+// payload, or nil when the config does not ask for one
+func (lsb *lsbCodec) coverID3Checksum(mp3File *mp3parser.MP3File) []byte {
+	if !lsb.config.ID3Checksum {
+		return nil
+	}
+	return ID3Checksum(mp3File)
+}
+
+// checkID3 flags an extracted payload whose stored tag checksum no longer
+// matches the file. Re-tagging never moves the embedded data, so this is
+// informational only.
+func checkID3(payload *Payload, mp3File *mp3parser.MP3File) {
+	if payload.ID3Checksum == nil || bytes.Equal(payload.ID3Checksum, ID3Checksum(mp3File)) {
+		return
+	}
+	payload.Retagged = true
+	payload.Warnings = append(payload.Warnings, "the ID3v2 tag changed since embedding; the file was re-tagged")
+}
@@ -0,0 +1,99 @@
+package stego
+
+import (
+	"bytes"
+	"testing"
+
+	"steganography-backend/models"
+	"steganography-backend/mp3parser"
+)
+
+// retag rewrites the ID3v2 tag of an MP3 the way a player would, leaving
+// the frames alone
+func retag(t *testing.T, mp3Data []byte, edit func(mp3File *mp3parser.MP3File)) []byte {
+	t.Helper()
+	mp3File, err := mp3parser.ParseMP3File(mp3Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edit(mp3File)
+	retagged, err := mp3parser.WriteMP3File(mp3File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return retagged
+}
+
+func TestExtractAfterRetagging(t *testing.T) {
+	cover := loadCover(t, 200)
+	secret := []byte("survives a new tag")
+
+	tests := []struct {
+		name         string
+		edit         func(mp3File *mp3parser.MP3File)
+		wantRetagged bool
+	}{
+		{
+			name:         "unchanged",
+			edit:         func(mp3File *mp3parser.MP3File) {},
+			wantRetagged: false,
+		},
+		{
+			name: "grown by an odd size",
+			edit: func(mp3File *mp3parser.MP3File) {
+				mp3File.ID3v2Data = append(mp3File.ID3v2Data, make([]byte, 1001)...)
+			},
+			wantRetagged: true,
+		},
+		{
+			name: "shrunk",
+			edit: func(mp3File *mp3parser.MP3File) {
+				mp3File.ID3v2Data = mp3File.ID3v2Data[:len(mp3File.ID3v2Data)/2]
+			},
+			wantRetagged: true,
+		},
+		{
+			name: "removed",
+			edit: func(mp3File *mp3parser.MP3File) {
+				mp3File.ID3v2, mp3File.ID3v2Data = nil, nil
+			},
+			wantRetagged: true,
+		},
+		{
+			name: "ID3v1 appended",
+			edit: func(mp3File *mp3parser.MP3File) {
+				tag := make([]byte, 128)
+				copy(tag, "TAGtitle")
+				mp3File.ID3v1Data = tag
+			},
+			wantRetagged: false,
+		},
+	}
+
+	for _, method := range []string{models.MethodAncillary, models.MethodFrameLSB} {
+		config := models.StegoConfig{Key: "retag", LSBBits: 2, UseRandomStart: true, Method: method, ID3Checksum: true}
+		embedder, err := NewMP3Steganography(&config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stegoData, err := embedder.EmbedInMP3(cover, secret)
+		if err != nil {
+			t.Fatalf("embed with %s: %v", method, err)
+		}
+
+		for _, tt := range tests {
+			t.Run(method+"/"+tt.name, func(t *testing.T) {
+				payload, err := embedder.ExtractPayloadFromMP3(retag(t, stegoData, tt.edit))
+				if err != nil {
+					t.Fatalf("extract: %v", err)
+				}
+				if !bytes.Equal(payload.Data, secret) {
+					t.Errorf("extracted %q, want %q", payload.Data, secret)
+				}
+				if payload.Retagged != tt.wantRetagged {
+					t.Errorf("retagged = %v, want %v", payload.Retagged, tt.wantRetagged)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package stego
+
+import (
+	"encoding/binary"
+
+	"steganography-backend/crypto"
+	"steganography-backend/models"
+	"steganography-backend/mp3parser"
+)
+
+// Bounds the original layout enforced on extraction
+const (
+	legacyFilenameLimit = 255
+	legacyDataLimit     = 10 * 1024 * 1024
+)
+
+// extractLegacyPayload reads a secret embedded with the original ancillary
+// layout, from before the preamble existed: no marker, key check or salt, a
+// seed from MD5 of the key, the payload framed as
+// filename length + filename + data length + data and the safe bytes of every
+// frame that analyzes, the Xing/Info frame included. Such files carry nothing
+// to recognise them by, so whatever does not parse as a payload is reported
+// as ErrNoPayload.
+func (lsb *MP3AncillaryLSBSteganography) extractLegacyPayload(mp3File *mp3parser.MP3File) (*Payload, error) {
+	allSafeBytes := make([]byte, 0)
+	for _, frame := range mp3File.Frames {
+		regions, err := mp3parser.AnalyzeFrameData(frame.Header, frame.Data)
+		if err != nil {
+			continue
+		}
+		allSafeBytes = append(allSafeBytes, regions.GetSafeModificationBytes()...)
+	}
+
+	// Only the LSB depth, placement and encryption existed back then
+	legacyConfig := models.StegoConfig{
+		Key:            lsb.config.Key,
+		UseEncryption:  lsb.config.UseEncryption,
+		UseRandomStart: lsb.config.UseRandomStart,
+		LSBBits:        lsb.config.LSBBits,
+	}
+	codec := newLSBCodec(&legacyConfig)
+	codec.legacyPermutation = true
+
+	seed := generateSeed(legacyConfig.Key, "", models.SeedHashMD5)
+	positions := codec.generatePositions(seed, len(allSafeBytes), len(allSafeBytes))
+	extractedBytes := codec.extractBits(allSafeBytes, positions)
+	if len(extractedBytes) < 8 {
+		return nil, ErrNoPayload
+	}
+
+	// The whole payload was encrypted, so the prefix decrypts on its own
+	if legacyConfig.UseEncryption {
+		extractedBytes = crypto.NewExtendedVigenere(legacyConfig.Key).Decrypt(extractedBytes)
+	}
+
+	filenameLen := int(binary.BigEndian.Uint32(extractedBytes[0:4]))
+	if filenameLen > legacyFilenameLimit || len(extractedBytes) < 8+filenameLen {
+		return nil, ErrNoPayload
+	}
+	dataLen := int(binary.BigEndian.Uint32(extractedBytes[4+filenameLen : 8+filenameLen]))
+	dataStart := 8 + filenameLen
+	if dataLen == 0 || dataLen > legacyDataLimit || dataStart+dataLen > len(extractedBytes) {
+		return nil, ErrNoPayload
+	}
+	if dataLen > lsb.outputLimit() {
+		return nil, &OutputLimitError{Declared: dataLen, Limit: lsb.outputLimit()}
+	}
+
+	return &Payload{
+		Filename: string(extractedBytes[4 : 4+filenameLen]),
+		Data:     extractedBytes[dataStart : dataStart+dataLen],
+		SeedHash: models.SeedHashMD5,
+		Warnings: []string{"the file uses the original layout without a key check or metadata; re-embed it to get both"},
+	}, nil
+}
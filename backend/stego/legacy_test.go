@@ -0,0 +1,178 @@
+package stego
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"steganography-backend/models"
+	"steganography-backend/mp3parser"
+)
+
+func TestExtractLegacyFixtures(t *testing.T) {
+	tests := []struct {
+		name    string
+		fixture string
+		config  models.StegoConfig
+		// The sequential unencrypted layout never used the key
+		keyed bool
+	}{
+		{
+			name:    "sequential",
+			fixture: "legacy_sequential.mp3",
+			config:  models.StegoConfig{Key: "legacy-key", LSBBits: 1},
+		},
+		{
+			name:    "random start with encryption",
+			fixture: "legacy_random.mp3",
+			config:  models.StegoConfig{Key: "legacy-key", LSBBits: 2, UseEncryption: true, UseRandomStart: true},
+			keyed:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mp3Data, err := os.ReadFile(filepath.Join("testdata", tt.fixture))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			config := tt.config
+			payload, err := NewMP3AncillaryLSBSteganography(&config).ExtractPayloadFromMP3(mp3Data)
+			if err != nil {
+				t.Fatalf("extract: %v", err)
+			}
+			if got := string(payload.Data); got != "embedded before the preamble existed" {
+				t.Errorf("data = %q", got)
+			}
+			if payload.Filename != "legacy.txt" {
+				t.Errorf("filename = %q, want legacy.txt", payload.Filename)
+			}
+			if payload.SeedHash != models.SeedHashMD5 {
+				t.Errorf("seed hash = %q, want md5", payload.SeedHash)
+			}
+			if err := NewMP3AncillaryLSBSteganography(&config).CheckKeyInMP3(mp3Data); !errors.Is(err, ErrNoPayload) {
+				t.Errorf("check key without legacy: err = %v, want ErrNoPayload", err)
+			}
+			checkLegacy := config
+			checkLegacy.CheckLegacy = true
+			if err := NewMP3AncillaryLSBSteganography(&checkLegacy).CheckKeyInMP3(mp3Data); err != nil {
+				t.Errorf("check key with legacy: %v", err)
+			}
+
+			if !tt.keyed {
+				return
+			}
+			wrong := config
+			wrong.Key = "another-key"
+			if _, err := NewMP3AncillaryLSBSteganography(&wrong).ExtractPayloadFromMP3(mp3Data); !errors.Is(err, ErrNoPayload) {
+				t.Errorf("wrong key: err = %v, want ErrNoPayload", err)
+			}
+			wrong.CheckLegacy = true
+			if err := NewMP3AncillaryLSBSteganography(&wrong).CheckKeyInMP3(mp3Data); !errors.Is(err, ErrNoPayload) {
+				t.Errorf("wrong key check with legacy: err = %v, want ErrNoPayload", err)
+			}
+		})
+	}
+}
+
+func TestLegacyOutputLimit(t *testing.T) {
+	mp3Data, err := os.ReadFile(filepath.Join("testdata", "legacy_sequential.mp3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	const secretSize = len("embedded before the preamble existed")
+
+	tests := []struct {
+		name      string
+		frames    int // frames kept, 0 keeps them all
+		maxOutput int
+		wantLimit bool
+		wantErr   error
+	}{
+		{name: "over the limit", maxOutput: 10, wantLimit: true},
+		{name: "at the limit", maxOutput: secretSize},
+		// The Info frame and three data frames just hold the whole payload
+		{name: "just complete", frames: 4, maxOutput: 10, wantLimit: true},
+		// Without the data in full the bytes may not be a payload at all
+		{name: "data cut short", frames: 3, maxOutput: 10, wantErr: ErrNoPayload},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := mp3Data
+			if tt.frames > 0 {
+				mp3File, err := mp3parser.ParseMP3File(mp3Data)
+				if err != nil {
+					t.Fatal(err)
+				}
+				mp3File.Frames = mp3File.Frames[:tt.frames]
+				if data, err = mp3parser.WriteMP3File(mp3File); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			config := models.StegoConfig{Key: "legacy-key", LSBBits: 1, MaxOutputBytes: tt.maxOutput}
+			payload, err := NewMP3AncillaryLSBSteganography(&config).ExtractPayloadFromMP3(data)
+			var limit *OutputLimitError
+			switch {
+			case tt.wantLimit:
+				if !errors.As(err, &limit) || *limit != (OutputLimitError{Declared: secretSize, Limit: tt.maxOutput}) {
+					t.Errorf("err = %v, want an OutputLimitError for %d of %d bytes", err, secretSize, tt.maxOutput)
+				}
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) || errors.As(err, &limit) {
+					t.Errorf("err = %v, want %v", err, tt.wantErr)
+				}
+			case err != nil:
+				t.Fatal(err)
+			case len(payload.Data) != secretSize:
+				t.Errorf("extracted %d bytes, want %d", len(payload.Data), secretSize)
+			}
+		})
+	}
+}
+
+func TestMarkerMismatchFallsThroughLegacy(t *testing.T) {
+	legacy, err := os.ReadFile(filepath.Join("testdata", "legacy_sequential.mp3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	embedConfig := models.StegoConfig{Key: "legacy-key", LSBBits: 1, Marker: "ACME"}
+	namespaced, err := NewMP3AncillaryLSBSteganography(&embedConfig).EmbedInMP3(loadCover(t, 200), []byte("namespaced"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		mp3Data  []byte
+		marker   string
+		wantData string // "" expects ErrNoPayload
+	}{
+		{name: "other marker", mp3Data: namespaced, marker: DefaultMarker},
+		{name: "matching marker", mp3Data: namespaced, marker: "ACME", wantData: "namespaced"},
+		// The original layout has no marker, so it is tried whatever the marker
+		{name: "original layout", mp3Data: legacy, marker: "ACME", wantData: "embedded before the preamble existed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := models.StegoConfig{Key: "legacy-key", LSBBits: 1, Marker: tt.marker}
+			payload, err := NewMP3AncillaryLSBSteganography(&config).ExtractPayloadFromMP3(tt.mp3Data)
+			if tt.wantData == "" {
+				if !errors.Is(err, ErrNoPayload) {
+					t.Errorf("err = %v, want ErrNoPayload", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(payload.Data) != tt.wantData {
+				t.Errorf("data = %q, want %q", payload.Data, tt.wantData)
+			}
+		})
+	}
+}
@@ -2,169 +2,108 @@
 package stego
 
 import (
-	"crypto/md5"
-	"encoding/binary"
+	"errors"
 	"fmt"
-	"math/rand"
 
-	"steganography-backend/crypto"
 	"steganography-backend/models"
 	"steganography-backend/mp3parser"
 )
 
+// MP3AncillaryLSBSteganography hides data in the LSBs of the ancillary and
+// padding bytes of MP3 frames. Positions index the concatenated safe bytes of
+// the audio frames only, so they are frame-relative: the ID3 tags are never
+// part of the position space and re-tagging a file does not move them.
 type MP3AncillaryLSBSteganography struct {
-	config *models.StegoConfig
-	rng    *rand.Rand
+	*lsbCodec
 }
 
 func NewMP3AncillaryLSBSteganography(config *models.StegoConfig) *MP3AncillaryLSBSteganography {
-	seed := generateSeed(config.Key)
-	rng := rand.New(rand.NewSource(seed))
+	codec := newLSBCodec(config)
+	// Random-start ancillary files have always used this permutation
+	codec.legacyPermutation = true
 
 	return &MP3AncillaryLSBSteganography{
-		config: config,
-		rng:    rng,
+		lsbCodec: codec,
 	}
 }
 
-func generateSeed(key string) int64 {
-	hash := md5.Sum([]byte(key))
-	return int64(binary.BigEndian.Uint64(hash[:8]))
+// collectSafeBytes concatenates the safe bytes of every frame and returns the
+// regions of each frame for reconstruction. Capacity, embedding and extraction
+// all use it, so a frame is treated identically everywhere: frames that fail
+// analysis (including frames too short for their side info), frames rejected
+// by the filter and the Xing/Info frame get empty regions, contribute no bytes
+// and are written back unchanged. A nil filter accepts every frame.
+func collectSafeBytes(mp3File *mp3parser.MP3File, filter func(int, *mp3parser.MP3Frame) bool) ([]byte, []*mp3parser.MP3FrameRegions) {
+	allSafeBytes := make([]byte, 0)
+	frameRegions := make([]*mp3parser.MP3FrameRegions, 0, len(mp3File.Frames))
+
+	for i, frame := range mp3File.Frames {
+		regions, err := mp3parser.AnalyzeFrameData(frame.Header, frame.Data)
+		if err != nil || !acceptsFrame(filter, i, frame) {
+			// Create empty regions for problematic, filtered and Xing/Info frames
+			regions = &mp3parser.MP3FrameRegions{}
+		}
+
+		frameRegions = append(frameRegions, regions)
+		allSafeBytes = append(allSafeBytes, regions.GetSafeModificationBytes()...)
+	}
+
+	return allSafeBytes, frameRegions
+}
+
+// acceptsFrame reports whether a frame may carry data: never the Xing/Info
+// frame, otherwise whatever the filter decides
+func acceptsFrame(filter func(int, *mp3parser.MP3Frame) bool, index int, frame *mp3parser.MP3Frame) bool {
+	if frame.IsInfo {
+		return false
+	}
+	return filter == nil || filter(index, frame)
 }
 
 func (lsb *MP3AncillaryLSBSteganography) CalculateCapacity(mp3Data []byte) (int, error) {
 	mp3File, err := mp3parser.ParseMP3File(mp3Data)
 	if err != nil {
-		return 0, fmt.Errorf("failed to parse MP3: %v", err)
+		return 0, fmt.Errorf("failed to parse MP3: %w", err)
 	}
 
-	totalSafeBytes := 0
-	for _, frame := range mp3File.Frames {
-		regions, err := mp3parser.AnalyzeFrameData(frame.Header, frame.Data)
-		if err != nil {
-			continue // Skip problematic frames
-		}
-
-		safeBytes := regions.GetSafeModificationBytes()
-		totalSafeBytes += len(safeBytes)
-	}
+	allSafeBytes, _ := collectSafeBytes(mp3File, lsb.config.FrameFilter)
+	totalSafeBytes := len(allSafeBytes)
 
 	if totalSafeBytes == 0 {
 		return 0, fmt.Errorf("no safe ancillary data found in MP3 frames")
 	}
 
-	bitsPerByte := lsb.config.LSBBits
-	totalBits := totalSafeBytes * bitsPerByte
-	capacity := totalBits / 8
-
-	// Reserve space for metadata (filename length + data length)
-	metadataBytes := 8 // 4 bytes for filename length + 4 bytes for data length
-	if capacity < metadataBytes {
-		return 0, fmt.Errorf("insufficient ancillary data for metadata")
-	}
-
-	return capacity - metadataBytes, nil
+	return lsb.payloadCapacity(totalSafeBytes)
 }
 
 func (lsb *MP3AncillaryLSBSteganography) EmbedInMP3(mp3Data []byte, secretData []byte) ([]byte, error) {
-	// Prepare payload: filename length + filename + data length + data
-	filename := []byte(lsb.config.SecretFilename)
-	payload := make([]byte, 0)
-
-	// Add filename length (4 bytes)
-	filenameLen := make([]byte, 4)
-	binary.BigEndian.PutUint32(filenameLen, uint32(len(filename)))
-	payload = append(payload, filenameLen...)
-
-	// Add filename
-	payload = append(payload, filename...)
-
-	// Add data length (4 bytes)
-	dataLen := make([]byte, 4)
-	binary.BigEndian.PutUint32(dataLen, uint32(len(secretData)))
-	payload = append(payload, dataLen...)
-
-	// Add secret data
-	payload = append(payload, secretData...)
-
-	// Encrypt the entire payload if encryption is enabled
-	if lsb.config.UseEncryption {
-		cipher := crypto.NewExtendedVigenere(lsb.config.Key)
-		payload = cipher.Encrypt(payload)
-	}
-
 	// Parse MP3 file
 	mp3File, err := mp3parser.ParseMP3File(mp3Data)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse MP3: %v", err)
-	}
-
-	// Check capacity
-	capacity, err := lsb.CalculateCapacity(mp3Data)
-	if err != nil {
-		return nil, err
-	}
-	if len(payload) > capacity {
-		return nil, fmt.Errorf("secret data too large: %d bytes, capacity: %d bytes", len(payload), capacity)
+		return nil, fmt.Errorf("failed to parse MP3: %w", err)
 	}
 
 	// Collect all safe bytes from all frames
-	allSafeBytes := make([]byte, 0)
-	frameRegions := make([]*mp3parser.MP3FrameRegions, 0)
-
-	for _, frame := range mp3File.Frames {
-		regions, err := mp3parser.AnalyzeFrameData(frame.Header, frame.Data)
-		if err != nil {
-			// Create empty regions for problematic frames
-			regions = &mp3parser.MP3FrameRegions{}
-		}
-
-		frameRegions = append(frameRegions, regions)
-		safeBytes := regions.GetSafeModificationBytes()
-		allSafeBytes = append(allSafeBytes, safeBytes...)
-	}
+	allSafeBytes, frameRegions := collectSafeBytes(mp3File, lsb.config.FrameFilter)
 
 	if len(allSafeBytes) == 0 {
 		return nil, fmt.Errorf("no safe ancillary data available for embedding")
 	}
 
-	// Calculate how many bytes we need based on LSB bits per byte
-	totalPayloadBits := len(payload) * 8
-	bytesNeeded := totalPayloadBits / lsb.config.LSBBits
-	if totalPayloadBits%lsb.config.LSBBits != 0 {
-		bytesNeeded++
+	if err := lsb.embedPayload(allSafeBytes, secretData, lsb.coverID3Checksum(mp3File)); err != nil {
+		return nil, err
 	}
-
-	if bytesNeeded > len(allSafeBytes) {
-		return nil, fmt.Errorf("insufficient safe bytes: need %d, have %d", bytesNeeded, len(allSafeBytes))
+	if lsb.config.StageHook != nil {
+		lsb.config.StageHook("embed")
 	}
 
-	positions := lsb.generatePositions(len(allSafeBytes), bytesNeeded)
-
-	// Convert payload to bits for multi-bit embedding
-	payloadBits := bytesToBits(payload)
-
-	// Embed bits using LSBBits per position
-	mask := byte((1 << lsb.config.LSBBits) - 1)
-	bitIndex := 0
-
-	for _, pos := range positions {
-		if bitIndex >= len(payloadBits) {
-			break
-		}
-
-		// Pack multiple bits into LSB positions
-		var bitsToEmbed byte = 0
-		for j := 0; j < lsb.config.LSBBits && bitIndex < len(payloadBits); j++ {
-			bitsToEmbed |= (payloadBits[bitIndex] << j)
-			bitIndex++
-		}
-
-		// Modify the byte in allSafeBytes
-		allSafeBytes[pos] = (allSafeBytes[pos] & ^mask) | (bitsToEmbed & mask)
-	}
+	// Reconstruct MP3 file
+	return writeSafeBytes(mp3File, frameRegions, allSafeBytes)
+}
 
-	// Put modified safe data back into frames
+// writeSafeBytes puts the modified safe bytes back into their frames and
+// serializes the MP3
+func writeSafeBytes(mp3File *mp3parser.MP3File, frameRegions []*mp3parser.MP3FrameRegions, allSafeBytes []byte) ([]byte, error) {
 	safeByteIndex := 0
 	for i, frame := range mp3File.Frames {
 		regions := frameRegions[i]
@@ -180,144 +119,74 @@ func (lsb *MP3AncillaryLSBSteganography) EmbedInMP3(mp3Data []byte, secretData [
 		}
 	}
 
-	// Reconstruct MP3 file
 	return mp3parser.WriteMP3File(mp3File)
 }
 
 func (lsb *MP3AncillaryLSBSteganography) ExtractFromMP3(mp3Data []byte) ([]byte, string, error) {
+	payload, err := lsb.ExtractPayloadFromMP3(mp3Data)
+	if err != nil {
+		return nil, "", err
+	}
+	return payload.Data, payload.Filename, nil
+}
+
+// ExtractPayloadFromMP3 extracts the secret along with its header metadata
+func (lsb *MP3AncillaryLSBSteganography) ExtractPayloadFromMP3(mp3Data []byte) (*Payload, error) {
 	// Parse MP3 file
 	mp3File, err := mp3parser.ParseMP3File(mp3Data)
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to parse MP3: %v", err)
+		return nil, fmt.Errorf("failed to parse MP3: %w", err)
 	}
 
 	// Collect all safe bytes from all frames
-	allSafeBytes := make([]byte, 0)
-	for _, frame := range mp3File.Frames {
-		regions, err := mp3parser.AnalyzeFrameData(frame.Header, frame.Data)
-		if err != nil {
-			continue // Skip problematic frames
-		}
-
-		safeBytes := regions.GetSafeModificationBytes()
-		allSafeBytes = append(allSafeBytes, safeBytes...)
-	}
+	allSafeBytes, _ := collectSafeBytes(mp3File, lsb.config.FrameFilter)
 
 	if len(allSafeBytes) == 0 {
-		return nil, "", fmt.Errorf("no safe ancillary data found")
+		return nil, fmt.Errorf("no safe ancillary data found")
 	}
 
-	// Generate positions for ALL available safe bytes to get the complete permutation
-	positions := lsb.generatePositions(len(allSafeBytes), len(allSafeBytes))
-	if len(positions) == 0 {
-		return nil, "", fmt.Errorf("no positions generated for extraction")
+	payload, err := lsb.extractPayload(allSafeBytes)
+	if errors.Is(err, ErrNoPayload) {
+		// Files embedded before the preamble existed have no marker
+		payload, err = lsb.extractLegacyPayload(mp3File)
 	}
-
-	// Extract all available bits using the complete position sequence
-	extractedBits := make([]byte, 0)
-	mask := byte((1 << lsb.config.LSBBits) - 1)
-
-	for i := range positions {
-		lsbValue := allSafeBytes[positions[i]] & mask
-		// Unpack bits from this LSB value
-		for j := 0; j < lsb.config.LSBBits; j++ {
-			extractedBits = append(extractedBits, (lsbValue>>j)&1)
-		}
-	}
-
-	// Now parse the extracted bits sequentially
-	extractedBytes := bitsToBytes(extractedBits)
-
-	if len(extractedBytes) < 8 {
-		return nil, "", fmt.Errorf("insufficient extracted data for basic metadata")
-	}
-
-	// Decrypt the entire payload if encryption was used
-	if lsb.config.UseEncryption {
-		cipher := crypto.NewExtendedVigenere(lsb.config.Key)
-		extractedBytes = cipher.Decrypt(extractedBytes)
-	}
-
-	// Parse filename length
-	filenameLen := binary.BigEndian.Uint32(extractedBytes[0:4])
-	if filenameLen > 255 {
-		return nil, "", fmt.Errorf("invalid filename length: %d", filenameLen)
-	}
-
-	if len(extractedBytes) < int(8+filenameLen) {
-		return nil, "", fmt.Errorf("insufficient extracted data for filename")
+	if err != nil {
+		return nil, err
 	}
+	checkID3(payload, mp3File)
+	return payload, nil
+}
 
-	// Parse filename
-	filename := string(extractedBytes[4 : 4+filenameLen])
-
-	// Parse data length
-	dataLen := binary.BigEndian.Uint32(extractedBytes[4+filenameLen : 4+filenameLen+4])
-	if dataLen > 10*1024*1024 { // 10MB sanity check
-		return nil, "", fmt.Errorf("invalid data length: %d", dataLen)
+// CheckKeyInMP3 verifies the key against the preamble without extracting the payload
+func (lsb *MP3AncillaryLSBSteganography) CheckKeyInMP3(mp3Data []byte) error {
+	mp3File, err := mp3parser.ParseMP3File(mp3Data)
+	if err != nil {
+		return fmt.Errorf("failed to parse MP3: %w", err)
 	}
 
-	dataStart := 4 + filenameLen + 4
-	if int(dataStart+dataLen) > len(extractedBytes) {
-		return nil, "", fmt.Errorf("insufficient extracted data: expected %d bytes, got %d", dataLen, len(extractedBytes)-int(dataStart))
+	allSafeBytes, _ := collectSafeBytes(mp3File, lsb.config.FrameFilter)
+	err = lsb.checkKey(allSafeBytes)
+	if errors.Is(err, ErrNoPayload) && lsb.config.CheckLegacy {
+		// The original layout has no key check; the key is right if the
+		// payload parses. That takes a full extraction, so a wrong key is
+		// only this slow when the caller asked for it
+		_, err = lsb.extractLegacyPayload(mp3File)
 	}
-
-	secretData := extractedBytes[dataStart : dataStart+dataLen]
-
-	return secretData, filename, nil
+	return err
 }
 
-func (lsb *MP3AncillaryLSBSteganography) generatePositions(dataLen, bytesNeeded int) []int {
-	positions := make([]int, 0)
-
-	if lsb.config.UseRandomStart {
-		seed := generateSeed(lsb.config.Key)
-		lsb.rng.Seed(seed)
-
-		// Generate a FIXED permutation of all available positions
-		used := make(map[int]bool)
-		allPositions := make([]int, 0, dataLen)
-
-		// Generate the complete random permutation of all available positions
-		for len(allPositions) < dataLen {
-			pos := lsb.rng.Intn(dataLen)
-			if !used[pos] {
-				allPositions = append(allPositions, pos)
-				used[pos] = true
-			}
-		}
-
-		positions = allPositions[:bytesNeeded]
-	} else {
-		for i := 0; i < bytesNeeded && i < dataLen; i++ {
-			positions = append(positions, i)
-		}
+// UpdateHeaderInMP3 rewrites the stored filename and metadata of the embedded
+// payload in place, without re-embedding the secret
+func (lsb *MP3AncillaryLSBSteganography) UpdateHeaderInMP3(mp3Data []byte, update HeaderUpdate) ([]byte, error) {
+	mp3File, err := mp3parser.ParseMP3File(mp3Data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse MP3: %w", err)
 	}
 
-	return positions
-}
-
-func bytesToBits(data []byte) []byte {
-	bits := make([]byte, 0)
-	for _, b := range data {
-		for i := 7; i >= 0; i-- {
-			bits = append(bits, (b>>i)&1)
-		}
+	allSafeBytes, frameRegions := collectSafeBytes(mp3File, lsb.config.FrameFilter)
+	if err := lsb.rewritePayloadHeader(allSafeBytes, update); err != nil {
+		return nil, err
 	}
-	return bits
-}
 
-func bitsToBytes(bits []byte) []byte {
-	bytes := make([]byte, 0)
-	for i := 0; i < len(bits); i += 8 {
-		if i+8 > len(bits) {
-			break
-		}
-		var b byte
-		for j := range 8 {
-			b = (b << 1) | (bits[i+j] & 1)
-		}
-		bytes = append(bytes, b)
-	}
-	return bytes
+	return writeSafeBytes(mp3File, frameRegions, allSafeBytes)
 }
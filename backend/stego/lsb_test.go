@@ -0,0 +1,415 @@
+package stego
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"steganography-backend/audio"
+	"steganography-backend/models"
+	"steganography-backend/mp3parser"
+)
+
+const testCoverPath = "../../test_cases/file_example_MP3_700KB.mp3"
+
+// loadCover returns the test cover cut to its first frames (the LAME Info
+// frame included), keeping the ID3v2 tag
+func loadCover(t testing.TB, frames int) []byte {
+	t.Helper()
+	data, err := os.ReadFile(testCoverPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mp3File, err := mp3parser.ParseMP3File(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mp3File.Frames = mp3File.Frames[:frames]
+	cut, err := mp3parser.WriteMP3File(mp3File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cut
+}
+
+func TestEmbedPreservesGaplessInfo(t *testing.T) {
+	cover := loadCover(t, 200)
+	original, err := mp3parser.ParseMP3File(cover)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoder := audio.NewAudioDecoder()
+	originalPCM, _, err := decoder.DecodeMP3ToPCM(cover)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		config models.StegoConfig
+	}{
+		{name: "ancillary", config: models.StegoConfig{Key: "gapless", LSBBits: 4}},
+		{name: "ancillary random start", config: models.StegoConfig{Key: "gapless", LSBBits: 2, UseRandomStart: true}},
+		{name: "frame LSB", config: models.StegoConfig{Key: "gapless", LSBBits: 1, Method: models.MethodFrameLSB}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method, err := NewMP3Steganography(&tt.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			stegoData, err := method.EmbedInMP3(cover, bytes.Repeat([]byte("gapless "), 40))
+			if err != nil {
+				t.Fatalf("embed: %v", err)
+			}
+
+			embedded, err := mp3parser.ParseMP3File(stegoData)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(embedded.Frames[0].Data, original.Frames[0].Data) {
+				t.Error("the Info frame was modified")
+			}
+			if embedded.Xing == nil || *embedded.Xing != *original.Xing {
+				t.Errorf("LAME tag = %+v, want %+v", embedded.Xing, original.Xing)
+			}
+
+			stegoPCM, _, err := decoder.DecodeMP3ToPCM(stegoData)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(stegoPCM) != len(originalPCM) {
+				t.Errorf("decoded %d PCM bytes, want %d", len(stegoPCM), len(originalPCM))
+			}
+		})
+	}
+}
+
+func TestFrameFilterExcludesEvenFrames(t *testing.T) {
+	cover := loadCover(t, 200)
+	original, err := mp3parser.ParseMP3File(cover)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oddOnly := func(index int, frame *mp3parser.MP3Frame) bool { return index%2 == 1 }
+	secret := bytes.Repeat([]byte("odd frames only "), 8)
+
+	tests := []struct {
+		name   string
+		method string
+	}{
+		{name: "ancillary", method: models.MethodAncillary},
+		{name: "frame LSB", method: models.MethodFrameLSB},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := models.StegoConfig{Key: "filter", LSBBits: 2, UseRandomStart: true, Method: tt.method, FrameFilter: oddOnly}
+			filtered, err := NewMP3Steganography(&config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			unfilteredConfig := config
+			unfilteredConfig.FrameFilter = nil
+			unfiltered, err := NewMP3Steganography(&unfilteredConfig)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			filteredCapacity, err := filtered.CalculateCapacity(cover)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fullCapacity, err := unfiltered.CalculateCapacity(cover)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if filteredCapacity >= fullCapacity {
+				t.Errorf("filtered capacity %d is not below the full capacity %d", filteredCapacity, fullCapacity)
+			}
+
+			stegoData, err := filtered.EmbedInMP3(cover, secret)
+			if err != nil {
+				t.Fatalf("embed: %v", err)
+			}
+			embedded, err := mp3parser.ParseMP3File(stegoData)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < len(original.Frames); i += 2 {
+				if !bytes.Equal(embedded.Frames[i].Data, original.Frames[i].Data) {
+					t.Fatalf("even frame %d was modified", i)
+				}
+			}
+
+			payload, err := filtered.ExtractPayloadFromMP3(stegoData)
+			if err != nil {
+				t.Fatalf("extract: %v", err)
+			}
+			if !bytes.Equal(payload.Data, secret) {
+				t.Error("extracted data differs from the secret")
+			}
+			// The same filter is needed to find the carriers again
+			if payload, err := unfiltered.ExtractPayloadFromMP3(stegoData); err == nil && bytes.Equal(payload.Data, secret) {
+				t.Error("extracted without the filter")
+			}
+		})
+	}
+}
+
+func TestConcurrentEmbedOnOneInstance(t *testing.T) {
+	cover := loadCover(t, 200)
+	const workers = 4
+
+	tests := []struct {
+		name   string
+		config models.StegoConfig
+	}{
+		{name: "ancillary random start", config: models.StegoConfig{Key: "shared", LSBBits: 2, UseRandomStart: true}},
+		{name: "frame LSB random start", config: models.StegoConfig{Key: "shared", LSBBits: 1, UseRandomStart: true, Method: models.MethodFrameLSB}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method, err := NewMP3Steganography(&tt.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			secrets := make([][]byte, workers)
+			results := make([][]byte, workers)
+			errs := make([]error, workers)
+			var wg sync.WaitGroup
+			for i := range workers {
+				secrets[i] = []byte(fmt.Sprintf("secret from worker %d", i))
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					results[i], errs[i] = method.EmbedInMP3(cover, secrets[i])
+				}()
+			}
+			wg.Wait()
+
+			for i := range workers {
+				if errs[i] != nil {
+					t.Fatalf("worker %d: %v", i, errs[i])
+				}
+				// Each embed must match what the instance produces alone
+				alone, err := method.EmbedInMP3(cover, secrets[i])
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(results[i], alone) {
+					t.Errorf("worker %d: concurrent embed differs from a sequential one", i)
+				}
+				payload, err := method.ExtractPayloadFromMP3(results[i])
+				if err != nil || !bytes.Equal(payload.Data, secrets[i]) {
+					t.Errorf("worker %d: extract err = %v", i, err)
+				}
+			}
+		})
+	}
+}
+
+// rawFrame returns a silent MPEG-1 Layer III frame with the given header.
+// Its main data is empty, so everything after the side info is padding.
+func rawFrame(t *testing.T, header uint32) []byte {
+	t.Helper()
+	raw := make([]byte, 1441) // The longest frame, 320 kbps at 32 kHz padded
+	binary.BigEndian.PutUint32(raw, header)
+	frameHeader, _, _, err := mp3parser.ReadFrameHeader(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw[:frameHeader.FrameLength]
+}
+
+// silentFrames returns n parsed silent stereo frames at 128 kbps and 44.1 kHz
+func silentFrames(t *testing.T, n int) []*mp3parser.MP3Frame {
+	t.Helper()
+	frames := make([]*mp3parser.MP3Frame, n)
+	for i := range frames {
+		header, headerBytes, data, err := mp3parser.ReadFrameHeader(bytes.NewReader(rawFrame(t, 0xFFFB9000)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		frames[i] = &mp3parser.MP3Frame{Header: header, HeaderBytes: headerBytes, Data: data}
+	}
+	return frames
+}
+
+func TestUnanalyzableFramesAreSkipped(t *testing.T) {
+	// No MPEG-1 frame read from a file is short enough to fail analysis, so
+	// the frames are cut in memory
+	tests := []struct {
+		name   string
+		length int // Data bytes left in the cut frames
+	}{
+		{name: "too short to analyze", length: 3},
+		{name: "shorter than the side info", length: 20},
+		{name: "exactly the side info", length: 32},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frames := silentFrames(t, 40)
+			cut := []int{0, 7, 39}
+			for _, i := range cut {
+				frames[i].Data = frames[i].Data[:tt.length]
+				if _, err := mp3parser.AnalyzeFrameData(frames[i].Header, frames[i].Data); err == nil {
+					t.Fatalf("a %d-byte frame analyzed", tt.length)
+				}
+			}
+			mp3File := &mp3parser.MP3File{Frames: frames}
+			if got := mp3parser.CountUnanalyzableFrames(mp3File); got != len(cut) {
+				t.Errorf("%d unanalyzable frames, want %d", got, len(cut))
+			}
+
+			// Capacity counts only the frames that analyze
+			safeBytes, regions := collectSafeBytes(mp3File, nil)
+			intact, _ := collectSafeBytes(&mp3parser.MP3File{Frames: silentFrames(t, len(frames)-len(cut))}, nil)
+			if len(safeBytes) != len(intact) {
+				t.Errorf("%d safe bytes, want %d from the intact frames only", len(safeBytes), len(intact))
+			}
+			for _, i := range cut {
+				if len(regions[i].GetSafeModificationBytes()) != 0 {
+					t.Errorf("cut frame %d has safe bytes", i)
+				}
+			}
+
+			config := models.StegoConfig{Key: "unanalyzable", LSBBits: 2}
+			codec := NewMP3AncillaryLSBSteganography(&config)
+			secret := bytes.Repeat([]byte("skipped "), 200)
+			if err := codec.embedPayload(safeBytes, secret, nil); err != nil {
+				t.Fatalf("embed: %v", err)
+			}
+			if _, err := writeSafeBytes(mp3File, regions, safeBytes); err != nil {
+				t.Fatal(err)
+			}
+			for _, i := range cut {
+				if !bytes.Equal(frames[i].Data, make([]byte, tt.length)) {
+					t.Errorf("cut frame %d was modified", i)
+				}
+			}
+
+			// Extraction skips the same frames and finds the same carriers
+			embedded, _ := collectSafeBytes(mp3File, nil)
+			payload, err := codec.extractPayload(embedded)
+			if err != nil {
+				t.Fatalf("extract: %v", err)
+			}
+			if !bytes.Equal(payload.Data, secret) {
+				t.Error("extracted data differs from the secret")
+			}
+		})
+	}
+}
+
+func TestTaglessAndShortCovers(t *testing.T) {
+	tagged, err := mp3parser.ParseMP3File(loadCover(t, 200))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tagged.ID3v2, tagged.ID3v2Data = nil, nil
+	tagless, err := mp3parser.WriteMP3File(tagged)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	method, err := NewMP3Steganography(&models.StegoConfig{Key: "tagless", LSBBits: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("tagless", func(t *testing.T) {
+		reparsed, err := mp3parser.ParseMP3File(tagless)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rewritten, err := mp3parser.WriteMP3File(reparsed); err != nil || !bytes.Equal(rewritten, tagless) {
+			t.Errorf("rewrite is not byte-identical (err %v)", err)
+		}
+
+		secret := []byte("no tags at all")
+		stegoData, err := method.EmbedInMP3(tagless, secret)
+		if err != nil {
+			t.Fatalf("embed: %v", err)
+		}
+		if len(stegoData) != len(tagless) || bytes.HasPrefix(stegoData, []byte("ID3")) {
+			t.Errorf("stego file is %d bytes, want %d without a tag", len(stegoData), len(tagless))
+		}
+		data, _, err := method.ExtractFromMP3(stegoData)
+		if err != nil || !bytes.Equal(data, secret) {
+			t.Errorf("extracted %q, err = %v", data, err)
+		}
+	})
+
+	short := []struct {
+		name string
+		data []byte
+	}{
+		{name: "empty", data: nil},
+		{name: "tag prefix only", data: []byte("ID3")},
+		{name: "a few bytes", data: []byte{0xFF, 0xFB, 0x90}},
+		{name: "one frame header", data: tagless[:4]},
+	}
+	for _, tt := range short {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := method.CalculateCapacity(tt.data); err == nil || !strings.Contains(err.Error(), "no safe ancillary data") {
+				t.Errorf("capacity err = %v, want no safe ancillary data", err)
+			}
+			if _, err := method.EmbedInMP3(tt.data, []byte("x")); err == nil || !strings.Contains(err.Error(), "no safe ancillary data") {
+				t.Errorf("embed err = %v, want no safe ancillary data", err)
+			}
+		})
+	}
+}
+
+func TestUpdateHeaderKeepsData(t *testing.T) {
+	cover := loadCover(t, 200)
+	secret := bytes.Repeat([]byte("renamed "), 30)
+
+	tests := []struct {
+		name     string
+		config   models.StegoConfig
+		filename string
+	}{
+		{name: "ancillary, longer name", config: models.StegoConfig{Key: "rename", LSBBits: 2, SecretFilename: "a.txt"}, filename: "quarterly-report.txt"},
+		{name: "ancillary random start, shorter name", config: models.StegoConfig{Key: "rename", LSBBits: 1, UseRandomStart: true, SecretFilename: "original-name.bin"}, filename: "b"},
+		{name: "ancillary encrypted, name removed", config: models.StegoConfig{Key: "rename", LSBBits: 3, UseEncryption: true, SecretFilename: "clear.txt"}, filename: ""},
+		{name: "frame LSB", config: models.StegoConfig{Key: "rename", LSBBits: 1, Method: models.MethodFrameLSB, SecretFilename: "a.txt"}, filename: "frame.txt"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method, err := NewMP3Steganography(&tt.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			stegoData, err := method.EmbedInMP3(cover, secret)
+			if err != nil {
+				t.Fatalf("embed: %v", err)
+			}
+
+			updated, err := method.UpdateHeaderInMP3(stegoData, HeaderUpdate{Filename: &tt.filename})
+			if err != nil {
+				t.Fatalf("update: %v", err)
+			}
+			data, filename, err := method.ExtractFromMP3(updated)
+			if err != nil {
+				t.Fatalf("extract: %v", err)
+			}
+			if filename != tt.filename {
+				t.Errorf("filename = %q, want %q", filename, tt.filename)
+			}
+			if !bytes.Equal(data, secret) {
+				t.Error("extracted data changed with the filename")
+			}
+		})
+	}
+}
@@ -0,0 +1,34 @@
+package stego
+
+import (
+	"fmt"
+
+	"steganography-backend/models"
+)
+
+// MP3Steganography is implemented by every MP3 embedding method
+type MP3Steganography interface {
+	CalculateCapacity(mp3Data []byte) (int, error)
+	EmbedInMP3(mp3Data []byte, secretData []byte) ([]byte, error)
+	ExtractFromMP3(mp3Data []byte) ([]byte, string, error)
+	ExtractPayloadFromMP3(mp3Data []byte) (*Payload, error)
+	CheckKeyInMP3(mp3Data []byte) error
+	UpdateHeaderInMP3(mp3Data []byte, update HeaderUpdate) ([]byte, error)
+}
+
+// NewMP3Steganography returns the embedding method selected by the config
+func NewMP3Steganography(config *models.StegoConfig) (MP3Steganography, error) {
+	switch config.Method {
+	case "", models.MethodAncillary:
+		return NewMP3AncillaryLSBSteganography(config), nil
+	case models.MethodFrameLSB:
+		if err := ValidateFrameReservation(config.FrameReservation, config.FrameReservedBytes); err != nil {
+			return nil, err
+		}
+		return NewMP3LSBSteganography(config), nil
+	case models.MethodAuto:
+		return newAutoSteganography(config)
+	default:
+		return nil, fmt.Errorf("unknown embedding method: %q", config.Method)
+	}
+}
@@ -0,0 +1,128 @@
+package stego
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+
+	"steganography-backend/models"
+)
+
+// MaxParts bounds the number of files a secret can be split across
+const MaxParts = 256
+
+// Encoded part manifest: index (2 bytes) + count (2 bytes) + SHA-256 (32 bytes)
+const partManifestBytes = 2 + 2 + sha256.Size
+
+// ValidatePart checks that a part manifest describes a valid position in a set
+func ValidatePart(part *models.PartManifest) error {
+	if part.Count < 1 || part.Count > MaxParts {
+		return fmt.Errorf("part count must be between 1 and %d", MaxParts)
+	}
+	if part.Index < 0 || part.Index >= part.Count {
+		return fmt.Errorf("part index must be between 0 and %d", part.Count-1)
+	}
+	return nil
+}
+
+func encodePart(part *models.PartManifest) []byte {
+	encoded := make([]byte, 0, partManifestBytes)
+	encoded = binary.BigEndian.AppendUint16(encoded, uint16(part.Index))
+	encoded = binary.BigEndian.AppendUint16(encoded, uint16(part.Count))
+	return append(encoded, part.SHA256[:]...)
+}
+
+// decodePart returns nil for a malformed manifest so the chunk still extracts
+// on its own
+func decodePart(value []byte) *models.PartManifest {
+	if len(value) != partManifestBytes {
+		return nil
+	}
+
+	part := &models.PartManifest{
+		Index: int(binary.BigEndian.Uint16(value[0:2])),
+		Count: int(binary.BigEndian.Uint16(value[2:4])),
+	}
+	copy(part.SHA256[:], value[4:])
+	if ValidatePart(part) != nil {
+		return nil
+	}
+	return part
+}
+
+// AssembleParts orders the payloads extracted from every file of a split
+// secret by their part index and joins their chunks. The set must be
+// complete, without duplicates, and hash to the SHA-256 in the manifest. The
+// returned payload keeps the filename, MIME type and metadata of the first
+// part.
+func AssembleParts(payloads []*Payload) (*Payload, error) {
+	if len(payloads) == 0 {
+		return nil, fmt.Errorf("no parts provided")
+	}
+
+	for i, payload := range payloads {
+		if payload.Part == nil {
+			return nil, fmt.Errorf("file %d does not hold a part of a split secret", i+1)
+		}
+	}
+
+	manifest := payloads[0].Part
+	byIndex := make(map[int]*Payload, len(payloads))
+	for i, payload := range payloads {
+		if payload.Part.Count != manifest.Count || payload.Part.SHA256 != manifest.SHA256 {
+			return nil, fmt.Errorf("file %d belongs to a different split secret than file 1", i+1)
+		}
+		if _, ok := byIndex[payload.Part.Index]; ok {
+			return nil, fmt.Errorf("part %d of %d was provided more than once", payload.Part.Index+1, manifest.Count)
+		}
+		byIndex[payload.Part.Index] = payload
+	}
+
+	missing := make([]string, 0)
+	for index := 0; index < manifest.Count; index++ {
+		if _, ok := byIndex[index]; !ok {
+			missing = append(missing, fmt.Sprintf("%d", index+1))
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("missing part(s) %s of %d", strings.Join(missing, ", "), manifest.Count)
+	}
+
+	indices := make([]int, 0, len(byIndex))
+	for index := range byIndex {
+		indices = append(indices, index)
+	}
+	sort.Ints(indices)
+
+	data := make([]byte, 0)
+	for _, index := range indices {
+		data = append(data, byIndex[index].Data...)
+	}
+	if sha256.Sum256(data) != manifest.SHA256 {
+		return nil, fmt.Errorf("reassembled secret does not match the SHA-256 in the part manifest")
+	}
+
+	warnings := make([]string, 0)
+	for _, index := range indices {
+		for _, warning := range byIndex[index].Warnings {
+			warnings = append(warnings, fmt.Sprintf("part %d: %s", index+1, warning))
+		}
+	}
+
+	first := byIndex[0]
+	return &Payload{
+		Filename: first.Filename,
+		Metadata: first.Metadata,
+		MIMEType: first.MIMEType,
+		FileID:   first.FileID,
+		Expires:  first.Expires,
+		Part:     manifest,
+		Data:     data,
+		SeedHash: first.SeedHash,
+		Salt:     first.Salt,
+		Method:   first.Method,
+		Warnings: warnings,
+	}, nil
+}
@@ -0,0 +1,98 @@
+package stego
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"strings"
+	"testing"
+
+	"steganography-backend/models"
+)
+
+// splitPayloads splits secret into count chunks as extraction would return them
+func splitPayloads(secret []byte, count int) []*Payload {
+	hash := sha256.Sum256(secret)
+	payloads := make([]*Payload, count)
+	for i := range payloads {
+		start, end := i*len(secret)/count, (i+1)*len(secret)/count
+		payloads[i] = &Payload{
+			Filename: "whole.bin",
+			Data:     secret[start:end],
+			Part:     &models.PartManifest{Index: i, Count: count, SHA256: hash},
+		}
+	}
+	return payloads
+}
+
+func TestAssembleParts(t *testing.T) {
+	secret := []byte("a secret spread over several files")
+	parts := splitPayloads(secret, 3)
+	other := splitPayloads([]byte("another secret entirely"), 3)
+	tampered := *parts[1]
+	tampered.Data = []byte("XXXXXXXXXXX")
+
+	tests := []struct {
+		name     string
+		payloads []*Payload
+		wantErr  string
+	}{
+		{name: "in order", payloads: []*Payload{parts[0], parts[1], parts[2]}},
+		{name: "shuffled", payloads: []*Payload{parts[2], parts[0], parts[1]}},
+		{name: "missing part", payloads: []*Payload{parts[0], parts[2]}, wantErr: "missing part(s) 2 of 3"},
+		{name: "duplicate part", payloads: []*Payload{parts[0], parts[1], parts[1], parts[2]}, wantErr: "part 2 of 3 was provided more than once"},
+		{name: "mixed sets", payloads: []*Payload{parts[0], other[1], parts[2]}, wantErr: "different split secret"},
+		{name: "not a part", payloads: []*Payload{parts[0], {Data: []byte("x")}}, wantErr: "does not hold a part"},
+		{name: "tampered chunk", payloads: []*Payload{parts[0], &tampered, parts[2]}, wantErr: "does not match the SHA-256"},
+		{name: "none", payloads: nil, wantErr: "no parts provided"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload, err := AssembleParts(tt.payloads)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("assemble: %v", err)
+			}
+			if !bytes.Equal(payload.Data, secret) || payload.Filename != "whole.bin" {
+				t.Errorf("assembled %q as %q", payload.Data, payload.Filename)
+			}
+		})
+	}
+}
+
+func TestPartManifestRoundTrip(t *testing.T) {
+	cover := loadCover(t, 200)
+	secret := []byte("split across three covers")
+	chunks := splitPayloads(secret, 3)
+
+	extracted := make([]*Payload, 0, len(chunks))
+	for _, chunk := range chunks {
+		config := models.StegoConfig{Key: "parts", LSBBits: 2, UseEncryption: true, SecretFilename: chunk.Filename, Part: chunk.Part}
+		method := NewMP3AncillaryLSBSteganography(&config)
+		stegoData, err := method.EmbedInMP3(cover, chunk.Data)
+		if err != nil {
+			t.Fatalf("embed part %d: %v", chunk.Part.Index, err)
+		}
+		payload, err := method.ExtractPayloadFromMP3(stegoData)
+		if err != nil {
+			t.Fatalf("extract part %d: %v", chunk.Part.Index, err)
+		}
+		if payload.Part == nil || *payload.Part != *chunk.Part {
+			t.Fatalf("part %d: manifest = %+v, want %+v", chunk.Part.Index, payload.Part, chunk.Part)
+		}
+		extracted = append([]*Payload{payload}, extracted...)
+	}
+
+	payload, err := AssembleParts(extracted)
+	if err != nil {
+		t.Fatalf("assemble: %v", err)
+	}
+	if !bytes.Equal(payload.Data, secret) {
+		t.Errorf("assembled %q, want %q", payload.Data, secret)
+	}
+}
@@ -0,0 +1,409 @@
+package stego
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"steganography-backend/crypto"
+	"steganography-backend/models"
+)
+
+const (
+	// MaxMetadataBytes bounds the optional JSON metadata stored with the secret
+	MaxMetadataBytes = 4096
+
+	// DefaultMaxFilenameBytes bounds the stored filename of the secret unless
+	// StegoConfig.MaxFilenameBytes sets another limit
+	DefaultMaxFilenameBytes = 255
+
+	// MaxFilenameBytes is the largest filename limit a config may set
+	MaxFilenameBytes = 4096
+
+	// MaxFileIDBytes bounds the optional tracking ID stored with the secret
+	MaxFileIDBytes = 64
+
+	// MaxMIMETypeBytes bounds the stored MIME type of the secret
+	MaxMIMETypeBytes = 255
+
+	// DefaultMIMEType is used when the secret's type is unknown
+	DefaultMIMEType = "application/octet-stream"
+
+	// Fixed header fields: filename length + extensions length + data length
+	payloadFixedHeaderBytes = 12
+
+	maxExtensionsBytes = 64 * 1024
+
+	// MaxSecretBytes is the sanity cap on the data length declared in a
+	// payload header; callers may lower it with StegoConfig.MaxOutputBytes
+	MaxSecretBytes = 10 * 1024 * 1024
+)
+
+// Extension tags stored in the payload header
+const (
+	extensionMetadata byte = 1 // Caller supplied JSON metadata
+	extensionMIMEType byte = 2 // MIME type of the secret
+	extensionFileID   byte = 3 // Tracking ID of this copy
+	extensionExpiry   byte = 4 // Expiry as Unix seconds (8 bytes)
+	extensionPart     byte = 5 // Part manifest of a split secret
+	extensionID3      byte = 6 // SHA-256 of the cover's ID3v2 tag
+)
+
+// Payload is an extracted secret together with the metadata framed around it
+type Payload struct {
+	Filename string
+	Metadata json.RawMessage      // nil when no metadata was embedded
+	MIMEType string               // Empty when no valid type was embedded
+	FileID   string               // Tracking ID, empty when none was embedded
+	Expires  time.Time            // Zero when the payload does not expire
+	Part     *models.PartManifest // nil unless the secret is one part of a split secret
+	Data     []byte
+
+	ID3Checksum []byte // Cover tag checksum stored at embed time, nil when none
+	Retagged    bool   // The file's ID3v2 tag no longer matches ID3Checksum
+
+	// Parameters recorded in the preamble
+	SeedHash string
+	Salt     string
+
+	Method    string // Method found by automatic detection, empty otherwise
+	Placement string // Placement found by automatic detection, empty otherwise
+
+	Warnings []string // Non-fatal caveats noticed during extraction
+}
+
+// HeaderUpdate lists the payload header fields to replace when rewriting
+// an embedded payload; nil fields keep their stored value
+type HeaderUpdate struct {
+	Filename *string
+	Metadata json.RawMessage
+}
+
+// TruncatedPayloadError is returned when the payload header parses but the
+// secret data runs past the end of the extracted bytes. It carries what the
+// header promised so callers can still report the intended file.
+type TruncatedPayloadError struct {
+	Filename     string
+	ExpectedSize int // Secret size recorded in the header
+	Available    int // Secret bytes actually present
+}
+
+func (e *TruncatedPayloadError) Error() string {
+	return fmt.Sprintf("insufficient extracted data: expected %d bytes, got %d", e.ExpectedSize, e.Available)
+}
+
+// ExpiredPayloadError is returned when the payload's expiry has passed and
+// the config does not ask to ignore it
+type ExpiredPayloadError struct {
+	Expires time.Time
+}
+
+func (e *ExpiredPayloadError) Error() string {
+	return fmt.Sprintf("payload expired at %s", e.Expires.UTC().Format(time.RFC3339))
+}
+
+// OutputLimitError is returned when the data length declared in a payload
+// header exceeds the extraction output limit
+type OutputLimitError struct {
+	Declared int
+	Limit    int
+}
+
+func (e *OutputLimitError) Error() string {
+	return fmt.Sprintf("declared secret size %d bytes exceeds the output limit of %d bytes", e.Declared, e.Limit)
+}
+
+// buildPayload frames the secret together with the filename, metadata and
+// MIME type from the config and the cover's tag checksum
+func (lsb *lsbCodec) buildPayload(secretData []byte, id3Checksum []byte) []byte {
+	return lsb.encodePayload(&Payload{
+		Filename: lsb.config.SecretFilename,
+		Metadata: lsb.config.Metadata,
+		MIMEType: lsb.config.SecretMIMEType,
+		FileID:   lsb.config.FileID,
+		Expires:  lsb.config.Expires,
+		Part:     lsb.config.Part,
+		Data:     secretData,
+
+		ID3Checksum: id3Checksum,
+	})
+}
+
+// encodePayload frames a payload as
+// filename length + filename + extensions length + extensions + data length + data,
+// encrypting the whole payload when encryption is enabled. Extensions are
+// optional header fields, each stored as tag (1 byte) + length (2 bytes) + value.
+func (lsb *lsbCodec) encodePayload(secret *Payload) []byte {
+	filename := []byte(secret.Filename)
+	extensions := buildExtensions(secret)
+	payload := make([]byte, 0)
+
+	// Add filename length (4 bytes)
+	filenameLen := make([]byte, 4)
+	binary.BigEndian.PutUint32(filenameLen, uint32(len(filename)))
+	payload = append(payload, filenameLen...)
+
+	// Add filename
+	payload = append(payload, filename...)
+
+	// Add extensions length (4 bytes) and extensions
+	extensionsLen := make([]byte, 4)
+	binary.BigEndian.PutUint32(extensionsLen, uint32(len(extensions)))
+	payload = append(payload, extensionsLen...)
+	payload = append(payload, extensions...)
+
+	// Add data length (4 bytes)
+	dataLen := make([]byte, 4)
+	binary.BigEndian.PutUint32(dataLen, uint32(len(secret.Data)))
+	payload = append(payload, dataLen...)
+
+	// Add secret data
+	payload = append(payload, secret.Data...)
+
+	// Encrypt the entire payload if encryption is enabled
+	if lsb.config.UseEncryption {
+		cipher := crypto.NewExtendedVigenere(lsb.config.Key)
+		payload = cipher.Encrypt(payload)
+	}
+
+	return payload
+}
+
+func buildExtensions(secret *Payload) []byte {
+	extensions := make([]byte, 0)
+	if len(secret.Metadata) > 0 {
+		extensions = appendExtension(extensions, extensionMetadata, secret.Metadata)
+	}
+	if mimeType, ok := SanitizeMIMEType(secret.MIMEType); ok {
+		extensions = appendExtension(extensions, extensionMIMEType, []byte(mimeType))
+	}
+	if secret.FileID != "" {
+		extensions = appendExtension(extensions, extensionFileID, []byte(secret.FileID))
+	}
+	if !secret.Expires.IsZero() {
+		extensions = appendExtension(extensions, extensionExpiry, binary.BigEndian.AppendUint64(nil, uint64(secret.Expires.Unix())))
+	}
+	if secret.Part != nil {
+		extensions = appendExtension(extensions, extensionPart, encodePart(secret.Part))
+	}
+	if secret.ID3Checksum != nil {
+		extensions = appendExtension(extensions, extensionID3, secret.ID3Checksum)
+	}
+	return extensions
+}
+
+func appendExtension(extensions []byte, tag byte, value []byte) []byte {
+	extensions = append(extensions, tag)
+	extensions = binary.BigEndian.AppendUint16(extensions, uint16(len(value)))
+	return append(extensions, value...)
+}
+
+// parsePayload decrypts the extracted bytes if needed and unframes the secret
+func (lsb *lsbCodec) parsePayload(extractedBytes []byte) (*Payload, error) {
+	if len(extractedBytes) < payloadFixedHeaderBytes {
+		return nil, fmt.Errorf("insufficient extracted data for basic metadata")
+	}
+
+	// Decrypt the entire payload if encryption was used
+	if lsb.config.UseEncryption {
+		cipher := crypto.NewExtendedVigenere(lsb.config.Key)
+		extractedBytes = cipher.Decrypt(extractedBytes)
+	}
+
+	// Parse filename length
+	filenameLen := int(binary.BigEndian.Uint32(extractedBytes[0:4]))
+	if filenameLen > lsb.filenameLimit() {
+		return nil, fmt.Errorf("invalid filename length: %d (limit %d)", filenameLen, lsb.filenameLimit())
+	}
+
+	if len(extractedBytes) < payloadFixedHeaderBytes+filenameLen {
+		return nil, fmt.Errorf("insufficient extracted data for filename")
+	}
+
+	// Parse filename
+	payload := &Payload{Filename: string(extractedBytes[4 : 4+filenameLen])}
+
+	// Parse extensions
+	extensionsStart := 4 + filenameLen + 4
+	extensionsLen := int(binary.BigEndian.Uint32(extractedBytes[4+filenameLen : extensionsStart]))
+	if extensionsLen > maxExtensionsBytes {
+		return nil, fmt.Errorf("invalid extensions length: %d", extensionsLen)
+	}
+	if len(extractedBytes) < payloadFixedHeaderBytes+filenameLen+extensionsLen {
+		return nil, fmt.Errorf("insufficient extracted data for extensions")
+	}
+	if err := payload.parseExtensions(extractedBytes[extensionsStart : extensionsStart+extensionsLen]); err != nil {
+		return nil, err
+	}
+
+	// Parse data length
+	dataLenStart := extensionsStart + extensionsLen
+	dataLen := binary.BigEndian.Uint32(extractedBytes[dataLenStart : dataLenStart+4])
+	if limit := lsb.outputLimit(); uint64(dataLen) > uint64(limit) {
+		return nil, &OutputLimitError{Declared: int(dataLen), Limit: limit}
+	}
+
+	dataStart := dataLenStart + 4
+	if dataStart+int(dataLen) > len(extractedBytes) {
+		return nil, &TruncatedPayloadError{
+			Filename:     payload.Filename,
+			ExpectedSize: int(dataLen),
+			Available:    len(extractedBytes) - dataStart,
+		}
+	}
+
+	payload.Data = extractedBytes[dataStart : dataStart+int(dataLen)]
+
+	return payload, nil
+}
+
+// filenameLimit returns the longest filename embedding and extraction accept:
+// the caller's MaxFilenameBytes when set, otherwise the default
+func (lsb *lsbCodec) filenameLimit() int {
+	if lsb.config.MaxFilenameBytes > 0 {
+		return lsb.config.MaxFilenameBytes
+	}
+	return DefaultMaxFilenameBytes
+}
+
+// ValidateMaxFilenameBytes checks a filename limit; 0 keeps the default
+func ValidateMaxFilenameBytes(limit int) error {
+	if limit < 0 || limit > MaxFilenameBytes {
+		return fmt.Errorf("filename limit must be between 1 and %d bytes", MaxFilenameBytes)
+	}
+	return nil
+}
+
+// outputLimit returns the largest secret extraction accepts: the sanity cap,
+// lowered to the caller's MaxOutputBytes when set
+func (lsb *lsbCodec) outputLimit() int {
+	if lsb.config.MaxOutputBytes > 0 && lsb.config.MaxOutputBytes < MaxSecretBytes {
+		return lsb.config.MaxOutputBytes
+	}
+	return MaxSecretBytes
+}
+
+func (payload *Payload) parseExtensions(extensions []byte) error {
+	for len(extensions) > 0 {
+		if len(extensions) < 3 {
+			return fmt.Errorf("truncated payload extension")
+		}
+
+		tag := extensions[0]
+		valueLen := int(binary.BigEndian.Uint16(extensions[1:3]))
+		if len(extensions) < 3+valueLen {
+			return fmt.Errorf("truncated payload extension %d", tag)
+		}
+		value := extensions[3 : 3+valueLen]
+		extensions = extensions[3+valueLen:]
+
+		// Unknown tags are skipped so newer files still extract
+		switch tag {
+		case extensionMetadata:
+			payload.Metadata = json.RawMessage(value)
+		case extensionMIMEType:
+			// The type ends up in a response header, so never trust it as stored
+			if mimeType, ok := SanitizeMIMEType(string(value)); ok {
+				payload.MIMEType = mimeType
+			}
+		case extensionFileID:
+			if ValidateFileID(string(value)) == nil {
+				payload.FileID = string(value)
+			}
+		case extensionExpiry:
+			if len(value) == 8 {
+				payload.Expires = time.Unix(int64(binary.BigEndian.Uint64(value)), 0).UTC()
+			}
+		case extensionPart:
+			payload.Part = decodePart(value)
+		case extensionID3:
+			if len(value) == sha256.Size {
+				payload.ID3Checksum = value
+			}
+		}
+	}
+
+	return nil
+}
+
+// ValidateMetadata checks that metadata is a JSON object within the size limit
+func ValidateMetadata(metadata []byte) error {
+	if len(metadata) > MaxMetadataBytes {
+		return fmt.Errorf("metadata cannot exceed %d bytes", MaxMetadataBytes)
+	}
+
+	var object map[string]any
+	if err := json.Unmarshal(metadata, &object); err != nil || object == nil {
+		return fmt.Errorf("metadata must be a JSON object")
+	}
+
+	return nil
+}
+
+// ValidateFileID checks that a tracking ID is non-empty printable ASCII
+// within the size limit, so it can be returned in a response header
+func ValidateFileID(fileID string) error {
+	if fileID == "" || len(fileID) > MaxFileIDBytes {
+		return fmt.Errorf("file ID must be between 1 and %d bytes", MaxFileIDBytes)
+	}
+	for i := 0; i < len(fileID); i++ {
+		if fileID[i] < 0x21 || fileID[i] > 0x7E {
+			return fmt.Errorf("file ID must be printable ASCII without spaces")
+		}
+	}
+	return nil
+}
+
+// fileIDSource supplies the random bytes of generated file IDs; tests swap
+// it for a fixed stream to get repeatable IDs
+var fileIDSource io.Reader = rand.Reader
+
+// NewFileID generates a random (version 4) UUID to use as a tracking ID
+func NewFileID() (string, error) {
+	var id [16]byte
+	if _, err := io.ReadFull(fileIDSource, id[:]); err != nil {
+		return "", fmt.Errorf("failed to generate file ID: %v", err)
+	}
+	id[6] = id[6]&0x0F | 0x40 // Version 4
+	id[8] = id[8]&0x3F | 0x80 // RFC 4122 variant
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:16]), nil
+}
+
+// DetectMIMEType returns the MIME type of a secret, preferring the type
+// registered for its file extension and falling back to sniffing the content
+func DetectMIMEType(filename string, data []byte) string {
+	if mimeType, ok := SanitizeMIMEType(mime.TypeByExtension(filepath.Ext(filename))); ok {
+		return mimeType
+	}
+
+	mimeType, _ := SanitizeMIMEType(http.DetectContentType(data))
+	return mimeType
+}
+
+// SanitizeMIMEType parses a MIME type and returns it in canonical form. It
+// reports false for malformed, oversized or generic octet-stream types.
+func SanitizeMIMEType(mimeType string) (string, bool) {
+	if mimeType == "" || len(mimeType) > MaxMIMETypeBytes {
+		return "", false
+	}
+
+	mediaType, params, err := mime.ParseMediaType(mimeType)
+	if err != nil || !strings.Contains(mediaType, "/") || mediaType == DefaultMIMEType {
+		return "", false
+	}
+
+	sanitized := mime.FormatMediaType(mediaType, params)
+	if sanitized == "" || len(sanitized) > MaxMIMETypeBytes {
+		return "", false
+	}
+	return sanitized, true
+}
@@ -0,0 +1,392 @@
+package stego
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"steganography-backend/models"
+)
+
+func TestMetadataRoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		metadata string
+	}{
+		{name: "flat", metadata: `{"recipient":"alice","copy":3}`},
+		{name: "whitespace and key order kept", metadata: "{ \"z\": 1,\n  \"a\": [true, null] }"},
+		{name: "nested unicode", metadata: `{"note":"héllo ✓","tags":{"a":["x","y"]}}`},
+		{name: "at the size limit", metadata: `{"pad":"` + strings.Repeat("x", MaxMetadataBytes-10) + `"}`},
+	}
+
+	for _, tt := range tests {
+		for _, encrypted := range []bool{false, true} {
+			name := tt.name
+			if encrypted {
+				name += "/encrypted"
+			}
+			t.Run(name, func(t *testing.T) {
+				config := models.StegoConfig{Key: "metadata", LSBBits: 1, UseEncryption: encrypted, Metadata: json.RawMessage(tt.metadata)}
+				if err := ValidateMetadata(config.Metadata); err != nil {
+					t.Fatalf("validate: %v", err)
+				}
+				codec := newLSBCodec(&config)
+
+				framed := codec.buildPayload([]byte("secret"), nil)
+				if encrypted && bytes.Contains(framed, []byte(tt.metadata)) {
+					t.Error("metadata stored in the clear with encryption enabled")
+				}
+				payload, err := codec.parsePayload(framed)
+				if err != nil {
+					t.Fatalf("parse: %v", err)
+				}
+				if string(payload.Metadata) != tt.metadata {
+					t.Errorf("metadata = %s, want %s", payload.Metadata, tt.metadata)
+				}
+				if string(payload.Data) != "secret" {
+					t.Errorf("data = %q", payload.Data)
+				}
+			})
+		}
+	}
+}
+
+func TestMetadataThroughMP3(t *testing.T) {
+	cover := loadCover(t, 200)
+	metadata := json.RawMessage(`{"provenance":{"by":"newsroom","at":"2026-01-02"}}`)
+
+	for _, name := range []string{models.MethodAncillary, models.MethodFrameLSB} {
+		t.Run(name, func(t *testing.T) {
+			config := models.StegoConfig{Key: "metadata", LSBBits: 2, UseEncryption: true, Method: name, Metadata: metadata}
+			method, err := NewMP3Steganography(&config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			stegoData, err := method.EmbedInMP3(cover, []byte("with provenance"))
+			if err != nil {
+				t.Fatalf("embed: %v", err)
+			}
+			payload, err := method.ExtractPayloadFromMP3(stegoData)
+			if err != nil {
+				t.Fatalf("extract: %v", err)
+			}
+			if !bytes.Equal(payload.Metadata, metadata) {
+				t.Errorf("metadata = %s, want %s", payload.Metadata, metadata)
+			}
+		})
+	}
+}
+
+func TestValidateMetadata(t *testing.T) {
+	tests := []struct {
+		name     string
+		metadata string
+		wantErr  bool
+	}{
+		{name: "object", metadata: `{"a":1}`},
+		{name: "empty object", metadata: `{}`},
+		{name: "array", metadata: `[1,2]`, wantErr: true},
+		{name: "string", metadata: `"text"`, wantErr: true},
+		{name: "null", metadata: `null`, wantErr: true},
+		{name: "malformed", metadata: `{"a":`, wantErr: true},
+		{name: "too large", metadata: `{"pad":"` + strings.Repeat("x", MaxMetadataBytes) + `"}`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateMetadata([]byte(tt.metadata))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseExtensions(t *testing.T) {
+	tests := []struct {
+		name         string
+		extensions   []byte
+		wantMetadata string
+		wantErr      bool
+	}{
+		{
+			name:         "metadata only",
+			extensions:   appendExtension(nil, extensionMetadata, []byte(`{"a":1}`)),
+			wantMetadata: `{"a":1}`,
+		},
+		{
+			name:         "unknown tag skipped",
+			extensions:   appendExtension(appendExtension(nil, 200, []byte("future")), extensionMetadata, []byte(`{"b":2}`)),
+			wantMetadata: `{"b":2}`,
+		},
+		{
+			name:       "truncated tag header",
+			extensions: []byte{extensionMetadata, 0},
+			wantErr:    true,
+		},
+		{
+			name:       "value past the end",
+			extensions: appendExtension(nil, extensionMetadata, []byte(`{"a":1}`))[:6],
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload := &Payload{}
+			err := payload.parseExtensions(tt.extensions)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if string(payload.Metadata) != tt.wantMetadata {
+				t.Errorf("metadata = %s, want %s", payload.Metadata, tt.wantMetadata)
+			}
+		})
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+
+	tests := []struct {
+		name         string
+		expires      time.Time
+		ignoreExpiry bool
+		wantExpired  bool
+	}{
+		{name: "no expiry"},
+		{name: "before expiry", expires: now.Add(time.Hour)},
+		{name: "after expiry", expires: now.Add(-time.Hour), wantExpired: true},
+		{name: "after expiry, ignored", expires: now.Add(-time.Hour), ignoreExpiry: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := models.StegoConfig{Key: "ephemeral", LSBBits: 2, UseEncryption: true, Expires: tt.expires}
+			carriers := randomCarriers(4000)
+			if err := newLSBCodec(&config).embedPayload(carriers, []byte("short-lived"), nil); err != nil {
+				t.Fatalf("embed: %v", err)
+			}
+
+			extract := models.StegoConfig{Key: "ephemeral", LSBBits: 2, UseEncryption: true, IgnoreExpiry: tt.ignoreExpiry}
+			payload, err := newLSBCodec(&extract).extractPayload(carriers)
+			var expired *ExpiredPayloadError
+			if tt.wantExpired {
+				if !errors.As(err, &expired) || !expired.Expires.Equal(tt.expires) {
+					t.Fatalf("err = %v, want expiry at %s", err, tt.expires)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("extract: %v", err)
+			}
+			if string(payload.Data) != "short-lived" || !payload.Expires.Equal(tt.expires) {
+				t.Errorf("extracted %q expiring %s, want expiry %s", payload.Data, payload.Expires, tt.expires)
+			}
+		})
+	}
+}
+
+func TestFilenameLimit(t *testing.T) {
+	tests := []struct {
+		name           string
+		filenameLen    int
+		embedLimit     int // 0 keeps the default
+		extractLimit   int
+		wantEmbedErr   bool
+		wantExtractErr bool
+	}{
+		{name: "default limit", filenameLen: DefaultMaxFilenameBytes},
+		{name: "past the default", filenameLen: DefaultMaxFilenameBytes + 1, wantEmbedErr: true},
+		{name: "raised limit", filenameLen: 1000, embedLimit: 1000, extractLimit: 1000},
+		{name: "raised limit, default on extraction", filenameLen: 1000, embedLimit: 1000, wantExtractErr: true},
+		{name: "lowered limit", filenameLen: 50, embedLimit: 50, extractLimit: 50},
+		{name: "past a lowered limit", filenameLen: 51, embedLimit: 50, wantEmbedErr: true},
+		{name: "lowered on extraction only", filenameLen: 51, extractLimit: 50, wantExtractErr: true},
+		{name: "largest limit", filenameLen: MaxFilenameBytes, embedLimit: MaxFilenameBytes, extractLimit: MaxFilenameBytes},
+		{name: "limit out of range", filenameLen: 10, embedLimit: MaxFilenameBytes + 1, wantEmbedErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := strings.Repeat("f", tt.filenameLen)
+			config := models.StegoConfig{Key: "names", LSBBits: 4, SecretFilename: filename, MaxFilenameBytes: tt.embedLimit}
+			codec := newLSBCodec(&config)
+			err := codec.embedPayload(randomCarriers(40000), []byte("named"), nil)
+			if (err != nil) != tt.wantEmbedErr {
+				t.Fatalf("embed: err = %v, want error %v", err, tt.wantEmbedErr)
+			}
+			if err != nil {
+				return
+			}
+
+			extract := models.StegoConfig{Key: "names", LSBBits: 4, MaxFilenameBytes: tt.extractLimit}
+			payload, err := newLSBCodec(&extract).parsePayload(codec.buildPayload([]byte("named"), nil))
+			if (err != nil) != tt.wantExtractErr {
+				t.Fatalf("extract: err = %v, want error %v", err, tt.wantExtractErr)
+			}
+			if err == nil && payload.Filename != filename {
+				t.Errorf("filename is %d bytes, want %d", len(payload.Filename), len(filename))
+			}
+		})
+	}
+}
+
+func TestTruncatedPayload(t *testing.T) {
+	config := models.StegoConfig{Key: "truncated", LSBBits: 1, SecretFilename: "report.pdf"}
+	codec := newLSBCodec(&config)
+	secret := bytes.Repeat([]byte("cut short "), 90)
+	framed := codec.buildPayload(secret, nil)
+
+	tests := []struct {
+		name      string
+		cut       int // Bytes removed from the end of the framed payload
+		available int
+	}{
+		{name: "last byte missing", cut: 1, available: len(secret) - 1},
+		{name: "half the secret", cut: len(secret) / 2, available: len(secret) / 2},
+		{name: "only the header", cut: len(secret), available: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := codec.parsePayload(framed[:len(framed)-tt.cut])
+			var truncated *TruncatedPayloadError
+			if !errors.As(err, &truncated) {
+				t.Fatalf("err = %v, want a TruncatedPayloadError", err)
+			}
+			want := TruncatedPayloadError{Filename: "report.pdf", ExpectedSize: len(secret), Available: tt.available}
+			if *truncated != want {
+				t.Errorf("error = %+v, want %+v", *truncated, want)
+			}
+		})
+	}
+}
+
+func TestOutputLimit(t *testing.T) {
+	secret := bytes.Repeat([]byte("limited "), 4)
+
+	tests := []struct {
+		name         string
+		declared     uint32 // Data length written into the header, 0 keeps the real one
+		maxOutput    int
+		wantDeclared int
+		wantLimit    int
+	}{
+		{name: "within the limit", maxOutput: len(secret)},
+		{name: "past a client limit", maxOutput: len(secret) - 1, wantDeclared: len(secret), wantLimit: len(secret) - 1},
+		// Only a few bytes are present, so a TruncatedPayloadError would
+		// follow if the size were trusted
+		{name: "crafted size past the cap", declared: MaxSecretBytes + 1, wantDeclared: MaxSecretBytes + 1, wantLimit: MaxSecretBytes},
+		{name: "crafted size past a client limit", declared: 0xFFFFFFFF, maxOutput: 1000, wantDeclared: 0xFFFFFFFF, wantLimit: 1000},
+		{name: "client limit above the cap", declared: MaxSecretBytes + 1, maxOutput: 2 * MaxSecretBytes, wantDeclared: MaxSecretBytes + 1, wantLimit: MaxSecretBytes},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := models.StegoConfig{Key: "limit", LSBBits: 1, SecretFilename: "limit.bin", MaxOutputBytes: tt.maxOutput}
+			codec := newLSBCodec(&config)
+			framed := codec.buildPayload(secret, nil)
+			if tt.declared != 0 {
+				// The data length directly precedes the secret
+				binary.BigEndian.PutUint32(framed[len(framed)-len(secret)-4:], tt.declared)
+			}
+
+			payload, err := codec.parsePayload(framed)
+			var limit *OutputLimitError
+			if tt.wantLimit == 0 {
+				if err != nil || !bytes.Equal(payload.Data, secret) {
+					t.Errorf("extracted %q, err = %v", payload.Data, err)
+				}
+				return
+			}
+			if !errors.As(err, &limit) {
+				t.Fatalf("err = %v, want an OutputLimitError", err)
+			}
+			if limit.Declared != tt.wantDeclared || limit.Limit != tt.wantLimit {
+				t.Errorf("error = %+v, want declared %d and limit %d", *limit, tt.wantDeclared, tt.wantLimit)
+			}
+		})
+	}
+}
+
+func TestFileIDRoundTrip(t *testing.T) {
+	cover := loadCover(t, 200)
+	generated, err := NewFileID()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		fileID string
+	}{
+		{name: "none"},
+		{name: "generated", fileID: generated},
+		{name: "caller supplied", fileID: "batch-7/copy-42"},
+		{name: "longest", fileID: strings.Repeat("i", MaxFileIDBytes)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := models.StegoConfig{Key: "tracked", LSBBits: 2, FileID: tt.fileID}
+			method, err := NewMP3Steganography(&config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			stegoData, err := method.EmbedInMP3(cover, []byte("tracked secret"))
+			if err != nil {
+				t.Fatalf("embed: %v", err)
+			}
+
+			payload, err := method.ExtractPayloadFromMP3(stegoData)
+			if err != nil {
+				t.Fatalf("extract: %v", err)
+			}
+			if payload.FileID != tt.fileID || string(payload.Data) != "tracked secret" {
+				t.Errorf("file ID = %q, data = %q, want %q", payload.FileID, payload.Data, tt.fileID)
+			}
+		})
+	}
+
+	if err := ValidateFileID(strings.Repeat("i", MaxFileIDBytes+1)); err == nil {
+		t.Error("expected an oversized file ID to be rejected")
+	}
+	if err := ValidateFileID("has space"); err == nil {
+		t.Error("expected a file ID with a space to be rejected")
+	}
+}
+
+func TestNewFileIDFromSource(t *testing.T) {
+	defer func(source io.Reader) { fileIDSource = source }(fileIDSource)
+
+	seed := bytes.Repeat([]byte{0xA5}, 16)
+	ids := make([]string, 2)
+	for i := range ids {
+		fileIDSource = bytes.NewReader(seed)
+		id, err := NewFileID()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[i] = id
+	}
+	if ids[0] != ids[1] {
+		t.Errorf("IDs from the same source differ: %s and %s", ids[0], ids[1])
+	}
+	if want := "a5a5a5a5-a5a5-45a5-a5a5-a5a5a5a5a5a5"; ids[0] != want {
+		t.Errorf("ID = %s, want %s", ids[0], want)
+	}
+	if err := ValidateFileID(ids[0]); err != nil {
+		t.Errorf("generated ID is invalid: %v", err)
+	}
+
+	fileIDSource = bytes.NewReader(seed[:8])
+	if _, err := NewFileID(); err == nil {
+		t.Error("expected a short source to fail")
+	}
+}
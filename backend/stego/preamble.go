@@ -0,0 +1,263 @@
+package stego
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/scrypt"
+
+	"steganography-backend/models"
+)
+
+// MaxSaltLength is the maximum length of the optional per-file salt
+const MaxSaltLength = 64
+
+// DefaultMarker opens the preamble of every embedded file. Deployments can use
+// their own marker so their files are not mistaken for another tool's.
+const DefaultMarker = "STG1"
+
+// MarkerLength is the fixed size of the preamble marker
+const MarkerLength = 4
+
+// ErrNoPayload is returned when the marker is missing, meaning the file holds
+// no data embedded by this deployment
+var ErrNoPayload = errors.New("no embedded data found")
+
+// ErrKeyMismatch is returned when the key does not match the key check stored
+// in the preamble
+var ErrKeyMismatch = errors.New("key does not match the embedded data")
+
+// Seed hash identifiers stored in the preamble
+const (
+	seedHashIDMD5    byte = 0
+	seedHashIDSHA256 byte = 1
+)
+
+// keyCheckLength is the size of the key check value stored in the preamble
+const keyCheckLength = 4
+
+// preambleFixedBytes is the size of the preamble without the salt
+const preambleFixedBytes = MarkerLength + 6 + keyCheckLength
+
+// preamble is the header written sequentially into the first safe bytes,
+// ahead of the payload. It carries what extraction needs before it can
+// reproduce the payload positions:
+//
+//	marker (4 bytes) | seed hash (1 byte) | domain (4 bytes) | key check (4 bytes) | salt length (1 byte) | salt
+//
+// The encoded preamble is XORed with a mask derived from the key and the
+// marker before it is embedded, so files carry no constant marker that
+// fingerprints them, and with the wrong key the marker does not decode: a
+// wrong key looks exactly like a file without a payload.
+//
+// Decoding the marker still confirms a key guess, so anyone holding a stego
+// file can test keys offline; the key check adds nothing to that. Both the
+// mask and the key check are therefore derived with scrypt, which makes each
+// guess cost tens of milliseconds and a large allocation, but a weak key
+// remains guessable. Use a long random key when that matters.
+//
+// The domain is the number of safe bytes the payload positions were drawn
+// from at embed time. Extraction draws from the same domain, so positions are
+// reproduced even if a later analyzer finds slightly more or fewer safe bytes.
+// The key check is an HMAC of the salt under a key derived from the
+// embedding key, so a wrong key is detected from the preamble alone without
+// reconstructing the payload.
+type preamble struct {
+	Marker   string
+	SeedHash string
+	Domain   int
+	KeyCheck [keyCheckLength]byte
+	Salt     string
+}
+
+// configPreamble returns the preamble describing the codec's own config. The
+// key check is left zero since deriving it is expensive; it only matters for
+// the bytes actually embedded, and the length does not depend on it.
+func (lsb *lsbCodec) configPreamble() preamble {
+	return preamble{
+		Marker:   lsb.marker(),
+		SeedHash: normalizeSeedHash(lsb.config.SeedHash),
+		Salt:     lsb.config.Salt,
+	}
+}
+
+func (lsb *lsbCodec) marker() string {
+	if lsb.config.Marker == "" {
+		return DefaultMarker
+	}
+	return lsb.config.Marker
+}
+
+// scrypt cost parameters for the preamble keys: about 16MB and a few tens of
+// milliseconds per derivation
+const (
+	preambleKDFN = 1 << 14
+	preambleKDFR = 8
+	preambleKDFP = 1
+)
+
+// preambleKeys holds what the key derives for the preamble: the mask it is
+// XORed with and the key its key check is computed under
+type preambleKeys struct {
+	mask     []byte
+	checkKey []byte
+}
+
+// deriveKeys runs scrypt once over the key, salted with the marker so each
+// deployment derives different keys. The per-file salt cannot take part: it
+// is stored under the mask.
+func (lsb *lsbCodec) deriveKeys() (preambleKeys, error) {
+	maskLength := preambleFixedBytes + MaxSaltLength
+	derived, err := scrypt.Key([]byte(lsb.config.Key), []byte("stego-preamble:"+lsb.marker()),
+		preambleKDFN, preambleKDFR, preambleKDFP, maskLength+sha256.Size)
+	if err != nil {
+		return preambleKeys{}, fmt.Errorf("failed to derive preamble keys: %v", err)
+	}
+	return preambleKeys{mask: derived[:maskLength], checkKey: derived[maskLength:]}, nil
+}
+
+// whiten XORs data with the preamble mask; applying it twice restores the data
+func (keys preambleKeys) whiten(data []byte) []byte {
+	whitened := make([]byte, len(data))
+	copy(whitened, data)
+	for i := range min(len(data), len(keys.mask)) {
+		whitened[i] ^= keys.mask[i]
+	}
+	return whitened
+}
+
+// keyCheck derives the key check value stored in the preamble
+func (keys preambleKeys) keyCheck(salt string) [keyCheckLength]byte {
+	mac := hmac.New(sha256.New, keys.checkKey)
+	mac.Write([]byte("key-check:" + salt))
+	var check [keyCheckLength]byte
+	copy(check[:], mac.Sum(nil))
+	return check
+}
+
+func (p preamble) encode() []byte {
+	encoded := make([]byte, 0, preambleFixedBytes+len(p.Salt))
+	encoded = append(encoded, p.Marker...)
+	encoded = append(encoded, seedHashID(p.SeedHash))
+	encoded = binary.BigEndian.AppendUint32(encoded, uint32(p.Domain))
+	encoded = append(encoded, p.KeyCheck[:]...)
+	encoded = append(encoded, byte(len(p.Salt)))
+	encoded = append(encoded, p.Salt...)
+	return encoded
+}
+
+// readPreamble decodes the preamble from the start of the safe bytes and
+// returns it along with how many safe bytes it occupies
+func (lsb *lsbCodec) readPreamble(safeBytes []byte) (preamble, int, error) {
+	if err := ValidateBitOrder(lsb.config.BitOrder); err != nil {
+		return preamble{}, 0, err
+	}
+
+	fixedSafeBytes := lsb.safeBytesNeeded(preambleFixedBytes)
+	if fixedSafeBytes > len(safeBytes) {
+		return preamble{}, 0, fmt.Errorf("insufficient extracted data for preamble")
+	}
+
+	keys, err := lsb.deriveKeys()
+	if err != nil {
+		return preamble{}, 0, err
+	}
+	fixed := keys.whiten(lsb.extractBits(safeBytes, sequentialPositions(0, fixedSafeBytes)))
+	marker := lsb.marker()
+	if string(fixed[:MarkerLength]) != marker {
+		return preamble{}, 0, ErrNoPayload
+	}
+
+	seedHash, err := seedHashFromID(fixed[MarkerLength])
+	if err != nil {
+		return preamble{}, 0, err
+	}
+
+	domain := int(binary.BigEndian.Uint32(fixed[MarkerLength+1 : MarkerLength+5]))
+	var storedKeyCheck [keyCheckLength]byte
+	copy(storedKeyCheck[:], fixed[MarkerLength+5:MarkerLength+5+keyCheckLength])
+	saltLen := int(fixed[preambleFixedBytes-1])
+	if saltLen > MaxSaltLength {
+		return preamble{}, 0, fmt.Errorf("invalid salt length: %d", saltLen)
+	}
+
+	preambleSafeBytes := lsb.safeBytesNeeded(preambleFixedBytes + saltLen)
+	if preambleSafeBytes > len(safeBytes) {
+		return preamble{}, 0, fmt.Errorf("insufficient extracted data for preamble")
+	}
+
+	encoded := keys.whiten(lsb.extractBits(safeBytes, sequentialPositions(0, preambleSafeBytes)))
+	salt := string(encoded[preambleFixedBytes : preambleFixedBytes+saltLen])
+	if keys.keyCheck(salt) != storedKeyCheck {
+		return preamble{}, 0, ErrKeyMismatch
+	}
+
+	return preamble{
+		Marker:   marker,
+		SeedHash: seedHash,
+		Domain:   domain,
+		KeyCheck: storedKeyCheck,
+		Salt:     salt,
+	}, preambleSafeBytes, nil
+}
+
+// checkKey reports whether the key matches the one used for embedding by
+// reading only the preamble, without reconstructing the payload
+func (lsb *lsbCodec) checkKey(safeBytes []byte) error {
+	_, _, err := lsb.readPreamble(safeBytes)
+	return err
+}
+
+// ValidateMarker checks that a custom marker is exactly MarkerLength bytes
+func ValidateMarker(marker string) error {
+	if len(marker) != MarkerLength {
+		return fmt.Errorf("marker must be exactly %d bytes", MarkerLength)
+	}
+	return nil
+}
+
+// ValidateSalt validates the optional per-file salt
+func ValidateSalt(salt string) error {
+	if len(salt) > MaxSaltLength {
+		return fmt.Errorf("salt length cannot exceed %d bytes", MaxSaltLength)
+	}
+	return nil
+}
+
+// ValidateSeedHash validates the seed hash algorithm name
+func ValidateSeedHash(seedHash string) error {
+	switch seedHash {
+	case "", models.SeedHashSHA256, models.SeedHashMD5:
+		return nil
+	default:
+		return fmt.Errorf("unknown seed hash %q, expected %q or %q", seedHash, models.SeedHashSHA256, models.SeedHashMD5)
+	}
+}
+
+func normalizeSeedHash(seedHash string) string {
+	if seedHash == "" {
+		return models.SeedHashSHA256
+	}
+	return seedHash
+}
+
+func seedHashID(seedHash string) byte {
+	if seedHash == models.SeedHashMD5 {
+		return seedHashIDMD5
+	}
+	return seedHashIDSHA256
+}
+
+func seedHashFromID(id byte) (string, error) {
+	switch id {
+	case seedHashIDMD5:
+		return models.SeedHashMD5, nil
+	case seedHashIDSHA256:
+		return models.SeedHashSHA256, nil
+	default:
+		return "", fmt.Errorf("unknown seed hash identifier: %d", id)
+	}
+}
@@ -0,0 +1,158 @@
+package stego
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"steganography-backend/models"
+)
+
+// randomCarriers returns n pseudo-random carrier bytes standing in for the
+// safe bytes of an MP3
+func randomCarriers(n int) []byte {
+	carriers := make([]byte, n)
+	rand.New(rand.NewSource(int64(n))).Read(carriers)
+	return carriers
+}
+
+func TestStoredDomain(t *testing.T) {
+	secret := []byte("pinned domain")
+
+	tests := []struct {
+		name           string
+		useRandomStart bool
+		resize         int // Safe bytes added (or removed) after embedding
+		wantErr        bool
+		wantWarning    bool
+	}{
+		{name: "unchanged", useRandomStart: true},
+		{name: "random start, analyzer finds more", useRandomStart: true, resize: 64, wantWarning: true},
+		{name: "sequential, analyzer finds more", resize: 64, wantWarning: true},
+		{name: "sequential, analyzer finds fewer", resize: -64, wantWarning: true},
+		{name: "random start, domain far past the carriers", useRandomStart: true, resize: -3000, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := models.StegoConfig{Key: "domain", LSBBits: 1, UseRandomStart: tt.useRandomStart}
+			codec := newLSBCodec(&config)
+			carriers := randomCarriers(4000)
+			if err := codec.embedPayload(carriers, secret, nil); err != nil {
+				t.Fatalf("embed: %v", err)
+			}
+
+			if tt.resize > 0 {
+				carriers = append(carriers, randomCarriers(tt.resize)...)
+			} else {
+				carriers = carriers[:len(carriers)+tt.resize]
+			}
+			payload, err := codec.extractPayload(carriers)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("extract: %v", err)
+			}
+			if !bytes.Equal(payload.Data, secret) {
+				t.Errorf("extracted %q, want %q", payload.Data, secret)
+			}
+			warned := len(payload.Warnings) > 0 && strings.Contains(payload.Warnings[0], "modified after embedding")
+			if warned != tt.wantWarning {
+				t.Errorf("warnings = %q, want a modification warning %v", payload.Warnings, tt.wantWarning)
+			}
+		})
+	}
+}
+
+func TestCheckKey(t *testing.T) {
+	config := models.StegoConfig{Key: "right-key", LSBBits: 1, Salt: "salt"}
+	embedded := randomCarriers(4000)
+	if err := newLSBCodec(&config).embedPayload(embedded, []byte("checked"), nil); err != nil {
+		t.Fatal(err)
+	}
+	// The key check follows the marker, seed hash and domain
+	tampered := bytes.Clone(embedded)
+	tampered[(MarkerLength+5)*8] ^= 1
+
+	tests := []struct {
+		name     string
+		carriers []byte
+		key      string
+		marker   string
+		wantErr  error
+	}{
+		{name: "right key", carriers: embedded, key: "right-key"},
+		{name: "wrong key", carriers: embedded, key: "wrong-key", wantErr: ErrNoPayload},
+		{name: "another deployment's marker", carriers: embedded, key: "right-key", marker: "XYZ1", wantErr: ErrNoPayload},
+		{name: "no payload", carriers: randomCarriers(4000), key: "right-key", wantErr: ErrNoPayload},
+		{name: "key check damaged", carriers: tampered, key: "right-key", wantErr: ErrKeyMismatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			check := models.StegoConfig{Key: tt.key, LSBBits: 1, Marker: tt.marker}
+			err := newLSBCodec(&check).checkKey(tt.carriers)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPreambleIsMasked(t *testing.T) {
+	config := models.StegoConfig{Key: "masked", LSBBits: 1}
+	codec := newLSBCodec(&config)
+	carriers := randomCarriers(4000)
+	if err := codec.embedPayload(carriers, []byte("masked"), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	raw := codec.extractBits(carriers, sequentialPositions(0, preambleFixedBytes*8))
+	if bytes.HasPrefix(raw, []byte(DefaultMarker)) {
+		t.Error("the marker is stored in the clear")
+	}
+}
+
+func TestMarkerMismatch(t *testing.T) {
+	tests := []struct {
+		name          string
+		embedMarker   string
+		extractMarker string
+		wantErr       error
+	}{
+		{name: "default marker", embedMarker: "", extractMarker: DefaultMarker},
+		{name: "same custom marker", embedMarker: "ACME", extractMarker: "ACME"},
+		{name: "custom marker, default on extraction", embedMarker: "ACME", extractMarker: "", wantErr: ErrNoPayload},
+		{name: "default marker, custom on extraction", embedMarker: "", extractMarker: "ACME", wantErr: ErrNoPayload},
+		{name: "another custom marker", embedMarker: "ACME", extractMarker: "ACMF", wantErr: ErrNoPayload},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := models.StegoConfig{Key: "namespaced", LSBBits: 2, Marker: tt.embedMarker}
+			carriers := randomCarriers(4000)
+			if err := newLSBCodec(&config).embedPayload(carriers, []byte("namespaced"), nil); err != nil {
+				t.Fatalf("embed: %v", err)
+			}
+
+			extract := models.StegoConfig{Key: "namespaced", LSBBits: 2, Marker: tt.extractMarker}
+			payload, err := newLSBCodec(&extract).extractPayload(carriers)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && string(payload.Data) != "namespaced" {
+				t.Errorf("extracted %q", payload.Data)
+			}
+		})
+	}
+
+	if err := ValidateMarker("TOOLONG"); err == nil {
+		t.Error("expected a marker of the wrong length to be rejected")
+	}
+}
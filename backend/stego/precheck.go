@@ -0,0 +1,65 @@
+package stego
+
+import (
+	"fmt"
+	"math/rand"
+
+	"steganography-backend/models"
+	"steganography-backend/mp3parser"
+)
+
+// PrecheckFrames is how many leading frames the PSNR precheck embeds into,
+// about ten seconds of audio at 44.1 kHz
+const PrecheckFrames = 400
+
+// PrecheckSample cuts the first PrecheckFrames frames of mp3Data into a short
+// cover and embeds into it, with the given config, a stand-in secret that
+// fills the same share of the sample's capacity as secretSize fills of the
+// whole file's. Decoding both and comparing them estimates the PSNR of the
+// real embed without paying for it. The stand-in is random, like an
+// encrypted secret.
+func PrecheckSample(mp3Data []byte, config *models.StegoConfig, secretSize int) ([]byte, []byte, error) {
+	mp3File, err := mp3parser.ParseMP3File(mp3Data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse MP3: %w", err)
+	}
+
+	sample := &mp3parser.MP3File{Frames: mp3File.Frames[:min(len(mp3File.Frames), PrecheckFrames)]}
+	sampleCover, err := mp3parser.WriteMP3File(sample)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to write sample: %v", err)
+	}
+
+	// The sample embed must not be reported as the stages of the real one
+	sampleConfig := *config
+	sampleConfig.StageHook = nil
+	method, err := NewMP3Steganography(&sampleConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+	fullCapacity, err := method.CalculateCapacity(mp3Data)
+	if err != nil {
+		return nil, nil, err
+	}
+	sampleCapacity, err := method.CalculateCapacity(sampleCover)
+	if err != nil {
+		return nil, nil, fmt.Errorf("sample has no capacity: %v", err)
+	}
+
+	// Scale the secret together with the header fields framed around it, then
+	// take those back out since the sample embeds them in full as well
+	overhead := PayloadOverhead(config)
+	framed := overhead.Filename + overhead.Extensions
+	standInSize := (secretSize+framed)*sampleCapacity/max(fullCapacity, 1) - framed
+	standInSize = max(min(standInSize, sampleCapacity-framed), 0)
+
+	standIn := make([]byte, standInSize)
+	rand.New(rand.NewSource(int64(standInSize))).Read(standIn)
+
+	sampleStego, err := method.EmbedInMP3(sampleCover, standIn)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to embed into sample: %v", err)
+	}
+
+	return sampleCover, sampleStego, nil
+}